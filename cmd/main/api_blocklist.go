@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// BlocklistConfig holds settings for blocklisted clients.
+type BlocklistConfig struct {
+	// Stage is the index of the threat stage every blocklisted client gets, whatever its metrics. Its threat level is
+	// pinned to the threat config's max_threat.
+	Stage int `json:"stage"`
+}
+
+// Validate checks that the stage exists, given the number of threat stages.
+func (c *BlocklistConfig) Validate(stages int) error {
+	if c.Stage < 0 || c.Stage >= stages {
+		return fmt.Errorf("stage must be between 0 and %d, got %d", stages-1, c.Stage)
+	}
+	return nil
+}
+
+// setupBlocklistSchema creates the table for storing blocklisted IPs, CIDR ranges and User Agents.
+func setupBlocklistSchema(db *sql.DB) error {
+	return setupClientListSchema(db, "blocklist")
+}
+
+// NewBlocklistCache creates an empty cache for the blocklist.
+func NewBlocklistCache() *ClientListCache {
+	return newClientListCache("blocklist")
+}
+
+// NewBlocklistAPI creates the API that manages the blocklist.
+func NewBlocklistAPI(db *sql.DB, logger *slog.Logger, cache *ClientListCache) *ClientListAPI {
+	return &ClientListAPI{
+		db:     db,
+		logger: logger,
+		cache:  cache,
+		list:   "blocklist",
+	}
+}
+
+// blocklistedThreat returns the threat level and stage for a client, pinned by the blocklist if the client is on it,
+// and otherwise calculated from its metrics and request features.
+func (s *Server) blocklistedThreat(metrics *RequestMetrics, features *RequestFeatures) (threatLevel, threatStage int, blocklisted bool) {
+	if s.blc.Matches(metrics.IPAddress, metrics.UserAgent) {
+		config := s.cm.Get()
+		return config.Threat.MaxThreat, config.Server.Blocklist.Stage, true
+	}
+	threatLevel, threatStage, _ = s.tc.GetThreat(metrics, features)
+	return threatLevel, threatStage, false
+}
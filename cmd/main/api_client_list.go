@@ -0,0 +1,452 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxImportBytes caps the body of a bulk import.
+const maxImportBytes = 10 << 20
+
+// clientListTableSchema is the schema shared by the whitelist and blocklist tables. %s is the table name.
+const clientListTableSchema = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY,
+		type TEXT NOT NULL CHECK(type IN ('ip', 'cidr', 'user_agent', 'user_agent_glob', 'user_agent_regex')),
+		value TEXT NOT NULL UNIQUE
+	);
+	`
+
+// setupClientListSchema creates a client list table, migrating tables from before CIDR and UA pattern entries existed.
+func setupClientListSchema(db *sql.DB, table string) error {
+	schema := fmt.Sprintf(clientListTableSchema, table)
+
+	var existing string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&existing)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && strings.Contains(existing, "'cidr'")) {
+		_, err = db.Exec(schema)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// SQLite can't change a CHECK constraint in place, so the table is rebuilt with the new one.
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, stmt := range []string{
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s_old", table, table),
+		schema,
+		fmt.Sprintf("INSERT INTO %s (id, type, value) SELECT id, type, value FROM %s_old", table, table),
+		fmt.Sprintf("DROP TABLE %s_old", table),
+	} {
+		if _, err = tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to migrate %s table: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ClientListCache holds the entries of a client list table in memory, for matching every request against.
+type ClientListCache struct {
+	mu      sync.RWMutex
+	table   string
+	matcher *clientMatcher
+
+	// goodBots, if set, verifies clients whose UA claims to be a good bot. See Matches.
+	goodBots *GoodBotVerifier
+}
+
+func newClientListCache(table string) *ClientListCache {
+	return &ClientListCache{
+		table:   table,
+		matcher: newClientMatcher(),
+	}
+}
+
+// LoadFromDB reads all entries from the database into the cache.
+func (c *ClientListCache) LoadFromDB(db *sql.DB) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.matcher = newClientMatcher()
+
+	rows, err := db.Query(fmt.Sprintf("SELECT type, value FROM %s", c.table))
+	if err != nil {
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var listType, value string
+		if err = rows.Scan(&listType, &value); err != nil {
+			return err
+		}
+		c.matcher.add(listType, value)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return c.matcher.compilePatterns()
+}
+
+// Check reports whether the entries can be added to the cache, i.e. whether UA globs and regexes would still
+// compile together with them.
+func (c *ClientListCache) Check(listType string, values ...string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.matcher.checkPatterns(listType, values)
+}
+
+// Add safely adds entries to the cache, compiling UA globs and regexes once for the whole batch.
+func (c *ClientListCache) Add(listType string, values ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, value := range values {
+		c.matcher.add(listType, value)
+	}
+	return c.matcher.compilePatterns()
+}
+
+// Remove safely removes a single entry from the cache.
+func (c *ClientListCache) Remove(listType, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.matcher.remove(listType, value)
+}
+
+// SetGoodBotVerifier makes the list match verified good bots, and only them, for UAs claiming to be a good bot.
+func (c *ClientListCache) SetGoodBotVerifier(verifier *GoodBotVerifier) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.goodBots = verifier
+}
+
+// Matches safely checks if an IP or User Agent matches any entry in the cache. With a good bot verifier set, a client
+// claiming to be a good bot matches if it is verified, and UA entries are ignored for it, so they can't be spoofed.
+func (c *ClientListCache) Matches(ip, userAgent string) bool {
+	c.mu.RLock()
+	matchesIP := c.matcher.matchesIP(ip)
+	matchesUserAgent := c.matcher.matchesUserAgent(userAgent)
+	goodBots := c.goodBots
+	c.mu.RUnlock()
+
+	if matchesIP {
+		return true
+	}
+	// Verification can wait on DNS, so it's done without holding the lock.
+	if goodBots != nil {
+		if claimed, verified := goodBots.Verify(ip, userAgent); claimed {
+			return verified
+		}
+	}
+	return matchesUserAgent
+}
+
+// ClientListAPI manages a client list of IPs, CIDR ranges and User Agents, such as the whitelist or the blocklist.
+type ClientListAPI struct {
+	db     *sql.DB
+	logger *slog.Logger
+	cache  *ClientListCache // A pointer to the in-memory cache
+
+	// list is the name of the list, used for its table, endpoints, scopes and messages.
+	list string
+}
+
+// RegisterRoutes sets up the routing for all /api/<list> endpoints.
+func (a *ClientListAPI) RegisterRoutes(mux *http.ServeMux) {
+	for path, listType := range map[string]string{
+		"ip":              listTypeIP,
+		"cidr":            listTypeCIDR,
+		"useragent":       listTypeUserAgent,
+		"useragent_glob":  listTypeUserAgentGlob,
+		"useragent_regex": listTypeUserAgentRegex,
+	} {
+		mux.HandleFunc("/api/"+a.list+"/"+path, a.handleList(listType))
+		mux.HandleFunc("/api/"+a.list+"/"+path+"/import", a.handleImport(listType))
+	}
+}
+
+// handleList is a generic handler for every type of list entry.
+func (a *ClientListAPI) handleList(listType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			a.getList(w, r, listType)
+		case http.MethodPost:
+			a.addToList(w, r, listType)
+		case http.MethodDelete:
+			a.removeFromList(w, r, listType)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// requireScope responds with 403 and returns false unless the request has the list's read or write scope.
+func (a *ClientListAPI) requireScope(w http.ResponseWriter, r *http.Request, access string) bool {
+	scope := a.list + ":" + access
+	if !hasScope(r, scope) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Forbidden: requires '%s' scope", scope))
+		return false
+	}
+	return true
+}
+
+// getList retrieves all entries for a given list type.
+func (a *ClientListAPI) getList(w http.ResponseWriter, r *http.Request, listType string) {
+	if !a.requireScope(w, r, "read") {
+		return
+	}
+
+	rows, err := a.db.Query(fmt.Sprintf("SELECT value FROM %s WHERE type = ?", a.list), listType)
+	if err != nil {
+		a.logger.Error("Failed to query "+a.list, "type", listType, "error", err)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve %s: %v", a.list, err))
+		return
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			a.logger.Error("Failed to scan "+a.list+" value", "error", err)
+			continue
+		}
+		values = append(values, value)
+	}
+
+	respondWithJSON(w, http.StatusOK, values)
+}
+
+// addToList adds a new value to the specified list.
+func (a *ClientListAPI) addToList(w http.ResponseWriter, r *http.Request, listType string) {
+	if !a.requireScope(w, r, "write") {
+		return
+	}
+
+	var payload struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request body")
+		return
+	}
+	value := strings.TrimSpace(payload.Value)
+	if value == "" {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s value cannot be empty", capitalize(a.list)))
+		return
+	}
+	value, err := normalizeListValue(listType, value)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err = a.cache.Check(listType, value); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, err = a.db.Exec(fmt.Sprintf("INSERT INTO %s (type, value) VALUES (?, ?)", a.list), listType, value)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			respondWithError(w, http.StatusConflict, fmt.Sprintf("Value already exists in the %s", a.list))
+		} else {
+			a.logger.Error("Failed to insert into "+a.list, "type", listType, "value", value, "error", err)
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to add value to %s: %v", a.list, err))
+		}
+		return
+	}
+
+	if err = a.cache.Add(listType, value); err != nil {
+		a.logger.Error("Failed to compile "+a.list+" patterns", "error", err)
+	}
+	a.logger.Info("Added value to "+a.list, "type", listType, "value", value)
+	respondWithJSON(w, http.StatusCreated, map[string]string{"message": fmt.Sprintf("Value added to %s", a.list)})
+}
+
+// removeFromList removes a value from the specified list.
+func (a *ClientListAPI) removeFromList(w http.ResponseWriter, r *http.Request, listType string) {
+	if !a.requireScope(w, r, "write") {
+		return
+	}
+
+	var payload struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request body")
+		return
+	}
+	value := strings.TrimSpace(payload.Value)
+	if value == "" {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s value cannot be empty", capitalize(a.list)))
+		return
+	}
+	// Entries stored before validation existed may not normalize, and can still be removed as they are.
+	if normalized, err := normalizeListValue(listType, value); err == nil {
+		value = normalized
+	}
+
+	res, err := a.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE type = ? AND value = ?", a.list), listType, value)
+	if err != nil {
+		a.logger.Error("Failed to delete from "+a.list, "type", listType, "value", value, "error", err)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to remove value from %s: %v", a.list, err))
+		return
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Value not found in the %s", a.list))
+		return
+	}
+
+	if err = a.cache.Remove(listType, value); err != nil {
+		a.logger.Error("Failed to compile "+a.list+" patterns", "error", err)
+	}
+	a.logger.Info("Removed value from "+a.list, "type", listType, "value", value)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": fmt.Sprintf("Value removed from %s", a.list)})
+}
+
+// ImportResult is the response to a bulk import.
+type ImportResult struct {
+	Added   int `json:"added"`
+	Skipped int `json:"skipped"` // Values already in the list.
+
+	// Invalid lists the values that were rejected, with why.
+	Invalid []ImportError `json:"invalid"`
+}
+
+// ImportError is a value rejected by a bulk import.
+type ImportError struct {
+	Value string `json:"value"`
+	Error string `json:"error"`
+}
+
+// handleImport adds many values to a list at once, in a single transaction. The body is either a JSON object with a
+// "values" array, or plain text with one value per line; blank lines and lines starting with "#" are ignored.
+// Invalid values are reported and skipped, rather than failing the whole import.
+func (a *ClientListAPI) handleImport(listType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if !a.requireScope(w, r, "write") {
+			return
+		}
+
+		values, err := readImportValues(w, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result := ImportResult{Invalid: []ImportError{}}
+		var added []string
+		tx, err := a.db.BeginTx(r.Context(), nil)
+		if err != nil {
+			a.logger.Error("Failed to begin "+a.list+" import", "error", err)
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to import into %s: %v", a.list, err))
+			return
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		insert := fmt.Sprintf("INSERT OR IGNORE INTO %s (type, value) VALUES (?, ?)", a.list)
+		for _, value := range values {
+			normalized, err := normalizeListValue(listType, value)
+			if err != nil {
+				result.Invalid = append(result.Invalid, ImportError{Value: value, Error: err.Error()})
+				continue
+			}
+			res, err := tx.ExecContext(r.Context(), insert, listType, normalized)
+			if err != nil {
+				a.logger.Error("Failed to import into "+a.list, "type", listType, "value", normalized, "error", err)
+				respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to import into %s: %v", a.list, err))
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				result.Skipped++
+				continue
+			}
+			added = append(added, normalized)
+		}
+		// Reject the whole import, rather than store patterns that would fail to load on the next start.
+		if err = a.cache.Check(listType, added...); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err = tx.Commit(); err != nil {
+			a.logger.Error("Failed to commit "+a.list+" import", "error", err)
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to import into %s: %v", a.list, err))
+			return
+		}
+
+		if err = a.cache.Add(listType, added...); err != nil {
+			a.logger.Error("Failed to compile "+a.list+" patterns", "error", err)
+		}
+		result.Added = len(added)
+		a.logger.Info("Imported values into "+a.list, "type", listType, "added", result.Added, "skipped", result.Skipped, "invalid", len(result.Invalid))
+		respondWithJSON(w, http.StatusOK, result)
+	}
+}
+
+// readImportValues reads the values of a bulk import body, as JSON or as plain text lines.
+func readImportValues(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var lines []string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var payload struct {
+			Values []string `json:"values"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			return nil, fmt.Errorf("invalid JSON request body")
+		}
+		lines = payload.Values
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		lines = strings.Split(string(body), "\n")
+	}
+
+	values := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		values = append(values, line)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no values to import")
+	}
+	return values, nil
+}
+
+// capitalize upper-cases the first letter of an ASCII string.
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSetupClientListSchema_Migrate(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	// The table as it was before CIDR ranges and UA patterns.
+	for _, stmt := range []string{
+		`CREATE TABLE whitelist (
+			id INTEGER PRIMARY KEY,
+			type TEXT NOT NULL CHECK(type IN ('ip', 'user_agent')),
+			value TEXT NOT NULL UNIQUE
+		)`,
+		"INSERT INTO whitelist (type, value) VALUES ('ip', '198.51.100.1'), ('user_agent', 'ExactBot/1.0')",
+	} {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Running it twice checks that an already migrated table is left alone.
+	for i := 0; i < 2; i++ {
+		if err = setupWhitelistSchema(db); err != nil {
+			t.Fatalf("setupWhitelistSchema (run %d): %v", i+1, err)
+		}
+	}
+
+	testCases := []struct {
+		listType string
+		value    string
+		wantErr  bool
+	}{
+		{listTypeCIDR, "10.0.0.0/8", false},
+		{listTypeUserAgentGlob, "*Pingdom*", false},
+		{listTypeUserAgentRegex, `^Uptime-Kuma/\d+`, false},
+		{"asn", "AS64496", true},
+		{listTypeIP, "198.51.100.1", true}, // Still unique.
+	}
+	for _, tc := range testCases {
+		_, err = db.Exec("INSERT INTO whitelist (type, value) VALUES (?, ?)", tc.listType, tc.value)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("inserting %s %q: got error %v, want error %v", tc.listType, tc.value, err, tc.wantErr)
+		}
+	}
+
+	cache := NewWhitelistCache()
+	if err = cache.LoadFromDB(db); err != nil {
+		t.Fatalf("LoadFromDB: %v", err)
+	}
+	if !cache.Matches("198.51.100.1", "") || !cache.Matches("", "ExactBot/1.0") {
+		t.Error("entries from before the migration were lost")
+	}
+	if !cache.Matches("10.1.2.3", "") || !cache.Matches("", "Uptime-Kuma/1.23") {
+		t.Error("entries added after the migration don't match")
+	}
+
+	var tables int
+	if err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'whitelist_old'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("whitelist_old was not dropped")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// maxCrawlerCatalogBytes caps the size of an uploaded crawler catalogue.
+const maxCrawlerCatalogBytes = 1 << 20
+
+// CrawlerAPI manages the crawler catalogue.
+type CrawlerAPI struct {
+	catalog *CrawlerCatalog
+	logger  *slog.Logger
+}
+
+// NewCrawlerAPI creates a new instance of the CrawlerAPI.
+func NewCrawlerAPI(catalog *CrawlerCatalog, logger *slog.Logger) *CrawlerAPI {
+	return &CrawlerAPI{
+		catalog: catalog,
+		logger:  logger,
+	}
+}
+
+// RegisterRoutes sets up the routing for all /api/crawlers endpoints.
+func (a *CrawlerAPI) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/crawlers", a.handleCatalog)
+	mux.HandleFunc("/api/crawlers/reload", a.handleReload)
+}
+
+// handleCatalog gets or replaces the crawler catalogue.
+func (a *CrawlerAPI) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !hasScope(r, "server:config") {
+			respondWithError(w, http.StatusForbidden, "Forbidden: requires 'server:config' scope")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, a.catalog.Get())
+	case http.MethodPut:
+		if !hasScope(r, "server:config") {
+			respondWithError(w, http.StatusForbidden, "Forbidden: requires 'server:config' scope")
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCrawlerCatalogBytes))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		if err = a.catalog.Replace(data); err != nil {
+			a.logger.Error("Failed to replace crawler catalogue", "error", err)
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Catalogue rejected: %v", err))
+			return
+		}
+		respondWithJSON(w, http.StatusOK, a.catalog.Get())
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleReload re-reads the crawler catalogue file, e.g. after it has been updated on disk.
+func (a *CrawlerAPI) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !hasScope(r, "server:config") {
+		respondWithError(w, http.StatusForbidden, "Forbidden: requires 'server:config' scope")
+		return
+	}
+	if err := a.catalog.Reload(); err != nil {
+		a.logger.Error("Failed to reload crawler catalogue", "error", err)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Catalogue rejected: %v", err))
+		return
+	}
+	respondWithJSON(w, http.StatusOK, a.catalog.Get())
+}
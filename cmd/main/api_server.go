@@ -29,6 +29,16 @@ type VersionInfo struct {
 	BuildDate string `json:"build_date"`
 }
 
+// StageProfile is the effective tarpit configuration for a threat stage, after its overrides
+// have been applied to the global settings.
+type StageProfile struct {
+	Stage     int          `json:"stage"`
+	Name      string       `json:"name"`
+	Enabled   bool         `json:"enabled"`
+	Threshold int          `json:"threshold"`
+	Tarpit    TarpitConfig `json:"tarpit"`
+}
+
 // NewServerAPI creates a new instance of the ServerAPI.
 func NewServerAPI(cm *ConfigManager, actionChan chan string, tm *templating.TemplateManager, logger *slog.Logger) *ServerAPI {
 	return &ServerAPI{
@@ -42,6 +52,7 @@ func NewServerAPI(cm *ConfigManager, actionChan chan string, tm *templating.Temp
 // RegisterRoutes sets up the routing for all /api/server endpoints.
 func (a *ServerAPI) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/server/config", a.handleConfig)
+	mux.HandleFunc("/api/server/config/profiles", a.handleStageProfiles)
 	mux.HandleFunc("/api/server/version", a.handleVersion)
 	mux.HandleFunc("/api/server/shutdown", a.handleShutdown)
 	mux.HandleFunc("/api/server/restart", a.handleRestart)
@@ -91,6 +102,32 @@ func (a *ServerAPI) handleConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleStageProfiles returns the effective tarpit profile of every threat stage.
+func (a *ServerAPI) handleStageProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !hasScope(r, "server:config") {
+		respondWithError(w, http.StatusForbidden, "Forbidden: requires 'server:config' scope")
+		return
+	}
+
+	config := a.cm.Get()
+	profiles := make([]StageProfile, 0, len(config.Threat.Stages))
+	for i, stage := range config.Threat.Stages {
+		profiles = append(profiles, StageProfile{
+			Stage:     i,
+			Name:      stage.Name,
+			Enabled:   stage.Enabled,
+			Threshold: stage.Threshold,
+			Tarpit:    stage.Tarpit.Apply(*config.Server.TarpitConfig),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, profiles)
+}
+
 // handleVersion returns the application's build information.
 func (a *ServerAPI) handleVersion(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
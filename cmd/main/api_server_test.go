@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerAPI_HandleStageProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{
+		"server_config": {"tarpit_config": {"min_drip_feed_delay_ms": 500, "max_drip_feed_delay_ms": 1000}},
+		"threat_config": {"stages": {
+			"stage_1": {"enabled": true, "threshold": 0, "tarpit": {"enable_drip_feed": false}},
+			"stage_5": {"enabled": true, "threshold": 100, "tarpit": {"enable_drip_feed": true, "max_drip_feed_delay_ms": 15000}}
+		}}
+	}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := NewConfigManager(path)
+	if err != nil {
+		t.Fatalf("NewConfigManager: %v", err)
+	}
+	api := NewServerAPI(cm, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Run("Profiles", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/server/config/profiles", nil)
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyPermissions, &Permissions{ScopeSet: map[string]struct{}{"server:config": {}}}))
+		w := httptest.NewRecorder()
+		api.handleStageProfiles(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d, want %d", w.Code, http.StatusOK)
+		}
+		var profiles []StageProfile
+		if err = json.NewDecoder(w.Body).Decode(&profiles); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		if len(profiles) != 5 {
+			t.Fatalf("got %d profiles, want 5", len(profiles))
+		}
+		for i, p := range profiles {
+			if p.Stage != i {
+				t.Errorf("profile %d: got stage %d", i, p.Stage)
+			}
+		}
+		if profiles[0].Tarpit.EnableDripFeed || profiles[0].Tarpit.DripFeedDelayMax != 1000 {
+			t.Errorf("stage 1: got drip feed %v with max delay %d, want false with the global 1000",
+				profiles[0].Tarpit.EnableDripFeed, profiles[0].Tarpit.DripFeedDelayMax)
+		}
+		last := profiles[4]
+		if !last.Enabled || last.Threshold != 100 {
+			t.Errorf("stage 5: got enabled %v, threshold %d", last.Enabled, last.Threshold)
+		}
+		if !last.Tarpit.EnableDripFeed || last.Tarpit.DripFeedDelayMin != 500 || last.Tarpit.DripFeedDelayMax != 15000 {
+			t.Errorf("stage 5: got drip feed %v with delay %d-%d, want true with 500-15000",
+				last.Tarpit.EnableDripFeed, last.Tarpit.DripFeedDelayMin, last.Tarpit.DripFeedDelayMax)
+		}
+	})
+
+	t.Run("MissingScope", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/server/config/profiles", nil)
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyPermissions, &Permissions{ScopeSet: map[string]struct{}{"stats:read": {}}}))
+		w := httptest.NewRecorder()
+		api.handleStageProfiles(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status: got %d, want %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("WrongMethod", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/server/config/profiles", nil)
+		w := httptest.NewRecorder()
+		api.handleStageProfiles(w, r)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status: got %d, want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -4,10 +4,16 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"net/netip"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/amenyxia/Sarracenia/pkg/tarpit"
 )
 
 const statsSchema = `
@@ -15,16 +21,55 @@ CREATE TABLE IF NOT EXISTS stats_ip (
     ip_address    TEXT PRIMARY KEY,
     total_hits    INTEGER NOT NULL DEFAULT 1,
     first_seen    DATETIME NOT NULL,
-    last_seen     DATETIME NOT NULL
+    last_seen     DATETIME NOT NULL,
+    hit_window    BLOB
 );
 CREATE TABLE IF NOT EXISTS stats_user_agent (
     user_agent    TEXT PRIMARY KEY,
     total_hits    INTEGER NOT NULL DEFAULT 1,
     first_seen    DATETIME NOT NULL,
-    last_seen     DATETIME NOT NULL
+    last_seen     DATETIME NOT NULL,
+    hit_window    BLOB
+);
+CREATE TABLE IF NOT EXISTS stats_subnet (
+    subnet        TEXT PRIMARY KEY,
+    total_hits    INTEGER NOT NULL DEFAULT 1,
+    first_seen    DATETIME NOT NULL,
+    last_seen     DATETIME NOT NULL,
+    hit_window    BLOB
+);
+CREATE TABLE IF NOT EXISTS stats_asn (
+    asn           INTEGER PRIMARY KEY,
+    organization  TEXT NOT NULL DEFAULT '',
+    total_hits    INTEGER NOT NULL DEFAULT 1,
+    first_seen    DATETIME NOT NULL,
+    last_seen     DATETIME NOT NULL,
+    hit_window    BLOB
+);
+CREATE TABLE IF NOT EXISTS stats_counters (
+    name          TEXT PRIMARY KEY,
+    value         INTEGER NOT NULL DEFAULT 0
 );
 `
 
+// Names of the global counters kept in stats_counters.
+const (
+	counterBytesBeforeCompression = "bytes_before_compression"
+	counterBytesAfterCompression  = "bytes_after_compression"
+	counterOverLimitServed        = "over_limit_served"
+	counterOverLimitRejected      = "over_limit_rejected"
+
+	counterRequestsForwarded = "requests_forwarded"
+	counterRequestsTarpitted = "requests_tarpitted"
+
+	// Tarpit responses are counted per status code, in counters named e.g. "status_301".
+	counterStatusPrefix = "status_"
+
+	// TCP tarpit connections and the seconds they were held are counted per protocol, e.g. "tcp_connections_ssh".
+	counterTCPConnectionsPrefix = "tcp_connections_"
+	counterTCPHeldSecPrefix     = "tcp_held_sec_"
+)
+
 // RequestMetrics is the data structure returned for a single request.
 type RequestMetrics struct {
 	IPAddress        string        `json:"ip_address"`
@@ -33,6 +78,25 @@ type RequestMetrics struct {
 	UATotalHits      int           `json:"ua_total_hits"`
 	TimeSinceIPFirst time.Duration `json:"time_since_ip_first_seen"`
 	TimeSinceUAFirst time.Duration `json:"time_since_ua_first_seen"`
+
+	// Hit rates over recent windows, including this hit.
+	IPRates WindowRates `json:"ip_rates"`
+	UARates WindowRates `json:"ua_rates"`
+
+	// CrawlerFamily is the ID of the crawler family the user agent belongs to, if it is in the crawler catalogue.
+	CrawlerFamily string `json:"crawler_family"`
+
+	// Subnet is the network the IP is aggregated into, e.g. "203.0.113.0/24", with the hits of every IP in it.
+	Subnet          string      `json:"subnet"`
+	SubnetTotalHits int         `json:"subnet_total_hits"`
+	SubnetRates     WindowRates `json:"subnet_rates"`
+
+	// ASN is the IP's autonomous system, with the hits of every IP in it. It is 0 if there is no ASN database, or the
+	// IP isn't in it.
+	ASN             uint32      `json:"asn"`
+	ASNOrganization string      `json:"asn_organization"`
+	ASNTotalHits    int         `json:"asn_total_hits"`
+	ASNRates        WindowRates `json:"asn_rates"`
 }
 
 // GlobalStatsSummary provides a high-level overview of all collected stats.
@@ -40,6 +104,41 @@ type GlobalStatsSummary struct {
 	TotalRequests    int64 `json:"total_requests"`
 	UniqueIPs        int64 `json:"unique_ips"`
 	UniqueUserAgents int64 `json:"unique_user_agents"`
+
+	// Tarpit response body bytes, as generated and as sent after compression.
+	BytesBeforeCompression int64 `json:"bytes_before_compression"`
+	BytesAfterCompression  int64 `json:"bytes_after_compression"`
+
+	// Requests forwarded to the real site and requests tarpitted, in reverse-proxy mode and through forward-auth.
+	RequestsForwarded int64 `json:"requests_forwarded"`
+	RequestsTarpitted int64 `json:"requests_tarpitted"`
+
+	// Tarpit responses by status code.
+	StatusCodes map[int]int64 `json:"status_codes"`
+
+	// TCP tarpit connections, and the total seconds they were held, by protocol.
+	TCPConnections map[string]int64 `json:"tcp_connections"`
+	TCPHeldSeconds map[string]int64 `json:"tcp_held_seconds"`
+}
+
+// ConnectionStats is a live view of the tarpit connections being held open.
+type ConnectionStats struct {
+	Held   int      `json:"held"`
+	TopIPs []HeldIP `json:"top_ips"`
+
+	// Requests that went over the connection limits since the stats were last reset.
+	OverLimitServed   int64 `json:"over_limit_served"`
+	OverLimitRejected int64 `json:"over_limit_rejected"`
+}
+
+// CrawlerFamilyStats is the combined statistics of every user agent in a crawler family.
+type CrawlerFamilyStats struct {
+	Family     string    `json:"family"`
+	Name       string    `json:"name"`
+	TotalHits  int       `json:"total_hits"`
+	UserAgents int       `json:"user_agents"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
 }
 
 // IPStats holds statistics for a single IP address.
@@ -47,6 +146,7 @@ type IPStats struct {
 	TotalHits int
 	FirstSeen time.Time
 	LastSeen  time.Time
+	window    hitWindow
 }
 
 // UAStats holds statistics for a single user agent.
@@ -54,6 +154,24 @@ type UAStats struct {
 	TotalHits int
 	FirstSeen time.Time
 	LastSeen  time.Time
+	window    hitWindow
+}
+
+// SubnetStats holds statistics for all IPs in a subnet.
+type SubnetStats struct {
+	TotalHits int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	window    hitWindow
+}
+
+// ASNStats holds statistics for all IPs in an autonomous system.
+type ASNStats struct {
+	Organization string
+	TotalHits    int
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	window       hitWindow
 }
 
 // MetricsCache holds statistics in-memory for faster access and no db locking
@@ -61,6 +179,10 @@ type MetricsCache struct {
 	mu             sync.RWMutex
 	ipStats        map[string]*IPStats
 	uaStats        map[string]*UAStats
+	subnetStats    map[string]*SubnetStats
+	asnStats       map[uint32]*ASNStats
+	counters       map[string]int64
+	asn            *ASNDatabase // nil if ASN lookups are disabled.
 	db             *sql.DB
 	logger         *slog.Logger
 	config         *StatsConfig
@@ -71,20 +193,39 @@ type MetricsCache struct {
 
 // StatsAPI holds the dependencies for the statistics handlers.
 type StatsAPI struct {
-	cache  *MetricsCache
-	db     *sql.DB
-	logger *slog.Logger
+	cache       *MetricsCache
+	connections *ConnectionTracker
+	crawlers    *CrawlerCatalog
+	db          *sql.DB
+	logger      *slog.Logger
 }
 
+// setupStatsSchema creates the stats tables, adding the hit_window column to tables from before it existed.
 func setupStatsSchema(db *sql.DB) error {
-	_, err := db.Exec(statsSchema)
-	return err
+	if _, err := db.Exec(statsSchema); err != nil {
+		return err
+	}
+	for _, table := range []string{"stats_ip", "stats_user_agent"} {
+		var columns int
+		err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'hit_window'", table).Scan(&columns)
+		if err != nil {
+			return err
+		}
+		if columns == 0 {
+			if _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN hit_window BLOB", table)); err != nil {
+				return fmt.Errorf("failed to add hit_window to %s: %w", table, err)
+			}
+		}
+	}
+	return nil
 }
 
-func NewStatsAPI(db *sql.DB, logger *slog.Logger) *StatsAPI {
+func NewStatsAPI(db *sql.DB, logger *slog.Logger, connections *ConnectionTracker, crawlers *CrawlerCatalog) *StatsAPI {
 	return &StatsAPI{
-		db:     db,
-		logger: logger,
+		connections: connections,
+		crawlers:    crawlers,
+		db:          db,
+		logger:      logger,
 	}
 }
 
@@ -94,6 +235,9 @@ func (s *StatsAPI) InitializeCache(config *StatsConfig) error {
 	cache := &MetricsCache{
 		ipStats:        make(map[string]*IPStats),
 		uaStats:        make(map[string]*UAStats),
+		subnetStats:    make(map[string]*SubnetStats),
+		asnStats:       make(map[uint32]*ASNStats),
+		counters:       make(map[string]int64),
 		db:             s.db,
 		logger:         s.logger,
 		config:         config,
@@ -101,6 +245,15 @@ func (s *StatsAPI) InitializeCache(config *StatsConfig) error {
 		syncInProgress: false,
 	}
 
+	if config.ASNDatabasePath != "" {
+		asn, err := OpenASNDatabase(config.ASNDatabasePath)
+		if err != nil {
+			return fmt.Errorf("failed to open ASN database: %w", err)
+		}
+		cache.asn = asn
+		s.logger.Info("Loaded ASN database", "path", config.ASNDatabasePath)
+	}
+
 	// Load existing data from database
 	if err := cache.loadFromDB(); err != nil {
 		return fmt.Errorf("failed to load stats from database: %w", err)
@@ -114,26 +267,108 @@ func (s *StatsAPI) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/stats/summary", s.handleSummary)
 	mux.HandleFunc("/api/stats/top_ips", s.handleTopIPs)
 	mux.HandleFunc("/api/stats/top_user_agents", s.handleTopUserAgents)
+	mux.HandleFunc("/api/stats/top_subnets", s.handleTopSubnets)
+	mux.HandleFunc("/api/stats/top_asns", s.handleTopASNs)
+	mux.HandleFunc("/api/stats/connections", s.handleConnections)
+	mux.HandleFunc("/api/stats/crawler_families", s.handleCrawlerFamilies)
 	mux.HandleFunc("/api/stats/all", s.handleResetAll)
 }
 
 // LogAndGetMetrics is the core function called by the tarpit handler.
 // It logs the request and returns up-to-date metrics using the in-memory cache.
 func (s *StatsAPI) LogAndGetMetrics(r *http.Request, ip string) (*RequestMetrics, error) {
-	ua := r.UserAgent()
+	return s.LogAndGetConnMetrics(ip, r.UserAgent()), nil
+}
+
+// LogAndGetConnMetrics does the same as LogAndGetMetrics for connections that aren't HTTP requests, such as those
+// to the TCP tarpits.
+func (s *StatsAPI) LogAndGetConnMetrics(ip, ua string) *RequestMetrics {
 	now := time.Now()
 
 	// Get metrics and also trigger sync if needed
 	metrics := s.cache.GetOrIncrementMetrics(ip, ua, now)
+	metrics.CrawlerFamily = s.crawlers.Classify(ua)
 
 	// Check if sync is needed after updating metrics
 	go s.cache.syncToDBIfDue()
 
-	return metrics, nil
+	return metrics
+}
+
+// RecordResponseBytes adds the body size of a tarpit response, before and after compression, to the global counters.
+func (s *StatsAPI) RecordResponseBytes(before, after int64) {
+	s.cache.AddCounter(counterBytesBeforeCompression, before)
+	s.cache.AddCounter(counterBytesAfterCompression, after)
+}
+
+// RecordDecision counts a request that was either forwarded to the real site or tarpitted. Requests tarpitted only
+// because reverse-proxy mode is off aren't counted.
+func (s *StatsAPI) RecordDecision(_ *http.Request, decision tarpit.Decision) {
+	switch {
+	case !decision.Tarpit:
+		s.cache.AddCounter(counterRequestsForwarded, 1)
+	case decision.Reason != reasonProxyDisabled:
+		s.cache.AddCounter(counterRequestsTarpitted, 1)
+	}
+}
+
+// RecordStatus counts a tarpit response with the given status code.
+func (s *StatsAPI) RecordStatus(statusCode int) {
+	s.cache.AddCounter(counterStatusPrefix+strconv.Itoa(statusCode), 1)
+}
+
+// RecordTCPConnection counts a closed TCP tarpit connection, and how long it was held.
+func (s *StatsAPI) RecordTCPConnection(protocol string, held time.Duration) {
+	s.cache.AddCounter(counterTCPConnectionsPrefix+protocol, 1)
+	s.cache.AddCounter(counterTCPHeldSecPrefix+protocol, int64(held.Seconds()))
+}
+
+// RecordOverLimit counts a request that went over the connection limits, and whether it was rejected or served
+// without delay.
+func (s *StatsAPI) RecordOverLimit(rejected bool) {
+	if rejected {
+		s.cache.AddCounter(counterOverLimitRejected, 1)
+	} else {
+		s.cache.AddCounter(counterOverLimitServed, 1)
+	}
+}
+
+// AddCounter adds delta to a named global counter.
+func (c *MetricsCache) AddCounter(name string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters[name] += delta
 }
 
-// GetOrIncrementMetrics gets the current stats for an IP and UA, and increments their hit counts in memory.
+// subnetOf returns the subnet an IP is aggregated into, or "" if it isn't a valid IP.
+func subnetOf(ip string, prefixV4, prefixV6 int) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := prefixV6
+	if addr.Is4() {
+		bits = prefixV4
+	}
+	prefix, err := addr.WithZone("").Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// GetOrIncrementMetrics gets the current stats for an IP and UA, and for the IP's subnet and AS, and increments their
+// hit counts in memory.
 func (c *MetricsCache) GetOrIncrementMetrics(ip, ua string, accessTime time.Time) *RequestMetrics {
+	// The lookups are done before locking, as they don't touch the cache.
+	subnet := subnetOf(ip, c.config.SubnetPrefixV4, c.config.SubnetPrefixV6)
+	var asn uint32
+	var organization string
+	if c.asn != nil {
+		asn, organization, _ = c.asn.Lookup(ip)
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -151,6 +386,7 @@ func (c *MetricsCache) GetOrIncrementMetrics(ip, ua string, accessTime time.Time
 		ipStats.TotalHits++
 		ipStats.LastSeen = accessTime
 	}
+	ipStats.window.add(accessTime)
 
 	// Get or create UA stats
 	uaStats, exists := c.uaStats[ua]
@@ -166,22 +402,56 @@ func (c *MetricsCache) GetOrIncrementMetrics(ip, ua string, accessTime time.Time
 		uaStats.TotalHits++
 		uaStats.LastSeen = accessTime
 	}
+	uaStats.window.add(accessTime)
 
-	// Return metrics
-	return &RequestMetrics{
+	metrics := &RequestMetrics{
 		IPAddress:        ip,
 		UserAgent:        ua,
 		IPTotalHits:      ipStats.TotalHits,
 		UATotalHits:      uaStats.TotalHits,
 		TimeSinceIPFirst: accessTime.Sub(ipStats.FirstSeen),
 		TimeSinceUAFirst: accessTime.Sub(uaStats.FirstSeen),
+		IPRates:          ipStats.window.rates(accessTime),
+		UARates:          uaStats.window.rates(accessTime),
 	}
+
+	if subnet != "" {
+		subnetStats, exists := c.subnetStats[subnet]
+		if !exists {
+			subnetStats = &SubnetStats{FirstSeen: accessTime}
+			c.subnetStats[subnet] = subnetStats
+		}
+		subnetStats.TotalHits++
+		subnetStats.LastSeen = accessTime
+		subnetStats.window.add(accessTime)
+		metrics.Subnet = subnet
+		metrics.SubnetTotalHits = subnetStats.TotalHits
+		metrics.SubnetRates = subnetStats.window.rates(accessTime)
+	}
+
+	if asn != 0 {
+		asnStats, exists := c.asnStats[asn]
+		if !exists {
+			asnStats = &ASNStats{FirstSeen: accessTime}
+			c.asnStats[asn] = asnStats
+		}
+		asnStats.Organization = organization
+		asnStats.TotalHits++
+		asnStats.LastSeen = accessTime
+		asnStats.window.add(accessTime)
+		metrics.ASN = asn
+		metrics.ASNOrganization = organization
+		metrics.ASNTotalHits = asnStats.TotalHits
+		metrics.ASNRates = asnStats.window.rates(accessTime)
+	}
+
+	return metrics
 }
 
 // loadFromDB loads existing stats from the database into memory.
 func (c *MetricsCache) loadFromDB() error {
 	// Load IP stats
-	rows, err := c.db.Query("SELECT ip_address, total_hits, first_seen, last_seen FROM stats_ip")
+	rows, err := c.db.Query("SELECT ip_address, total_hits, first_seen, last_seen, hit_window FROM stats_ip")
 	if err != nil {
 		return fmt.Errorf("failed to query IP stats: %w", err)
 	}
@@ -193,18 +463,20 @@ func (c *MetricsCache) loadFromDB() error {
 		var ip string
 		var hits int
 		var firstSeen, lastSeen time.Time
-		if err = rows.Scan(&ip, &hits, &firstSeen, &lastSeen); err != nil {
+		var window []byte
+		if err = rows.Scan(&ip, &hits, &firstSeen, &lastSeen, &window); err != nil {
 			return fmt.Errorf("failed to scan IP stats: %w", err)
 		}
 		c.ipStats[ip] = &IPStats{
 			TotalHits: hits,
 			FirstSeen: firstSeen,
 			LastSeen:  lastSeen,
+			window:    decodeHitWindow(window),
 		}
 	}
 
 	// Load User Agent stats
-	rows, err = c.db.Query("SELECT user_agent, total_hits, first_seen, last_seen FROM stats_user_agent")
+	rows, err = c.db.Query("SELECT user_agent, total_hits, first_seen, last_seen, hit_window FROM stats_user_agent")
 	if err != nil {
 		return fmt.Errorf("failed to query User Agent stats: %w", err)
 	}
@@ -216,16 +488,88 @@ func (c *MetricsCache) loadFromDB() error {
 		var ua string
 		var hits int
 		var firstSeen, lastSeen time.Time
-		if err = rows.Scan(&ua, &hits, &firstSeen, &lastSeen); err != nil {
+		var window []byte
+		if err = rows.Scan(&ua, &hits, &firstSeen, &lastSeen, &window); err != nil {
 			return fmt.Errorf("failed to scan User Agent stats: %w", err)
 		}
 		c.uaStats[ua] = &UAStats{
 			TotalHits: hits,
 			FirstSeen: firstSeen,
 			LastSeen:  lastSeen,
+			window:    decodeHitWindow(window),
+		}
+	}
+
+	// Load subnet stats
+	rows, err = c.db.Query("SELECT subnet, total_hits, first_seen, last_seen, hit_window FROM stats_subnet")
+	if err != nil {
+		return fmt.Errorf("failed to query subnet stats: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var subnet string
+		var hits int
+		var firstSeen, lastSeen time.Time
+		var window []byte
+		if err = rows.Scan(&subnet, &hits, &firstSeen, &lastSeen, &window); err != nil {
+			return fmt.Errorf("failed to scan subnet stats: %w", err)
+		}
+		c.subnetStats[subnet] = &SubnetStats{
+			TotalHits: hits,
+			FirstSeen: firstSeen,
+			LastSeen:  lastSeen,
+			window:    decodeHitWindow(window),
 		}
 	}
 
+	// Load ASN stats
+	rows, err = c.db.Query("SELECT asn, organization, total_hits, first_seen, last_seen, hit_window FROM stats_asn")
+	if err != nil {
+		return fmt.Errorf("failed to query ASN stats: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var asn uint32
+		var organization string
+		var hits int
+		var firstSeen, lastSeen time.Time
+		var window []byte
+		if err = rows.Scan(&asn, &organization, &hits, &firstSeen, &lastSeen, &window); err != nil {
+			return fmt.Errorf("failed to scan ASN stats: %w", err)
+		}
+		c.asnStats[asn] = &ASNStats{
+			Organization: organization,
+			TotalHits:    hits,
+			FirstSeen:    firstSeen,
+			LastSeen:     lastSeen,
+			window:       decodeHitWindow(window),
+		}
+	}
+
+	// Load global counters
+	rows, err = c.db.Query("SELECT name, value FROM stats_counters")
+	if err != nil {
+		return fmt.Errorf("failed to query counters: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var name string
+		var value int64
+		if err = rows.Scan(&name, &value); err != nil {
+			return fmt.Errorf("failed to scan counters: %w", err)
+		}
+		c.counters[name] = value
+	}
+
 	return nil
 }
 
@@ -264,6 +608,7 @@ func (c *MetricsCache) syncDB() {
 			TotalHits: v.TotalHits,
 			FirstSeen: v.FirstSeen,
 			LastSeen:  v.LastSeen,
+			window:    v.window,
 		}
 	}
 	for k, v := range c.uaStats {
@@ -271,8 +616,18 @@ func (c *MetricsCache) syncDB() {
 			TotalHits: v.TotalHits,
 			FirstSeen: v.FirstSeen,
 			LastSeen:  v.LastSeen,
+			window:    v.window,
 		}
 	}
+	subnetCopy := make(map[string]SubnetStats, len(c.subnetStats))
+	for k, v := range c.subnetStats {
+		subnetCopy[k] = *v
+	}
+	asnCopy := make(map[uint32]ASNStats, len(c.asnStats))
+	for k, v := range c.asnStats {
+		asnCopy[k] = *v
+	}
+	countersCopy := maps.Clone(c.counters)
 	c.mu.RUnlock()
 
 	// Perform database operations without holding the cache lock
@@ -289,10 +644,11 @@ func (c *MetricsCache) syncDB() {
 
 	// Batch upsert IP stats
 	for ip, stats := range ipCopy {
+		window := stats.window.encode(c.lastSyncTime)
 		_, err = tx.Exec(`
-			INSERT INTO stats_ip (ip_address, total_hits, first_seen, last_seen) VALUES (?, ?, ?, ?)
-			ON CONFLICT(ip_address) DO UPDATE SET total_hits = ?, last_seen = ?
-		`, ip, stats.TotalHits, stats.FirstSeen, stats.LastSeen, stats.TotalHits, stats.LastSeen)
+			INSERT INTO stats_ip (ip_address, total_hits, first_seen, last_seen, hit_window) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(ip_address) DO UPDATE SET total_hits = ?, last_seen = ?, hit_window = ?
+		`, ip, stats.TotalHits, stats.FirstSeen, stats.LastSeen, window, stats.TotalHits, stats.LastSeen, window)
 		if err != nil {
 			c.logger.Error("Failed to sync IP stats to DB", "ip", ip, "error", err)
 		}
@@ -300,15 +656,51 @@ func (c *MetricsCache) syncDB() {
 
 	// Batch upsert User Agent stats
 	for ua, stats := range uaCopy {
+		window := stats.window.encode(c.lastSyncTime)
 		_, err = tx.Exec(`
-			INSERT INTO stats_user_agent (user_agent, total_hits, first_seen, last_seen) VALUES (?, ?, ?, ?)
-			ON CONFLICT(user_agent) DO UPDATE SET total_hits = ?, last_seen = ?
-		`, ua, stats.TotalHits, stats.FirstSeen, stats.LastSeen, stats.TotalHits, stats.LastSeen)
+			INSERT INTO stats_user_agent (user_agent, total_hits, first_seen, last_seen, hit_window) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(user_agent) DO UPDATE SET total_hits = ?, last_seen = ?, hit_window = ?
+		`, ua, stats.TotalHits, stats.FirstSeen, stats.LastSeen, window, stats.TotalHits, stats.LastSeen, window)
 		if err != nil {
 			c.logger.Error("Failed to sync User Agent stats to DB", "user_agent", ua, "error", err)
 		}
 	}
 
+	// Batch upsert subnet stats
+	for subnet, stats := range subnetCopy {
+		window := stats.window.encode(c.lastSyncTime)
+		_, err = tx.Exec(`
+			INSERT INTO stats_subnet (subnet, total_hits, first_seen, last_seen, hit_window) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(subnet) DO UPDATE SET total_hits = ?, last_seen = ?, hit_window = ?
+		`, subnet, stats.TotalHits, stats.FirstSeen, stats.LastSeen, window, stats.TotalHits, stats.LastSeen, window)
+		if err != nil {
+			c.logger.Error("Failed to sync subnet stats to DB", "subnet", subnet, "error", err)
+		}
+	}
+
+	// Batch upsert ASN stats
+	for asn, stats := range asnCopy {
+		window := stats.window.encode(c.lastSyncTime)
+		_, err = tx.Exec(`
+			INSERT INTO stats_asn (asn, organization, total_hits, first_seen, last_seen, hit_window) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(asn) DO UPDATE SET organization = ?, total_hits = ?, last_seen = ?, hit_window = ?
+		`, asn, stats.Organization, stats.TotalHits, stats.FirstSeen, stats.LastSeen, window, stats.Organization, stats.TotalHits, stats.LastSeen, window)
+		if err != nil {
+			c.logger.Error("Failed to sync ASN stats to DB", "asn", asn, "error", err)
+		}
+	}
+
+	// Batch upsert global counters
+	for name, value := range countersCopy {
+		_, err = tx.Exec(`
+			INSERT INTO stats_counters (name, value) VALUES (?, ?)
+			ON CONFLICT(name) DO UPDATE SET value = ?
+		`, name, value, value)
+		if err != nil {
+			c.logger.Error("Failed to sync counter to DB", "counter", name, "error", err)
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		c.logger.Error("Failed to commit sync transaction", "error", err)
 		// If sync fails, reset lastSyncTime to allow another attempt soon
@@ -316,7 +708,7 @@ func (c *MetricsCache) syncDB() {
 		return
 	}
 
-	c.logger.Debug("Stats sync completed", "entries_synced", len(ipCopy)+len(uaCopy))
+	c.logger.Debug("Stats sync completed", "entries_synced", len(ipCopy)+len(uaCopy)+len(subnetCopy)+len(asnCopy))
 
 	// Also run cleanup after successful sync
 	c.cleanupOldEntries()
@@ -359,6 +751,26 @@ func (c *MetricsCache) cleanupOldEntries() {
 			}
 		}
 	}
+
+	// Check subnets for cleanup
+	for subnet, stats := range c.subnetStats {
+		if stats.TotalHits < c.config.ForgetThreshold && now.Sub(stats.LastSeen) > maxAge {
+			delete(c.subnetStats, subnet)
+			if _, err := c.db.Exec("DELETE FROM stats_subnet WHERE subnet = ?", subnet); err != nil {
+				c.logger.Error("Failed to delete old subnet entry from DB", "subnet", subnet, "error", err)
+			}
+		}
+	}
+
+	// Check ASNs for cleanup
+	for asn, stats := range c.asnStats {
+		if stats.TotalHits < c.config.ForgetThreshold && now.Sub(stats.LastSeen) > maxAge {
+			delete(c.asnStats, asn)
+			if _, err := c.db.Exec("DELETE FROM stats_asn WHERE asn = ?", asn); err != nil {
+				c.logger.Error("Failed to delete old ASN entry from DB", "asn", asn, "error", err)
+			}
+		}
+	}
 }
 
 func (s *StatsAPI) handleSummary(w http.ResponseWriter, r *http.Request) {
@@ -376,12 +788,26 @@ func (s *StatsAPI) handleSummary(w http.ResponseWriter, r *http.Request) {
 		}
 		uniqueIPs := len(s.cache.ipStats)
 		uniqueUserAgents := len(s.cache.uaStats)
+		bytesBefore := s.cache.counters[counterBytesBeforeCompression]
+		bytesAfter := s.cache.counters[counterBytesAfterCompression]
+		statusCodes := statusCodeCounts(s.cache.counters)
+		tcpConnections := prefixedCounts(s.cache.counters, counterTCPConnectionsPrefix)
+		tcpHeldSeconds := prefixedCounts(s.cache.counters, counterTCPHeldSecPrefix)
+		forwarded := s.cache.counters[counterRequestsForwarded]
+		tarpitted := s.cache.counters[counterRequestsTarpitted]
 		s.cache.mu.RUnlock()
 
 		summary := GlobalStatsSummary{
-			TotalRequests:    int64(totalRequests),
-			UniqueIPs:        int64(uniqueIPs),
-			UniqueUserAgents: int64(uniqueUserAgents),
+			TotalRequests:          int64(totalRequests),
+			UniqueIPs:              int64(uniqueIPs),
+			UniqueUserAgents:       int64(uniqueUserAgents),
+			BytesBeforeCompression: bytesBefore,
+			BytesAfterCompression:  bytesAfter,
+			RequestsForwarded:      forwarded,
+			RequestsTarpitted:      tarpitted,
+			StatusCodes:            statusCodes,
+			TCPConnections:         tcpConnections,
+			TCPHeldSeconds:         tcpHeldSeconds,
 		}
 		respondWithJSON(w, http.StatusOK, summary)
 	} else {
@@ -390,10 +816,130 @@ func (s *StatsAPI) handleSummary(w http.ResponseWriter, r *http.Request) {
 		_ = s.db.QueryRowContext(r.Context(), "SELECT COALESCE(SUM(total_hits), 0) FROM stats_ip").Scan(&summary.TotalRequests)
 		_ = s.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM stats_ip").Scan(&summary.UniqueIPs)
 		_ = s.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM stats_user_agent").Scan(&summary.UniqueUserAgents)
+		_ = s.db.QueryRowContext(r.Context(), "SELECT COALESCE(SUM(value), 0) FROM stats_counters WHERE name = ?", counterBytesBeforeCompression).Scan(&summary.BytesBeforeCompression)
+		_ = s.db.QueryRowContext(r.Context(), "SELECT COALESCE(SUM(value), 0) FROM stats_counters WHERE name = ?", counterBytesAfterCompression).Scan(&summary.BytesAfterCompression)
+		_ = s.db.QueryRowContext(r.Context(), "SELECT COALESCE(SUM(value), 0) FROM stats_counters WHERE name = ?", counterRequestsForwarded).Scan(&summary.RequestsForwarded)
+		_ = s.db.QueryRowContext(r.Context(), "SELECT COALESCE(SUM(value), 0) FROM stats_counters WHERE name = ?", counterRequestsTarpitted).Scan(&summary.RequestsTarpitted)
+		counters := make(map[string]int64)
+		if rows, err := s.db.QueryContext(r.Context(), "SELECT name, value FROM stats_counters WHERE name LIKE ? OR name LIKE ?", counterStatusPrefix+"%", "tcp_%"); err == nil {
+			for rows.Next() {
+				var name string
+				var value int64
+				if rows.Scan(&name, &value) == nil {
+					counters[name] = value
+				}
+			}
+			_ = rows.Close()
+		}
+		summary.StatusCodes = statusCodeCounts(counters)
+		summary.TCPConnections = prefixedCounts(counters, counterTCPConnectionsPrefix)
+		summary.TCPHeldSeconds = prefixedCounts(counters, counterTCPHeldSecPrefix)
 		respondWithJSON(w, http.StatusOK, summary)
 	}
 }
 
+// statusCodeCounts picks the per-status code counts out of the global counters.
+func statusCodeCounts(counters map[string]int64) map[int]int64 {
+	result := make(map[int]int64)
+	for name, value := range counters {
+		if code, ok := strings.CutPrefix(name, counterStatusPrefix); ok {
+			if statusCode, err := strconv.Atoi(code); err == nil {
+				result[statusCode] = value
+			}
+		}
+	}
+	return result
+}
+
+// prefixedCounts picks the counters whose names start with prefix out of the global counters, keyed by the rest of
+// their name.
+func prefixedCounts(counters map[string]int64, prefix string) map[string]int64 {
+	result := make(map[string]int64)
+	for name, value := range counters {
+		if key, ok := strings.CutPrefix(name, prefix); ok {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// handleConnections returns the number of held tarpit connections, the IPs holding the most, and how many requests
+// went over the connection limits.
+func (s *StatsAPI) handleConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !hasScope(r, "stats:read") {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	var stats ConnectionStats
+	stats.Held, stats.TopIPs = s.connections.Snapshot(100)
+	s.cache.mu.RLock()
+	stats.OverLimitServed = s.cache.counters[counterOverLimitServed]
+	stats.OverLimitRejected = s.cache.counters[counterOverLimitRejected]
+	s.cache.mu.RUnlock()
+	respondWithJSON(w, http.StatusOK, stats)
+}
+
+// handleCrawlerFamilies returns the hits of every crawler family in the catalogue that has been seen, combined over all
+// of its user agents. User agents are classified with the current catalogue, so an updated catalogue applies to the
+// hits already recorded as well.
+func (s *StatsAPI) handleCrawlerFamilies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !hasScope(r, "stats:read") {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	s.cache.mu.RLock()
+	uaCopy := make(map[string]UAStats, len(s.cache.uaStats))
+	for ua, stats := range s.cache.uaStats {
+		uaCopy[ua] = *stats
+	}
+	s.cache.mu.RUnlock()
+
+	byFamily := make(map[string]*CrawlerFamilyStats)
+	for ua, stats := range uaCopy {
+		id := s.crawlers.Classify(ua)
+		if id == "" {
+			continue
+		}
+		family, ok := byFamily[id]
+		if !ok {
+			family = &CrawlerFamilyStats{Family: id, FirstSeen: stats.FirstSeen, LastSeen: stats.LastSeen}
+			byFamily[id] = family
+		}
+		family.TotalHits += stats.TotalHits
+		family.UserAgents++
+		if stats.FirstSeen.Before(family.FirstSeen) {
+			family.FirstSeen = stats.FirstSeen
+		}
+		if stats.LastSeen.After(family.LastSeen) {
+			family.LastSeen = stats.LastSeen
+		}
+	}
+
+	results := make([]CrawlerFamilyStats, 0, len(byFamily))
+	for _, family := range s.crawlers.Get().Families {
+		if stats, ok := byFamily[family.ID]; ok {
+			stats.Name = family.Name
+			results = append(results, *stats)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].TotalHits > results[j].TotalHits
+	})
+	respondWithJSON(w, http.StatusOK, results)
+}
+
 func (s *StatsAPI) handleTopIPs(w http.ResponseWriter, r *http.Request) {
 	if !hasScope(r, "stats:read") {
 		respondWithError(w, http.StatusForbidden, "Forbidden")
@@ -524,6 +1070,139 @@ func (s *StatsAPI) handleTopUserAgents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *StatsAPI) handleTopSubnets(w http.ResponseWriter, r *http.Request) {
+	if !hasScope(r, "stats:read") {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	// Use the cache if available, otherwise fall back to database
+	if s.cache != nil {
+		s.cache.mu.RLock()
+
+		// Convert map to slice and sort by hits
+		var results []map[string]any
+		for subnet, stats := range s.cache.subnetStats {
+			results = append(results, map[string]any{
+				"subnet":     subnet,
+				"total_hits": stats.TotalHits,
+				"first_seen": stats.FirstSeen,
+				"last_seen":  stats.LastSeen,
+			})
+		}
+		s.cache.mu.RUnlock()
+
+		// Sort by hits descending
+		sort.Slice(results, func(i, j int) bool {
+			return results[i]["total_hits"].(int) > results[j]["total_hits"].(int)
+		})
+
+		// Limit to 100 results
+		if len(results) > 100 {
+			results = results[:100]
+		}
+
+		respondWithJSON(w, http.StatusOK, results)
+	} else {
+		// Fallback to database query
+		rows, err := s.db.QueryContext(r.Context(), "SELECT subnet, total_hits, first_seen, last_seen FROM stats_subnet ORDER BY total_hits DESC LIMIT 100")
+		if err != nil {
+			s.logger.Error("Failed to query top subnets", "error", err)
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		defer func(rows *sql.Rows) {
+			_ = rows.Close()
+		}(rows)
+
+		var results []map[string]any
+		for rows.Next() {
+			var subnet string
+			var hits int
+			var first, last time.Time
+			err = rows.Scan(&subnet, &hits, &first, &last)
+			if err != nil {
+				s.logger.Error("Failed to scan top subnets", "error", err)
+			}
+			results = append(results, map[string]any{
+				"subnet":     subnet,
+				"total_hits": hits,
+				"first_seen": first,
+				"last_seen":  last,
+			})
+		}
+		respondWithJSON(w, http.StatusOK, results)
+	}
+}
+
+func (s *StatsAPI) handleTopASNs(w http.ResponseWriter, r *http.Request) {
+	if !hasScope(r, "stats:read") {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	// Use the cache if available, otherwise fall back to database
+	if s.cache != nil {
+		s.cache.mu.RLock()
+
+		// Convert map to slice and sort by hits
+		var results []map[string]any
+		for asn, stats := range s.cache.asnStats {
+			results = append(results, map[string]any{
+				"asn":          asn,
+				"organization": stats.Organization,
+				"total_hits":   stats.TotalHits,
+				"first_seen":   stats.FirstSeen,
+				"last_seen":    stats.LastSeen,
+			})
+		}
+		s.cache.mu.RUnlock()
+
+		// Sort by hits descending
+		sort.Slice(results, func(i, j int) bool {
+			return results[i]["total_hits"].(int) > results[j]["total_hits"].(int)
+		})
+
+		// Limit to 100 results
+		if len(results) > 100 {
+			results = results[:100]
+		}
+
+		respondWithJSON(w, http.StatusOK, results)
+	} else {
+		// Fallback to database query
+		rows, err := s.db.QueryContext(r.Context(), "SELECT asn, organization, total_hits, first_seen, last_seen FROM stats_asn ORDER BY total_hits DESC LIMIT 100")
+		if err != nil {
+			s.logger.Error("Failed to query top ASNs", "error", err)
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		defer func(rows *sql.Rows) {
+			_ = rows.Close()
+		}(rows)
+
+		var results []map[string]any
+		for rows.Next() {
+			var asn uint32
+			var organization string
+			var hits int
+			var first, last time.Time
+			err = rows.Scan(&asn, &organization, &hits, &first, &last)
+			if err != nil {
+				s.logger.Error("Failed to scan top ASNs", "error", err)
+			}
+			results = append(results, map[string]any{
+				"asn":          asn,
+				"organization": organization,
+				"total_hits":   hits,
+				"first_seen":   first,
+				"last_seen":    last,
+			})
+		}
+		respondWithJSON(w, http.StatusOK, results)
+	}
+}
+
 // handleResetAll clears all statistics from the database and cache.
 func (s *StatsAPI) handleResetAll(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
@@ -556,6 +1235,22 @@ func (s *StatsAPI) handleResetAll(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Failed to reset User Agent statistics")
 		return
 	}
+	if _, err = tx.ExecContext(r.Context(), "DELETE FROM stats_subnet"); err != nil {
+		s.logger.Error("Failed to delete from stats_subnet", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset subnet statistics")
+		return
+	}
+	if _, err = tx.ExecContext(r.Context(), "DELETE FROM stats_asn"); err != nil {
+		s.logger.Error("Failed to delete from stats_asn", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset ASN statistics")
+		return
+	}
+
+	if _, err = tx.ExecContext(r.Context(), "DELETE FROM stats_counters"); err != nil {
+		s.logger.Error("Failed to delete from stats_counters", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset counters")
+		return
+	}
 
 	if err = tx.Commit(); err != nil {
 		s.logger.Error("Failed to commit transaction for stats reset", "error", err)
@@ -568,6 +1263,9 @@ func (s *StatsAPI) handleResetAll(w http.ResponseWriter, r *http.Request) {
 		s.cache.mu.Lock()
 		s.cache.ipStats = make(map[string]*IPStats)
 		s.cache.uaStats = make(map[string]*UAStats)
+		s.cache.subnetStats = make(map[string]*SubnetStats)
+		s.cache.asnStats = make(map[uint32]*ASNStats)
+		s.cache.counters = make(map[string]int64)
 		s.cache.mu.Unlock()
 	}
 
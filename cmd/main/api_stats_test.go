@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubnetOf(t *testing.T) {
+	testCases := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.77", "203.0.113.0/24"},
+		{"::ffff:203.0.113.77", "203.0.113.0/24"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"fe80::1%eth0", "fe80::/64"},
+		{"not an ip", ""},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			if got := subnetOf(tc.ip, 24, 64); got != tc.want {
+				t.Errorf("subnetOf(%q): got %q, want %q", tc.ip, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMetricsCache_GetOrIncrementMetrics(t *testing.T) {
+	data, networks := testASNData()
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	if err := os.WriteFile(path, buildMMDB(24, 6, data, networks), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	asn, err := OpenASNDatabase(path)
+	if err != nil {
+		t.Fatalf("OpenASNDatabase: %v", err)
+	}
+	config := *DefaultServerConfig().StatsConfig
+	c := &MetricsCache{
+		ipStats:     make(map[string]*IPStats),
+		uaStats:     make(map[string]*UAStats),
+		subnetStats: make(map[string]*SubnetStats),
+		asnStats:    make(map[uint32]*ASNStats),
+		asn:         asn,
+		config:      &config,
+	}
+
+	// A scraper rotating through one network: every address is new, but the subnet and AS add up.
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var metrics *RequestMetrics
+	for i, ip := range []string{"1.2.3.1", "1.2.3.2", "1.2.3.3", "198.51.100.1"} {
+		metrics = c.GetOrIncrementMetrics(ip, "scraper", now.Add(time.Duration(i)*time.Second))
+	}
+	if metrics.IPTotalHits != 1 || metrics.UATotalHits != 4 {
+		t.Errorf("IP and UA hits: got %d and %d, want 1 and 4", metrics.IPTotalHits, metrics.UATotalHits)
+	}
+	if metrics.Subnet != "198.51.100.0/24" || metrics.SubnetTotalHits != 1 {
+		t.Errorf("subnet: got %q with %d hits, want 198.51.100.0/24 with 1", metrics.Subnet, metrics.SubnetTotalHits)
+	}
+	if metrics.ASN != 15169 || metrics.ASNOrganization != "GOOGLE" || metrics.ASNTotalHits != 4 {
+		t.Errorf("AS: got %d (%s) with %d hits, want 15169 (GOOGLE) with 4", metrics.ASN, metrics.ASNOrganization,
+			metrics.ASNTotalHits)
+	}
+	if got := c.subnetStats["1.2.3.0/24"].TotalHits; got != 3 {
+		t.Errorf("1.2.3.0/24 hits: got %d, want 3", got)
+	}
+
+	// Clients outside the database have no AS.
+	metrics = c.GetOrIncrementMetrics("8.8.8.8", "scraper", now)
+	if metrics.ASN != 0 || metrics.ASNTotalHits != 0 || metrics.Subnet != "8.8.8.0/24" {
+		t.Errorf("unknown AS: got ASN %d with %d hits in %q", metrics.ASN, metrics.ASNTotalHits, metrics.Subnet)
+	}
+}
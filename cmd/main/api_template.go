@@ -73,7 +73,8 @@ func (t *TemplateAPI) handleList(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, t.tm.GetTemplateNames())
 }
 
-// handleTest validates template syntax without saving the file by executing it as a string.
+// handleTest validates template syntax without saving the file by executing it as a string. The optional "name"
+// query parameter sets the kind the content is parsed as, and defaults to an HTML template.
 func (t *TemplateAPI) handleTest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", "POST")
@@ -96,17 +97,25 @@ func (t *TemplateAPI) handleTest(w http.ResponseWriter, r *http.Request) {
 		threat = 0
 	}
 
+	kind := templating.KindOf(r.URL.Query().Get("name"))
+
 	var buf bytes.Buffer
-	err = t.tm.ExecuteTemplateString(&buf, string(body), TemplateInput{threat, t.tc.GetStage(threat)})
+	err = t.tm.ExecuteTemplateStringKind(&buf, kind, string(body), t.templateInput(threat))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Template execution failed: %v", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Type", kind.ContentType())
 	_, _ = w.Write(buf.Bytes())
 }
 
+// templateInput returns the template input for a request with the given threat level.
+func (t *TemplateAPI) templateInput(threat int) TemplateInput {
+	stage := t.tc.GetStage(threat)
+	return TemplateInput{ThreatLevel: threat, ThreatStage: stage, ThreatStageName: t.tc.StageName(stage)}
+}
+
 // handlePreview renders a template with temporarily overridden "threat" levels.
 func (t *TemplateAPI) handlePreview(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -130,8 +139,8 @@ func (t *TemplateAPI) handlePreview(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var buf bytes.Buffer
-	if err = t.tm.Execute(&buf, name, TemplateInput{threat, t.tc.GetStage(threat)}); err != nil {
-		if strings.Contains(err.Error(), "is undefined") {
+	if err = t.tm.Execute(&buf, name, t.templateInput(threat)); err != nil {
+		if strings.Contains(err.Error(), "is undefined") || strings.Contains(err.Error(), "no template") {
 			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Template '%s' not found", name))
 			return
 		}
@@ -139,7 +148,7 @@ func (t *TemplateAPI) handlePreview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Type", templating.KindOf(name).ContentType())
 	_, _ = w.Write(buf.Bytes())
 }
 
@@ -151,7 +160,7 @@ func (t *TemplateAPI) handleFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if strings.Contains(name, "..") || (!strings.HasSuffix(name, ".tmpl.html") && !strings.HasSuffix(name, ".part.html")) {
+	if strings.Contains(name, "..") || !templating.IsTemplateFile(name) {
 		respondWithError(w, http.StatusBadRequest, "Invalid template name format")
 		return
 	}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"html/template"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenyxia/Sarracenia/pkg/templating"
+)
+
+// Ways the tarpit can answer a request, besides serving a page.
+const (
+	behaviorPage        = "page"
+	behaviorRedirect    = "redirect"
+	behaviorRefresh     = "refresh"
+	behaviorUnavailable = "unavailable"
+	behaviorNotFound    = "not_found"
+	behaviorServerError = "server_error"
+)
+
+// maxLabyrinthPaths caps the number of pending redirect and refresh hops remembered at once. Once it is reached, the
+// oldest hop is forgotten to make room, and a client following it later gets a regular page.
+const maxLabyrinthPaths = 10000
+
+// labyrinthHopTTL is how long a hop is remembered. Clients that don't follow their redirect or refresh by then have
+// left the chain.
+const labyrinthHopTTL = 10 * time.Minute
+
+// BehaviorWeights are the relative weights of each way of answering a tarpit request. A weight of 0 disables that
+// behavior.
+type BehaviorWeights struct {
+	// Page serves a regular tarpit page.
+	Page int `json:"page"`
+
+	// Redirect sends the client through a chain of redirects to generated paths, ending on a regular page.
+	Redirect int `json:"redirect"`
+
+	// Refresh serves a short page with a meta refresh to a generated path, chained like redirects.
+	Refresh int `json:"refresh"`
+
+	// Unavailable responds with 503 Service Unavailable and a Retry-After header.
+	Unavailable int `json:"unavailable"`
+
+	// NotFound and ServerError serve a regular tarpit page with a 404 or 500 status.
+	NotFound    int `json:"not_found"`
+	ServerError int `json:"server_error"`
+}
+
+// pick chooses a behavior with a probability proportional to its weight, drawing from intN.
+// It returns behaviorPage if every weight is 0.
+func (b BehaviorWeights) pick(intN func(int) int) string {
+	behavior := pickWeighted([]WeightedTemplate{
+		{Name: behaviorPage, Weight: b.Page},
+		{Name: behaviorRedirect, Weight: b.Redirect},
+		{Name: behaviorRefresh, Weight: b.Refresh},
+		{Name: behaviorUnavailable, Weight: b.Unavailable},
+		{Name: behaviorNotFound, Weight: b.NotFound},
+		{Name: behaviorServerError, Weight: b.ServerError},
+	}, intN)
+	if behavior == "" {
+		return behaviorPage
+	}
+	return behavior
+}
+
+// Validate checks that no weight is negative.
+func (b BehaviorWeights) Validate() error {
+	if b.Page < 0 || b.Redirect < 0 || b.Refresh < 0 || b.Unavailable < 0 || b.NotFound < 0 || b.ServerError < 0 {
+		return fmt.Errorf("behavior weights must not be negative")
+	}
+	return nil
+}
+
+// BehaviorsConfig decides how tarpit requests are answered. Threat stages can replace the weights with their own.
+type BehaviorsConfig struct {
+	Weights BehaviorWeights `json:"weights"`
+
+	// RedirectCodes are the status codes redirects are sent with, picked at random for each hop.
+	RedirectCodes []int `json:"redirect_codes"`
+
+	// Number of hops in a redirect or refresh chain, before the client lands on a regular page.
+	MinChain int `json:"min_chain"`
+	MaxChain int `json:"max_chain"`
+
+	// Range of the Retry-After header sent with 503 responses.
+	MinRetryAfterSec int `json:"min_retry_after_sec"`
+	MaxRetryAfterSec int `json:"max_retry_after_sec"`
+
+	// RefreshDelaySec is the delay in the meta refresh of refresh pages.
+	RefreshDelaySec int `json:"refresh_delay_sec"`
+}
+
+// Validate checks the settings for values that can't be used.
+func (c *BehaviorsConfig) Validate() error {
+	if err := c.Weights.Validate(); err != nil {
+		return err
+	}
+	if len(c.RedirectCodes) == 0 {
+		return fmt.Errorf("redirect_codes must not be empty")
+	}
+	for _, code := range c.RedirectCodes {
+		switch code {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return fmt.Errorf("redirect code %d is not a redirect status", code)
+		}
+	}
+	if c.MinChain < 1 || c.MaxChain < c.MinChain {
+		return fmt.Errorf("min_chain must be at least 1, and max_chain at least min_chain")
+	}
+	if c.MinRetryAfterSec < 0 || c.MaxRetryAfterSec < c.MinRetryAfterSec {
+		return fmt.Errorf("min_retry_after_sec must not be negative, and max_retry_after_sec must be at least min_retry_after_sec")
+	}
+	if c.RefreshDelaySec < 0 {
+		return fmt.Errorf("refresh_delay_sec must not be negative")
+	}
+	return nil
+}
+
+// labyrinthHop is a pending step of a redirect or refresh chain.
+type labyrinthHop struct {
+	behavior  string
+	remaining int
+}
+
+// labyrinthEntry is a hop as stored, with its path and when it expires.
+type labyrinthEntry struct {
+	path    string
+	hop     labyrinthHop
+	expires time.Time
+}
+
+// labyrinth remembers the generated paths that clients have been sent to in the middle of a redirect or refresh
+// chain, so the chain continues when they follow it. Hops expire after labyrinthHopTTL. All methods are
+// concurrent-safe.
+type labyrinth struct {
+	mu    sync.Mutex
+	hops  map[string]*list.Element
+	order *list.List // Of *labyrinthEntry, oldest first. All hops live equally long, so this is also expiry order.
+	now   func() time.Time
+}
+
+func newLabyrinth() *labyrinth {
+	return &labyrinth{hops: make(map[string]*list.Element), order: list.New(), now: time.Now}
+}
+
+// add records that path continues a chain. Expired hops are dropped first, and if too many are still pending, the
+// oldest is dropped too.
+func (l *labyrinth) add(path string, hop labyrinthHop) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	if e, ok := l.hops[path]; ok {
+		l.remove(e)
+	}
+	for len(l.hops) >= maxLabyrinthPaths {
+		l.remove(l.order.Front())
+	}
+	l.hops[path] = l.order.PushBack(&labyrinthEntry{path: path, hop: hop, expires: now.Add(labyrinthHopTTL)})
+}
+
+// take removes and returns the hop recorded for path, if any and it hasn't expired.
+func (l *labyrinth) take(path string) (labyrinthHop, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.hops[path]
+	if !ok {
+		return labyrinthHop{}, false
+	}
+	l.remove(e)
+	entry := e.Value.(*labyrinthEntry)
+	if !l.now().Before(entry.expires) {
+		return labyrinthHop{}, false
+	}
+	return entry.hop, true
+}
+
+// sweep drops the hops that have expired. The caller must hold l.mu.
+func (l *labyrinth) sweep(now time.Time) {
+	for e := l.order.Front(); e != nil && !now.Before(e.Value.(*labyrinthEntry).expires); e = l.order.Front() {
+		l.remove(e)
+	}
+}
+
+// remove drops a hop. The caller must hold l.mu.
+func (l *labyrinth) remove(e *list.Element) {
+	delete(l.hops, e.Value.(*labyrinthEntry).path)
+	l.order.Remove(e)
+}
+
+// chooseBehavior decides how to answer a tarpit request. A request following a redirect or refresh chain continues
+// it, or gets a regular page at its end. Any other request gets a behavior picked by weight, from the stage's
+// weights if it has any, or the global ones otherwise. It also returns the number of hops left in the chain.
+func (s *Server) chooseBehavior(r *http.Request, behaviors BehaviorsConfig, stage StageConfig, intN func(int) int) (string, int) {
+	if hop, ok := s.labyrinth.take(r.URL.Path); ok {
+		if hop.remaining > 0 {
+			return hop.behavior, hop.remaining - 1
+		}
+		return behaviorPage, 0
+	}
+
+	weights := behaviors.Weights
+	if stage.Behaviors != nil {
+		weights = *stage.Behaviors
+	}
+	behavior := weights.pick(intN)
+	remaining := 0
+	if behavior == behaviorRedirect || behavior == behaviorRefresh {
+		remaining = behaviors.MinChain - 1
+		if behaviors.MaxChain > behaviors.MinChain {
+			remaining += intN(behaviors.MaxChain - behaviors.MinChain + 1)
+		}
+	}
+	return behavior, remaining
+}
+
+// serveBehavior answers a request whose behavior doesn't involve a tarpit page: a redirect or refresh to the next
+// hop of the chain, or a 503. It returns the status code sent.
+func (s *Server) serveBehavior(w http.ResponseWriter, r *http.Request, behavior string, remaining int, behaviors BehaviorsConfig, pageRand *rand.Rand, intN func(int) int) int {
+	if behavior == behaviorUnavailable {
+		retryAfter := behaviors.MinRetryAfterSec
+		if behaviors.MaxRetryAfterSec > behaviors.MinRetryAfterSec {
+			retryAfter += intN(behaviors.MaxRetryAfterSec - behaviors.MinRetryAfterSec + 1)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return http.StatusServiceUnavailable
+	}
+
+	next := "/"
+	if links := s.tm.GenerateLinks(1, templating.WithRand(pageRand)); len(links) > 0 {
+		next = links[0]
+	}
+	s.labyrinth.add(next, labyrinthHop{behavior: behavior, remaining: remaining})
+
+	if behavior == behaviorRedirect {
+		code := behaviors.RedirectCodes[intN(len(behaviors.RedirectCodes))]
+		http.Redirect(w, r, next, code)
+		return code
+	}
+
+	title := template.HTMLEscapeString(strings.TrimSuffix(s.tm.GenerateSentence(intN(4)+2, templating.WithRand(pageRand)), "."))
+	body := template.HTMLEscapeString(s.tm.GenerateSentence(intN(20)+10, templating.WithRand(pageRand)))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="%d; url=%s">
+<title>%s</title>
+</head>
+<body>
+<p>%s</p>
+<p><a href="%s">Continue</a></p>
+</body>
+</html>
+`, behaviors.RefreshDelaySec, template.HTMLEscapeString(next), title, body, template.HTMLEscapeString(next))
+	return http.StatusOK
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLabyrinth_Expiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newLabyrinth()
+	l.now = func() time.Time { return now }
+
+	l.add("/a", labyrinthHop{behavior: behaviorRedirect, remaining: 3})
+	l.add("/b", labyrinthHop{behavior: behaviorRefresh, remaining: 1})
+
+	hop, ok := l.take("/a")
+	if !ok || hop.behavior != behaviorRedirect || hop.remaining != 3 {
+		t.Fatalf("take /a: got %+v, %v", hop, ok)
+	}
+	if _, ok = l.take("/a"); ok {
+		t.Error("take /a twice: hop was still there")
+	}
+
+	now = now.Add(labyrinthHopTTL)
+	if _, ok = l.take("/b"); ok {
+		t.Error("take /b after its TTL: hop hadn't expired")
+	}
+
+	// Expired hops are swept by the next add, so they don't count towards the cap.
+	l.add("/c", labyrinthHop{behavior: behaviorRedirect, remaining: 1})
+	now = now.Add(labyrinthHopTTL)
+	l.add("/d", labyrinthHop{behavior: behaviorRedirect, remaining: 1})
+	if len(l.hops) != 1 || l.order.Len() != 1 {
+		t.Errorf("after sweep: got %d hops and %d in order, want 1", len(l.hops), l.order.Len())
+	}
+}
+
+func TestLabyrinth_EvictsOldestWhenFull(t *testing.T) {
+	l := newLabyrinth()
+	for i := 0; i < maxLabyrinthPaths; i++ {
+		l.add("/"+strconv.Itoa(i), labyrinthHop{behavior: behaviorRedirect, remaining: 1})
+	}
+	l.add("/new", labyrinthHop{behavior: behaviorRedirect, remaining: 1})
+
+	if len(l.hops) != maxLabyrinthPaths {
+		t.Errorf("got %d hops, want %d", len(l.hops), maxLabyrinthPaths)
+	}
+	if _, ok := l.take("/0"); ok {
+		t.Error("oldest hop was kept")
+	}
+	if _, ok := l.take("/1"); !ok {
+		t.Error("second oldest hop was dropped")
+	}
+	if _, ok := l.take("/new"); !ok {
+		t.Error("new hop was dropped")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// compressedPayloadChunk is written over and over to make up a compressed payload. Runs of a single byte compress at
+// about 1000:1 with deflate, and spaces are valid trailing content in every kind of document the tarpit serves.
+var compressedPayloadChunk = bytes.Repeat([]byte{' '}, 64*1024)
+
+// compressor is the part of gzip.Writer and zlib.Writer that compressWriter uses.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// compressWriter is an http.ResponseWriter that compresses the body with the given content encoding ("gzip",
+// "deflate", or "" to send it as is). Flush flushes the compressor before the underlying writer, so drip-fed chunks
+// still reach the client one at a time. It counts the body bytes written to it and the bytes sent after compression.
+// Close must be called once the response is complete.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+
+	wire        *countingWriter
+	compressor  compressor
+	written     int64
+	wroteHeader bool
+	statusCode  int
+}
+
+// newCompressWriter wraps w. The level is one of the compress/flate levels, and is used for both encodings.
+func newCompressWriter(w http.ResponseWriter, encoding string, level int) *compressWriter {
+	return &compressWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+		level:          level,
+		wire:           &countingWriter{w: w},
+	}
+}
+
+// WriteHeader sets the Content-Encoding, if the response has a body, and sends the headers.
+func (c *compressWriter) WriteHeader(statusCode int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	c.statusCode = statusCode
+
+	if c.encoding != "" && statusCode >= http.StatusOK && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified {
+		header := c.Header()
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", c.encoding)
+		header.Add("Vary", "Accept-Encoding")
+
+		var err error
+		if c.encoding == "gzip" {
+			c.compressor, err = gzip.NewWriterLevel(c.wire, c.level)
+		} else {
+			c.compressor, err = zlib.NewWriterLevel(c.wire, c.level)
+		}
+		if err != nil {
+			// Only possible with an invalid level, in which case the response goes out uncompressed.
+			header.Del("Content-Encoding")
+			c.compressor = nil
+		}
+	}
+	c.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.written += int64(len(p))
+	if c.compressor != nil {
+		return c.compressor.Write(p)
+	}
+	return c.wire.Write(p)
+}
+
+// Flush sends everything written so far to the client.
+func (c *compressWriter) Flush() {
+	if c.compressor != nil {
+		_ = c.compressor.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close finishes the compressed stream. It does not close the underlying writer.
+func (c *compressWriter) Close() error {
+	if c.compressor == nil {
+		return nil
+	}
+	return c.compressor.Close()
+}
+
+// Compressed reports whether the body is being compressed.
+func (c *compressWriter) Compressed() bool {
+	return c.compressor != nil
+}
+
+// StatusCode returns the status code sent, or 0 if the headers haven't been sent.
+func (c *compressWriter) StatusCode() int {
+	return c.statusCode
+}
+
+// BytesWritten returns the number of body bytes written, before compression.
+func (c *compressWriter) BytesWritten() int64 {
+	return c.written
+}
+
+// BytesSent returns the number of body bytes sent to the underlying writer, after compression.
+func (c *compressWriter) BytesSent() int64 {
+	return c.wire.n
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// negotiateEncoding picks the content encoding for a response from the request's Accept-Encoding header. gzip is
+// preferred over deflate when both are equally acceptable. A "*" covers whichever of the two isn't listed, and a
+// quality of 0 rules an encoding out. It returns "" if neither is accepted.
+func negotiateEncoding(r *http.Request) string {
+	qualities := make(map[string]float64, 2)
+	wildcard := 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "deflate" && coding != "*" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if coding == "*" {
+			wildcard = quality
+		} else {
+			qualities[coding] = quality
+		}
+	}
+
+	best, bestQuality := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		quality, ok := qualities[coding]
+		if !ok {
+			quality = wildcard
+		}
+		if quality > bestQuality {
+			best, bestQuality = coding, quality
+		}
+	}
+	return best
+}
+
+// serveCompressedPayload appends up to CompressedPayloadMaxBytes of spaces to a compressed response that has already
+// had its page sent. The payload is streamed through the compressor as the client reads it, so it costs the client
+// far more to inflate than it costs to send.
+func (s *Server) serveCompressedPayload(cw *compressWriter, r *http.Request, tarpitConfig TarpitConfig) {
+	start := time.Now()
+	remaining := tarpitConfig.CompressedPayloadMaxBytes
+	var err error
+	for remaining > 0 && err == nil {
+		if err = r.Context().Err(); err != nil {
+			break
+		}
+		var n int
+		n, err = cw.Write(compressedPayloadChunk[:min(remaining, len(compressedPayloadChunk))])
+		remaining -= n
+	}
+	cw.Flush()
+
+	s.logger.Debug("Compressed payload ended",
+		"remote_addr", r.RemoteAddr,
+		"bytes", tarpitConfig.CompressedPayloadMaxBytes-remaining,
+		"error", err,
+		"duration", time.Since(start))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{"missing", "", ""},
+		{"gzip", "gzip", "gzip"},
+		{"deflate", "deflate", "deflate"},
+		{"gzip preferred on a tie", "deflate, gzip", "gzip"},
+		{"case and spaces", " GZip ;q=0.5 ,deflate; q=0.4", "gzip"},
+		{"higher quality wins", "gzip;q=0.5, deflate;q=0.8", "deflate"},
+		{"q=0 rules gzip out", "gzip;q=0, deflate", "deflate"},
+		{"q=0 on everything", "gzip;q=0, deflate;q=0", ""},
+		{"identity only", "identity", ""},
+		{"identity and deflate", "identity, deflate;q=0.5", "deflate"},
+		{"unsupported only", "br, zstd", ""},
+		{"wildcard", "*", "gzip"},
+		{"wildcard q=0", "*;q=0", ""},
+		{"wildcard does not override an explicit q=0", "gzip;q=0, *", "deflate"},
+		{"wildcard covers the unlisted coding", "gzip;q=0.2, *;q=0.5", "deflate"},
+		{"explicit beats a higher wildcard", "deflate;q=0.1, gzip;q=0", "deflate"},
+		{"invalid quality is skipped", "gzip;q=abc, deflate", "deflate"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			if got := negotiateEncoding(r); got != tc.want {
+				t.Errorf("negotiateEncoding(%q): got %q, want %q", tc.acceptEncoding, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompressWriter_RoundTrip(t *testing.T) {
+	body := []byte(strings.Repeat("<p>The quick brown fox jumps over the lazy dog.</p>\n", 200))
+
+	testCases := []struct {
+		encoding   string
+		decompress func(io.Reader) (io.Reader, error)
+	}{
+		{"", func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{"gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate", func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+	}
+
+	for _, tc := range testCases {
+		t.Run("encoding="+tc.encoding, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			cw := newCompressWriter(rec, tc.encoding, flate.BestCompression)
+
+			// Write in pieces with flushes in between, like a drip-fed response.
+			for i := 0; i < len(body); i += 1000 {
+				if _, err := cw.Write(body[i:min(i+1000, len(body))]); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+				cw.Flush()
+			}
+			if err := cw.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			if got := rec.Header().Get("Content-Encoding"); got != tc.encoding {
+				t.Errorf("Content-Encoding: got %q, want %q", got, tc.encoding)
+			}
+			if cw.Compressed() != (tc.encoding != "") {
+				t.Errorf("Compressed: got %v", cw.Compressed())
+			}
+			if cw.BytesWritten() != int64(len(body)) {
+				t.Errorf("BytesWritten: got %d, want %d", cw.BytesWritten(), len(body))
+			}
+			if cw.BytesSent() != int64(rec.Body.Len()) {
+				t.Errorf("BytesSent: got %d, want %d", cw.BytesSent(), rec.Body.Len())
+			}
+			if tc.encoding != "" && rec.Body.Len() >= len(body) {
+				t.Errorf("compressed body is %d bytes, not smaller than the original %d", rec.Body.Len(), len(body))
+			}
+
+			reader, err := tc.decompress(bytes.NewReader(rec.Body.Bytes()))
+			if err != nil {
+				t.Fatalf("opening decompressor: %v", err)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("decompressing: %v", err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("decompressed body differs from the original: got %d bytes, want %d", len(got), len(body))
+			}
+		})
+	}
+}
+
+func TestCompressWriter_NoBody(t *testing.T) {
+	rec := httptest.NewRecorder()
+	cw := newCompressWriter(rec, "gzip", flate.DefaultCompression)
+	cw.WriteHeader(http.StatusNoContent)
+	if err := cw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding on a 204: got %q, want none", got)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("body on a 204: got %d bytes, want none", rec.Body.Len())
+	}
+}
@@ -5,42 +5,180 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/amenyxia/Sarracenia/pkg/tarpit"
 	"github.com/amenyxia/Sarracenia/pkg/templating"
 	"github.com/natefinch/atomic"
 )
 
 // ServerConfig holds the configuration for the HTTP servers.
 type ServerConfig struct {
-	ServerAddr          string        `json:"server_addr"`
-	ApiAddr             string        `json:"api_addr"`
-	LogLevel            string        `json:"log_level"`
-	TrustedProxies      []string      `json:"trusted_proxies"`
-	DataDir             string        `json:"data_dir"`
-	MarkovDatabasePath  string        `json:"markov_database_path"`
-	AuthDatabasePath    string        `json:"auth_database_path"`
-	StatsDatabasePath   string        `json:"stats_database_path"`
-	DashboardTmplPath   string        `json:"dashboard_tmpl_path"`
-	DashboardStaticPath string        `json:"dashboard_static_path"`
-	EnabledTemplates    []string      `json:"enabled_templates"`
-	TarpitConfig        *TarpitConfig `json:"tarpit_config"`
-	StatsConfig         *StatsConfig  `json:"stats_config"`
+	ServerAddr          string                  `json:"server_addr"`
+	ApiAddr             string                  `json:"api_addr"`
+	LogLevel            string                  `json:"log_level"`
+	TrustedProxies      []string                `json:"trusted_proxies"`
+	DataDir             string                  `json:"data_dir"`
+	MarkovDatabasePath  string                  `json:"markov_database_path"`
+	AuthDatabasePath    string                  `json:"auth_database_path"`
+	StatsDatabasePath   string                  `json:"stats_database_path"`
+	DashboardTmplPath   string                  `json:"dashboard_tmpl_path"`
+	DashboardStaticPath string                  `json:"dashboard_static_path"`
+	EnabledTemplates    []string                `json:"enabled_templates"`
+	RoutingRules        []RouteRule             `json:"routing_rules"`
+	TarpitConfig        *TarpitConfig           `json:"tarpit_config"`
+	StatsConfig         *StatsConfig            `json:"stats_config"`
+	CrawlerFiles        *CrawlerFilesConfig     `json:"crawler_files"`
+	ConnectionLimits    *ConnectionLimitsConfig `json:"connection_limits"`
+	Behaviors           *BehaviorsConfig        `json:"behaviors"`
+	Proxy               *ProxyConfig            `json:"proxy"`
+	ForwardAuth         *ForwardAuthConfig      `json:"forward_auth"`
+	TLS                 *TLSConfig              `json:"tls"`
+	TCPTarpits          *TCPTarpitsConfig       `json:"tcp_tarpits"`
+	Blocklist           *BlocklistConfig        `json:"blocklist"`
+	CrawlerCatalogPath  string                  `json:"crawler_catalog_path"`
+	GoodBots            *GoodBotsConfig         `json:"good_bots"`
 }
 
 // TarpitConfig holds settings for response delaying and drip-feeding.
+// When StreamResponse is enabled, pages are drip-fed in chunks sized by DripFeedChunkBytesMin/Max
+// while they render, and DripFeedChunksMin/Max are ignored since the total size isn't known upfront.
 type TarpitConfig struct {
-	EnableDripFeed    bool              `json:"enable_drip_feed"`
-	InitialDelayMin   int               `json:"min_initial_delay_ms"`
-	InitialDelayMax   int               `json:"max_initial_delay_ms"`
-	DripFeedDelayMin  int               `json:"min_drip_feed_delay_ms"`
-	DripFeedDelayMax  int               `json:"max_drip_feed_delay_ms"`
-	DripFeedChunksMin int               `json:"min_drip_feed_chunks"`
-	DripFeedChunksMax int               `json:"max_drip_feed_chunks"`
-	Headers           map[string]string `json:"headers"`
+	EnableDripFeed        bool              `json:"enable_drip_feed"`
+	StreamResponse        bool              `json:"stream_response"`
+	InitialDelayMin       int               `json:"min_initial_delay_ms"`
+	InitialDelayMax       int               `json:"max_initial_delay_ms"`
+	DripFeedDelayMin      int               `json:"min_drip_feed_delay_ms"`
+	DripFeedDelayMax      int               `json:"max_drip_feed_delay_ms"`
+	DripFeedChunksMin     int               `json:"min_drip_feed_chunks"`
+	DripFeedChunksMax     int               `json:"max_drip_feed_chunks"`
+	DripFeedChunkBytesMin int               `json:"min_drip_feed_chunk_bytes"`
+	DripFeedChunkBytesMax int               `json:"max_drip_feed_chunk_bytes"`
+	Headers               map[string]string `json:"headers"`
+
+	// Endless page settings, used by threat stages with endless_page enabled. Filler is appended at the
+	// drip-feed delay and chunk size settings above, even if enable_drip_feed is off.
+	EndlessMarkovModel string `json:"endless_markov_model"`
+	EndlessMaxHoldSec  int    `json:"endless_max_hold_sec"`
+	EndlessMaxBytes    int    `json:"endless_max_bytes"`
+
+	// Deterministic page settings. When enabled, pages are rendered from a random source seeded with a hash of the
+	// request host and path plus PageSeedSecret, so revisiting a URL returns the same page. If the secret is empty,
+	// one is generated at startup, and pages change whenever the server restarts.
+	DeterministicPages bool   `json:"deterministic_pages"`
+	PageSeedSecret     string `json:"page_seed_secret"`
+
+	// Compression compresses pages with gzip or deflate for clients that accept it. Drip-fed chunks are flushed
+	// through the compressor, so they are still sent one at a time. CompressedPayloadMaxBytes caps the size, once
+	// decompressed, of the payload appended for threat stages with compressed_payload enabled.
+	Compression               bool `json:"compression"`
+	CompressedPayloadMaxBytes int  `json:"compressed_payload_max_bytes"`
+}
+
+// Drip returns the delay and chunk size settings in the form pkg/tarpit uses.
+func (c TarpitConfig) Drip() tarpit.DripConfig {
+	return tarpit.DripConfig{
+		InitialDelayMin: time.Duration(c.InitialDelayMin) * time.Millisecond,
+		InitialDelayMax: time.Duration(c.InitialDelayMax) * time.Millisecond,
+		DelayMin:        time.Duration(c.DripFeedDelayMin) * time.Millisecond,
+		DelayMax:        time.Duration(c.DripFeedDelayMax) * time.Millisecond,
+		ChunkBytesMin:   c.DripFeedChunkBytesMin,
+		ChunkBytesMax:   c.DripFeedChunkBytesMax,
+	}
+}
+
+// TarpitOverrides holds optional replacements for the drip-feed settings and headers of a TarpitConfig.
+// Fields left unset keep the base value, and Headers are merged on top of the base headers.
+type TarpitOverrides struct {
+	EnableDripFeed        *bool             `json:"enable_drip_feed,omitempty"`
+	StreamResponse        *bool             `json:"stream_response,omitempty"`
+	InitialDelayMin       *int              `json:"min_initial_delay_ms,omitempty"`
+	InitialDelayMax       *int              `json:"max_initial_delay_ms,omitempty"`
+	DripFeedDelayMin      *int              `json:"min_drip_feed_delay_ms,omitempty"`
+	DripFeedDelayMax      *int              `json:"max_drip_feed_delay_ms,omitempty"`
+	DripFeedChunksMin     *int              `json:"min_drip_feed_chunks,omitempty"`
+	DripFeedChunksMax     *int              `json:"max_drip_feed_chunks,omitempty"`
+	DripFeedChunkBytesMin *int              `json:"min_drip_feed_chunk_bytes,omitempty"`
+	DripFeedChunkBytesMax *int              `json:"max_drip_feed_chunk_bytes,omitempty"`
+	Compression           *bool             `json:"compression,omitempty"`
+	Headers               map[string]string `json:"headers,omitempty"`
+}
+
+// Apply returns a copy of base with the overrides applied. A nil receiver returns base unchanged.
+func (o *TarpitOverrides) Apply(base TarpitConfig) TarpitConfig {
+	if o == nil {
+		return base
+	}
+	overrideBool(&base.EnableDripFeed, o.EnableDripFeed)
+	overrideBool(&base.StreamResponse, o.StreamResponse)
+	overrideInt(&base.InitialDelayMin, o.InitialDelayMin)
+	overrideInt(&base.InitialDelayMax, o.InitialDelayMax)
+	overrideInt(&base.DripFeedDelayMin, o.DripFeedDelayMin)
+	overrideInt(&base.DripFeedDelayMax, o.DripFeedDelayMax)
+	overrideInt(&base.DripFeedChunksMin, o.DripFeedChunksMin)
+	overrideInt(&base.DripFeedChunksMax, o.DripFeedChunksMax)
+	overrideInt(&base.DripFeedChunkBytesMin, o.DripFeedChunkBytesMin)
+	overrideInt(&base.DripFeedChunkBytesMax, o.DripFeedChunkBytesMax)
+	overrideBool(&base.Compression, o.Compression)
+
+	if len(o.Headers) > 0 {
+		// Copy so the shared base map is never modified.
+		headers := make(map[string]string, len(base.Headers)+len(o.Headers))
+		maps.Copy(headers, base.Headers)
+		maps.Copy(headers, o.Headers)
+		base.Headers = headers
+	}
+	return base
+}
+
+// Validate checks that every min/max range the overrides touch is still valid once applied to base.
+// Ranges that aren't overridden are left alone, so an odd global setting doesn't fail every stage.
+func (o *TarpitOverrides) Validate(base TarpitConfig) error {
+	if o == nil {
+		return nil
+	}
+	effective := o.Apply(base)
+	ranges := []struct {
+		name     string
+		set      bool
+		min, max int
+	}{
+		{"initial_delay_ms", o.InitialDelayMin != nil || o.InitialDelayMax != nil, effective.InitialDelayMin, effective.InitialDelayMax},
+		{"drip_feed_delay_ms", o.DripFeedDelayMin != nil || o.DripFeedDelayMax != nil, effective.DripFeedDelayMin, effective.DripFeedDelayMax},
+		{"drip_feed_chunks", o.DripFeedChunksMin != nil || o.DripFeedChunksMax != nil, effective.DripFeedChunksMin, effective.DripFeedChunksMax},
+		{"drip_feed_chunk_bytes", o.DripFeedChunkBytesMin != nil || o.DripFeedChunkBytesMax != nil, effective.DripFeedChunkBytesMin, effective.DripFeedChunkBytesMax},
+	}
+	for _, field := range ranges {
+		if !field.set {
+			continue
+		}
+		if field.min < 0 || field.max < 0 {
+			return fmt.Errorf("%s must not be negative", field.name)
+		}
+		if field.min > field.max {
+			return fmt.Errorf("min_%s (%d) is greater than max_%s (%d)", field.name, field.min, field.name, field.max)
+		}
+	}
+	return nil
+}
+
+func overrideBool(dst *bool, src *bool) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func overrideInt(dst *int, src *int) {
+	if src != nil {
+		*dst = *src
+	}
 }
 
 // StatsConfig holds settings for statistics caching and cleanup.
@@ -48,6 +186,45 @@ type StatsConfig struct {
 	SyncIntervalSec  int `json:"sync_interval_sec"`
 	ForgetThreshold  int `json:"forget_threshold"`
 	ForgetDelayHours int `json:"forget_delay_hours"`
+
+	// SubnetPrefixV4 and SubnetPrefixV6 are the prefix lengths hits are aggregated by, so scrapers rotating through
+	// the addresses of one network are counted together.
+	SubnetPrefixV4 int `json:"subnet_prefix_v4"`
+	SubnetPrefixV6 int `json:"subnet_prefix_v6"`
+
+	// ASNDatabasePath is a MaxMind GeoLite2-ASN or IPinfo ASN .mmdb file, used to aggregate hits by autonomous
+	// system. Empty disables ASN lookups.
+	ASNDatabasePath string `json:"asn_database_path"`
+}
+
+// Validate checks the settings for values that can't be used.
+func (c *StatsConfig) Validate() error {
+	if c.SubnetPrefixV4 < 1 || c.SubnetPrefixV4 > 32 {
+		return fmt.Errorf("subnet_prefix_v4 must be between 1 and 32")
+	}
+	if c.SubnetPrefixV6 < 1 || c.SubnetPrefixV6 > 128 {
+		return fmt.Errorf("subnet_prefix_v6 must be between 1 and 128")
+	}
+	return nil
+}
+
+// CrawlerFilesConfig holds settings for the generated robots.txt, sitemap, and llms.txt responses.
+type CrawlerFilesConfig struct {
+	// Enabled serves the generated files. If false, those paths get regular tarpit pages.
+	Enabled bool `json:"enabled"`
+
+	// RobotsDisallow lists the paths disallowed in robots.txt. Nothing real lives there; they are bait
+	// for crawlers that read robots.txt to find the interesting parts of a site.
+	RobotsDisallow []string `json:"robots_disallow"`
+
+	// SitemapURLs is the number of URLs in each sitemap page.
+	SitemapURLs int `json:"sitemap_urls"`
+
+	// SitemapIndexSize is the number of sitemaps listed in each page of the sitemap index.
+	SitemapIndexSize int `json:"sitemap_index_size"`
+
+	// LLMsLinks is the number of links listed in llms.txt.
+	LLMsLinks int `json:"llms_links"`
 }
 
 // Config is the top-level configuration struct that aggregates all other configs.
@@ -71,14 +248,25 @@ func DefaultServerConfig() *ServerConfig {
 		DashboardTmplPath:   "./data/dashboard/templates/",
 		DashboardStaticPath: "./data/dashboard/static/",
 		EnabledTemplates:    []string{"page.tmpl.html"},
+		RoutingRules:        []RouteRule{},
 		TarpitConfig: &TarpitConfig{
-			EnableDripFeed:    false,
-			InitialDelayMin:   0,
-			InitialDelayMax:   15000,
-			DripFeedDelayMin:  500,
-			DripFeedDelayMax:  1000,
-			DripFeedChunksMin: 1,
-			DripFeedChunksMax: 20,
+			EnableDripFeed:            false,
+			StreamResponse:            false,
+			InitialDelayMin:           0,
+			InitialDelayMax:           15000,
+			DripFeedDelayMin:          500,
+			DripFeedDelayMax:          1000,
+			DripFeedChunksMin:         1,
+			DripFeedChunksMax:         20,
+			DripFeedChunkBytesMin:     512,
+			DripFeedChunkBytesMax:     4096,
+			EndlessMarkovModel:        "",
+			EndlessMaxHoldSec:         600,
+			EndlessMaxBytes:           10 * 1024 * 1024,
+			DeterministicPages:        false,
+			PageSeedSecret:            "",
+			Compression:               false,
+			CompressedPayloadMaxBytes: 100 * 1024 * 1024,
 			Headers: map[string]string{
 				"Cache-Control":           "no-store, no-cache",
 				"Pragma":                  "no-cache",
@@ -91,6 +279,74 @@ func DefaultServerConfig() *ServerConfig {
 			SyncIntervalSec:  30,
 			ForgetThreshold:  10,
 			ForgetDelayHours: 24,
+			SubnetPrefixV4:   24,
+			SubnetPrefixV6:   64,
+			ASNDatabasePath:  "",
+		},
+		CrawlerFiles: &CrawlerFilesConfig{
+			Enabled:          true,
+			RobotsDisallow:   []string{"/admin/", "/backup/", "/private/", "/internal/api/"},
+			SitemapURLs:      500,
+			SitemapIndexSize: 50,
+			LLMsLinks:        25,
+		},
+		ConnectionLimits: &ConnectionLimitsConfig{
+			MaxHeld:         1000,
+			MaxHeldPerIP:    10,
+			OverLimitAction: overLimitServe,
+			RetryAfterSec:   60,
+		},
+		Behaviors: &BehaviorsConfig{
+			Weights:          BehaviorWeights{Page: 1},
+			RedirectCodes:    []int{http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect},
+			MinChain:         2,
+			MaxChain:         6,
+			MinRetryAfterSec: 30,
+			MaxRetryAfterSec: 300,
+			RefreshDelaySec:  3,
+		},
+		Proxy: &ProxyConfig{
+			Enabled:           false,
+			TarpitThreshold:   100,
+			BlockedUserAgents: []string{"GPTBot", "ClaudeBot", "CCBot", "Bytespider", "PerplexityBot", "Amazonbot", "meta-externalagent"},
+			BaitPaths:         []string{"/wp-login.php", "/wp-admin/**", "/xmlrpc.php", "/.env", "/.git/**"},
+		},
+		ForwardAuth: &ForwardAuthConfig{
+			Enabled:    false,
+			DenyStatus: http.StatusForbidden,
+		},
+		TLS: &TLSConfig{
+			Tarpit:         false,
+			API:            false,
+			CertFile:       "./data/tls/cert.pem",
+			KeyFile:        "./data/tls/key.pem",
+			SelfSigned:     true,
+			Hosts:          []string{"localhost", "127.0.0.1"},
+			ReloadCheckSec: 60,
+		},
+		TCPTarpits: &TCPTarpitsConfig{
+			{Protocol: tcpProtocolSSH, Addr: ":2222", LineDelayMin: 5000, LineDelayMax: 10000},
+			{Protocol: tcpProtocolSMTP, Addr: ":2525", LineDelayMin: 5000, LineDelayMax: 10000},
+			{Protocol: tcpProtocolTelnet, Addr: ":2323", LineDelayMin: 5000, LineDelayMax: 10000},
+			{Protocol: tcpProtocolFTP, Addr: ":2121", LineDelayMin: 5000, LineDelayMax: 10000},
+		},
+		Blocklist: &BlocklistConfig{
+			Stage: 4,
+		},
+		CrawlerCatalogPath: "./data/crawlers.json",
+		GoodBots: &GoodBotsConfig{
+			Enabled:           false,
+			Resolver:          "",
+			LookupTimeoutMs:   2000,
+			CacheTTLSec:       86400,
+			FailedCacheTTLSec: 3600,
+			Bots: []GoodBot{
+				{Name: "Googlebot", UserAgents: []string{"Googlebot", "Google-InspectionTool", "GoogleOther", "AdsBot-Google", "Mediapartners-Google", "Storebot-Google"}, Hostnames: []string{"googlebot.com", "google.com"}},
+				{Name: "Bingbot", UserAgents: []string{"bingbot", "BingPreview", "adidxbot", "msnbot"}, Hostnames: []string{"search.msn.com"}},
+				{Name: "Applebot", UserAgents: []string{"Applebot"}, Hostnames: []string{"applebot.apple.com"}},
+				{Name: "YandexBot", UserAgents: []string{"YandexBot", "YandexImages", "YandexMobileBot"}, Hostnames: []string{"yandex.ru", "yandex.net", "yandex.com"}},
+				{Name: "Baiduspider", UserAgents: []string{"Baiduspider"}, Hostnames: []string{"baidu.com", "baidu.jp"}},
+			},
 		},
 	}
 }
@@ -129,18 +385,127 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	// Threat stages from before they were a list have been migrated on parsing, and lifetime hit rate factors from
+	// before windowed rates are migrated here; save them in the new form.
+	var migrated []string
+	if hasLegacyStages(file) {
+		migrated = append(migrated, "threat stages")
+	}
+	if migrateLegacyRateFactors(file, config.Threat) {
+		migrated = append(migrated, "hit rate factors")
+	}
+	if len(migrated) > 0 {
+		var data []byte
+		data, err = json.MarshalIndent(config, "", "  ")
+		if err == nil {
+			err = atomic.WriteFile(path, bytes.NewReader(data))
+		}
+		if err != nil {
+			fmt.Printf("warning: failed to save migrated %s: %v\n", strings.Join(migrated, " and "), err)
+		} else {
+			fmt.Printf("migrated the %s in %s to their current form\n", strings.Join(migrated, " and "), path)
+		}
+	}
+
 	return config, nil
 }
 
-// ConfigManager handles thread-safe access to configuration and derived state (trusted proxies).
+// hasLegacyStages reports whether a config file has its threat stages in the stage_1 to stage_5 object form.
+func hasLegacyStages(file []byte) bool {
+	var raw struct {
+		Threat struct {
+			Stages json.RawMessage `json:"stages"`
+		} `json:"threat_config"`
+	}
+	if json.Unmarshal(file, &raw) != nil {
+		return false
+	}
+	stages := bytes.TrimSpace(raw.Threat.Stages)
+	return len(stages) > 0 && stages[0] == '{'
+}
+
+// Lifetime hit rate factors that configs from before windowed rates got by default.
+const (
+	legacyIPHitRateFactor = 10.0
+	legacyUAHitRateFactor = 5.0
+)
+
+// migrateLegacyRateFactors moves the lifetime hit rate factors of a config file from before windowed rates into
+// window factors, and reports whether it did. The windows get the default window factors scaled so that they add up
+// to the old factor, so a client hitting at a steady rate scores the same as before.
+func migrateLegacyRateFactors(file []byte, threat *ThreatConfig) bool {
+	var raw struct {
+		Threat *struct {
+			IPHitRateFactor     *float64        `json:"ip_hit_rate_factor"`
+			UAHitRateFactor     *float64        `json:"ua_hit_rate_factor"`
+			IPWindowRateFactors json.RawMessage `json:"ip_window_rate_factors"`
+			UAWindowRateFactors json.RawMessage `json:"ua_window_rate_factors"`
+		} `json:"threat_config"`
+	}
+	if json.Unmarshal(file, &raw) != nil || raw.Threat == nil || threat == nil {
+		return false
+	}
+	if raw.Threat.IPWindowRateFactors != nil || raw.Threat.UAWindowRateFactors != nil {
+		return false
+	}
+
+	defaults := DefaultThreatConfig()
+	ipFactor, uaFactor := legacyIPHitRateFactor, legacyUAHitRateFactor
+	if raw.Threat.IPHitRateFactor != nil {
+		ipFactor = *raw.Threat.IPHitRateFactor
+	}
+	if raw.Threat.UAHitRateFactor != nil {
+		uaFactor = *raw.Threat.UAHitRateFactor
+	}
+	threat.IPWindowRateFactors = defaults.IPWindowRateFactors.scaledTo(ipFactor)
+	threat.UAWindowRateFactors = defaults.UAWindowRateFactors.scaledTo(uaFactor)
+	threat.IPHitRateFactor, threat.UAHitRateFactor = 0, 0
+	return true
+}
+
+// fillSection sets a config section to base's if it is nil.
+func fillSection[T any](section **T, base *T) {
+	if *section == nil {
+		*section = base
+	}
+}
+
+// fillMissingSections replaces the sections of c that are missing or null with those of base, so a config written
+// before a section existed, or an update that leaves sections out, never has nil sections.
+func fillMissingSections(c *Config, base *Config) {
+	fillSection(&c.Templates, base.Templates)
+	fillSection(&c.Threat, base.Threat)
+	fillSection(&c.Server, base.Server)
+	if c.Server == base.Server {
+		return
+	}
+	fillSection(&c.Server.TarpitConfig, base.Server.TarpitConfig)
+	fillSection(&c.Server.StatsConfig, base.Server.StatsConfig)
+	fillSection(&c.Server.CrawlerFiles, base.Server.CrawlerFiles)
+	fillSection(&c.Server.ConnectionLimits, base.Server.ConnectionLimits)
+	fillSection(&c.Server.Behaviors, base.Server.Behaviors)
+	fillSection(&c.Server.Proxy, base.Server.Proxy)
+	fillSection(&c.Server.ForwardAuth, base.Server.ForwardAuth)
+	fillSection(&c.Server.TLS, base.Server.TLS)
+	fillSection(&c.Server.TCPTarpits, base.Server.TCPTarpits)
+	fillSection(&c.Server.Blocklist, base.Server.Blocklist)
+	fillSection(&c.Server.GoodBots, base.Server.GoodBots)
+}
+
+// ConfigManager handles thread-safe access to configuration and derived state (trusted proxies, routing rules).
 type ConfigManager struct {
 	config       *Config
 	mu           sync.RWMutex
 	trustedCIDRs []*net.IPNet
 	trustedIPs   []net.IP
+	routes       []compiledRoute
+	goodBots     []compiledGoodBot
+	threatRules  []compiledThreatRule
+	upstream     *url.URL
 	configPath   string
 	logger       *slog.Logger
 	tm           *templating.TemplateManager
+	tc           *ThreatCalculator
 }
 
 // NewConfigManager loads the config and initializes the manager.
@@ -149,10 +514,63 @@ func NewConfigManager(path string) (*ConfigManager, error) {
 	if err != nil {
 		return nil, err
 	}
+	fillMissingSections(cfg, &Config{
+		Server:    DefaultServerConfig(),
+		Templates: templating.DefaultConfig(),
+		Threat:    DefaultThreatConfig(),
+	})
+
+	routes, err := compileRoutes(cfg.Server.RoutingRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing rules: %w", err)
+	}
+	if err = cfg.Threat.Stages.Validate(*cfg.Server.TarpitConfig); err != nil {
+		return nil, fmt.Errorf("invalid threat stages: %w", err)
+	}
+	if err = cfg.Threat.Signals.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid threat signals: %w", err)
+	}
+	threatRules, err := compileThreatRules(cfg.Threat.Rules, len(cfg.Threat.Stages))
+	if err != nil {
+		return nil, fmt.Errorf("invalid threat rules: %w", err)
+	}
+	if err = cfg.Server.StatsConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid stats config: %w", err)
+	}
+	if err = cfg.Server.ConnectionLimits.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid connection limits: %w", err)
+	}
+	if err = cfg.Server.Behaviors.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid behaviors: %w", err)
+	}
+	upstream, err := cfg.Server.Proxy.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	if err = cfg.Server.ForwardAuth.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid forward auth: %w", err)
+	}
+	if err = cfg.Server.TLS.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid tls: %w", err)
+	}
+	if err = cfg.Server.TCPTarpits.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid tcp tarpits: %w", err)
+	}
+	if err = cfg.Server.Blocklist.Validate(len(cfg.Threat.Stages)); err != nil {
+		return nil, fmt.Errorf("invalid blocklist: %w", err)
+	}
+	goodBots, err := compileGoodBots(cfg.Server.GoodBots)
+	if err != nil {
+		return nil, fmt.Errorf("invalid good bots: %w", err)
+	}
 
 	cm := &ConfigManager{
-		config:     cfg,
-		configPath: path,
+		config:      cfg,
+		configPath:  path,
+		routes:      routes,
+		goodBots:    goodBots,
+		threatRules: threatRules,
+		upstream:    upstream,
 		// Log to stdout before the application-specific logger is set.
 		logger: slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})),
 	}
@@ -172,6 +590,14 @@ func (cm *ConfigManager) SetTemplateManager(tm *templating.TemplateManager) {
 	}
 }
 
+// SetThreatCalculator registers the threat calculator to receive config updates.
+func (cm *ConfigManager) SetThreatCalculator(tc *ThreatCalculator) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.tc = tc
+	tc.SetConfig(cm.config.Threat, cm.threatRules)
+}
+
 // SetLogger sets the logger. That's about it.
 func (cm *ConfigManager) SetLogger(logger *slog.Logger) {
 	cm.logger = logger
@@ -185,11 +611,58 @@ func (cm *ConfigManager) Get() Config {
 	return *cm.config
 }
 
-// Update updates the configuration, saves it to disk, and refreshes derived state.
+// Update updates the configuration, saves it to disk, and refreshes derived state. Sections left out of newConfig keep
+// their current values.
 func (cm *ConfigManager) Update(newConfig Config) error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
+	fillMissingSections(&newConfig, cm.config)
+
+	routes, err := compileRoutes(newConfig.Server.RoutingRules)
+	if err != nil {
+		return fmt.Errorf("routing rules rejected: %w", err)
+	}
+	if err = newConfig.Threat.Stages.Validate(*newConfig.Server.TarpitConfig); err != nil {
+		return fmt.Errorf("threat stages rejected: %w", err)
+	}
+	if err = newConfig.Threat.Signals.Validate(); err != nil {
+		return fmt.Errorf("threat signals rejected: %w", err)
+	}
+	threatRules, err := compileThreatRules(newConfig.Threat.Rules, len(newConfig.Threat.Stages))
+	if err != nil {
+		return fmt.Errorf("threat rules rejected: %w", err)
+	}
+	if err = newConfig.Server.StatsConfig.Validate(); err != nil {
+		return fmt.Errorf("stats config rejected: %w", err)
+	}
+	if err = newConfig.Server.ConnectionLimits.Validate(); err != nil {
+		return fmt.Errorf("connection limits rejected: %w", err)
+	}
+	if err = newConfig.Server.Behaviors.Validate(); err != nil {
+		return fmt.Errorf("behaviors rejected: %w", err)
+	}
+	upstream, err := newConfig.Server.Proxy.Validate()
+	if err != nil {
+		return fmt.Errorf("proxy rejected: %w", err)
+	}
+	if err = newConfig.Server.ForwardAuth.Validate(); err != nil {
+		return fmt.Errorf("forward auth rejected: %w", err)
+	}
+	if err = newConfig.Server.TLS.Validate(); err != nil {
+		return fmt.Errorf("tls rejected: %w", err)
+	}
+	if err = newConfig.Server.TCPTarpits.Validate(); err != nil {
+		return fmt.Errorf("tcp tarpits rejected: %w", err)
+	}
+	if err = newConfig.Server.Blocklist.Validate(len(newConfig.Threat.Stages)); err != nil {
+		return fmt.Errorf("blocklist rejected: %w", err)
+	}
+	goodBots, err := compileGoodBots(newConfig.Server.GoodBots)
+	if err != nil {
+		return fmt.Errorf("good bots rejected: %w", err)
+	}
+
 	// If we have a TemplateManager, try to apply the new config to it first.
 	if cm.tm != nil {
 		// Keep reference to old template config
@@ -205,6 +678,13 @@ func (cm *ConfigManager) Update(newConfig Config) error {
 	}
 
 	*cm.config = newConfig
+	cm.routes = routes
+	cm.goodBots = goodBots
+	cm.threatRules = threatRules
+	if cm.tc != nil {
+		cm.tc.SetConfig(cm.config.Threat, threatRules)
+	}
+	cm.upstream = upstream
 	cm.refreshCache()
 
 	data, err := json.MarshalIndent(cm.config, "", "  ")
@@ -244,6 +724,26 @@ func (cm *ConfigManager) IsTrusted(ipAddr string) bool {
 	return false
 }
 
+// MatchRoute returns the first routing rule that matches the request, or nil if none do.
+func (cm *ConfigManager) MatchRoute(r *http.Request) *RouteRule {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	for i := range cm.routes {
+		if cm.routes[i].matches(r) {
+			rule := cm.routes[i].rule
+			return &rule
+		}
+	}
+	return nil
+}
+
+// Upstream returns the parsed reverse-proxy upstream URL, or nil if none is configured.
+func (cm *ConfigManager) Upstream() *url.URL {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.upstream
+}
+
 // refreshCache rebuilds the binary IP lists from the config strings.
 func (cm *ConfigManager) refreshCache() {
 	var cidrs []*net.IPNet
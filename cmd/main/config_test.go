@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTarpitOverrides_Apply(t *testing.T) {
+	global := *DefaultServerConfig().TarpitConfig
+	stage := &TarpitOverrides{
+		EnableDripFeed:   ptr(true),
+		DripFeedDelayMin: ptr(5000),
+		DripFeedDelayMax: ptr(15000),
+		Headers:          map[string]string{"Cache-Control": "public", "X-Stage": "5"},
+	}
+	route := &TarpitOverrides{
+		DripFeedDelayMax: ptr(20000),
+		Headers:          map[string]string{"X-Stage": "route"},
+	}
+
+	// Routing rules are applied on top of the stage profile, which is applied on top of the global settings.
+	got := route.Apply(stage.Apply(global))
+
+	if !got.EnableDripFeed {
+		t.Error("EnableDripFeed: stage override was lost")
+	}
+	if got.DripFeedDelayMin != 5000 {
+		t.Errorf("DripFeedDelayMin: got %d, want the stage's 5000", got.DripFeedDelayMin)
+	}
+	if got.DripFeedDelayMax != 20000 {
+		t.Errorf("DripFeedDelayMax: got %d, want the route's 20000", got.DripFeedDelayMax)
+	}
+	if got.DripFeedChunksMax != global.DripFeedChunksMax {
+		t.Errorf("DripFeedChunksMax: got %d, want the global %d", got.DripFeedChunksMax, global.DripFeedChunksMax)
+	}
+	wantHeaders := map[string]string{
+		"Cache-Control": "public",
+		"X-Stage":       "route",
+		"Content-Type":  global.Headers["Content-Type"],
+	}
+	for k, want := range wantHeaders {
+		if got.Headers[k] != want {
+			t.Errorf("header %s: got %q, want %q", k, got.Headers[k], want)
+		}
+	}
+	if global.Headers["Cache-Control"] != "no-store, no-cache" || global.Headers["X-Stage"] != "" {
+		t.Errorf("global headers were modified: %v", global.Headers)
+	}
+
+	var none *TarpitOverrides
+	if got = none.Apply(global); got.DripFeedDelayMax != global.DripFeedDelayMax {
+		t.Errorf("nil overrides: got DripFeedDelayMax %d, want %d", got.DripFeedDelayMax, global.DripFeedDelayMax)
+	}
+}
+
+func TestTarpitOverrides_Validate(t *testing.T) {
+	base := TarpitConfig{
+		DripFeedDelayMin:  500,
+		DripFeedDelayMax:  1000,
+		DripFeedChunksMin: 10, // Invalid, but not touched by the overrides below unless noted.
+		DripFeedChunksMax: 5,
+	}
+
+	testCases := []struct {
+		name      string
+		overrides *TarpitOverrides
+		wantErr   bool
+	}{
+		{"nil", nil, false},
+		{"empty", &TarpitOverrides{}, false},
+		{"valid range", &TarpitOverrides{DripFeedDelayMin: ptr(100), DripFeedDelayMax: ptr(200)}, false},
+		{"max only, still above base min", &TarpitOverrides{DripFeedDelayMax: ptr(600)}, false},
+		{"max only, below base min", &TarpitOverrides{DripFeedDelayMax: ptr(100)}, true},
+		{"min only, above base max", &TarpitOverrides{DripFeedDelayMin: ptr(2000)}, true},
+		{"negative", &TarpitOverrides{InitialDelayMin: ptr(-1), InitialDelayMax: ptr(10)}, true},
+		{"touches invalid base range", &TarpitOverrides{DripFeedChunksMax: ptr(8)}, true},
+		{"fixes invalid base range", &TarpitOverrides{DripFeedChunksMax: ptr(20)}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.overrides.Validate(base)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate: got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_MigratesLegacyRateFactors(t *testing.T) {
+	testCases := []struct {
+		name         string
+		threat       string
+		wantIP       WindowRateFactors
+		wantUA       WindowRateFactors
+		wantIPRate   float64
+		wantRewrites bool
+	}{
+		{"old defaults", `{}`, WindowRateFactors{4, 4, 2}, WindowRateFactors{2, 2, 1}, 0, true},
+		{"custom factors", `{"ip_hit_rate_factor": 20, "ua_hit_rate_factor": 0}`, WindowRateFactors{8, 8, 4}, WindowRateFactors{}, 0, true},
+		{"already windowed", `{"ip_hit_rate_factor": 3, "ip_window_rate_factors": {"1m": 1}}`, WindowRateFactors{1, 4, 2}, WindowRateFactors{2, 2, 1}, 3, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			original := []byte(`{"threat_config": ` + tc.threat + `}`)
+			if err := os.WriteFile(path, original, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if config.Threat.IPWindowRateFactors != tc.wantIP || config.Threat.UAWindowRateFactors != tc.wantUA {
+				t.Errorf("window factors: got %+v and %+v, want %+v and %+v", config.Threat.IPWindowRateFactors,
+					config.Threat.UAWindowRateFactors, tc.wantIP, tc.wantUA)
+			}
+			if config.Threat.IPHitRateFactor != tc.wantIPRate {
+				t.Errorf("IP lifetime factor: got %v, want %v", config.Threat.IPHitRateFactor, tc.wantIPRate)
+			}
+
+			saved, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rewritten := string(saved) != string(original); rewritten != tc.wantRewrites {
+				t.Fatalf("config file rewritten: got %v, want %v", rewritten, tc.wantRewrites)
+			}
+
+			// The migrated config is saved, so loading it again changes nothing.
+			again, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig again: %v", err)
+			}
+			if again.Threat.IPWindowRateFactors != config.Threat.IPWindowRateFactors {
+				t.Errorf("reloaded IP factors: got %+v, want %+v", again.Threat.IPWindowRateFactors, config.Threat.IPWindowRateFactors)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_MigratesLegacyStages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	legacy := `{"threat_config": {
+		"ip_window_rate_factors": {"1m": 4}, "ua_window_rate_factors": {"1m": 2},
+		"stages": {"stage_1": {"enabled": true}, "stage_4": {"enabled": true, "threshold": 60}}
+	}}`
+	if err := os.WriteFile(path, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if got := config.Threat.Stages; len(got) != 5 || got[3].Name != "stage_4" || !got[3].Enabled || got[3].Threshold != 60 {
+		t.Fatalf("stages: got %+v", got)
+	}
+
+	// The file is rewritten with the stages as a list.
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasLegacyStages(saved) {
+		t.Error("saved config still has stage_1 to stage_5 keys")
+	}
+	again, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig again: %v", err)
+	}
+	if !reflect.DeepEqual(again.Threat.Stages, config.Threat.Stages) {
+		t.Errorf("reloaded stages: got %+v, want %+v", again.Threat.Stages, config.Threat.Stages)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
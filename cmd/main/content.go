@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/amenyxia/Sarracenia/pkg/templating"
+)
+
+// extensionKinds maps request path extensions to the content kind served for them.
+var extensionKinds = map[string]templating.ContentKind{
+	".html": templating.KindHTML,
+	".htm":  templating.KindHTML,
+	".json": templating.KindJSON,
+	".xml":  templating.KindXML,
+	".rss":  templating.KindRSS,
+	".atom": templating.KindAtom,
+	".csv":  templating.KindCSV,
+	".txt":  templating.KindText,
+}
+
+// mediaTypeKinds maps Accept header media types to the content kind served for them.
+var mediaTypeKinds = map[string]templating.ContentKind{
+	"text/html":             templating.KindHTML,
+	"application/xhtml+xml": templating.KindHTML,
+	"application/json":      templating.KindJSON,
+	"application/xml":       templating.KindXML,
+	"text/xml":              templating.KindXML,
+	"application/rss+xml":   templating.KindRSS,
+	"application/atom+xml":  templating.KindAtom,
+	"text/csv":              templating.KindCSV,
+	"text/plain":            templating.KindText,
+}
+
+// negotiateKind decides which kind of content to serve for a request. A known path extension wins, then the known
+// media type with the highest quality in the Accept header. Anything else gets HTML.
+func negotiateKind(r *http.Request) templating.ContentKind {
+	if kind, ok := extensionKinds[strings.ToLower(path.Ext(r.URL.Path))]; ok {
+		return kind
+	}
+
+	best, bestQuality := templating.KindHTML, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		kind, ok := mediaTypeKinds[mediaType]
+		if !ok {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = kind, quality
+		}
+	}
+	return best
+}
+
+// pickTemplate chooses the template to serve. A route's templates are always used as listed, whatever their kind.
+// Otherwise, the template comes from the threat stage, then from the enabled templates, then from every loaded
+// template, preferring the requested kind at each step. If no template of that kind is loaded at all, the same steps
+// are repeated for HTML.
+func (s *Server) pickTemplate(kind templating.ContentKind, routeTemplates []string, stageTemplates []WeightedTemplate, enabledTemplates []string, pageRand *rand.Rand, intN func(int) int) string {
+	if len(routeTemplates) > 0 {
+		return routeTemplates[intN(len(routeTemplates))]
+	}
+
+	kinds := []templating.ContentKind{kind}
+	if kind != templating.KindHTML {
+		kinds = append(kinds, templating.KindHTML)
+	}
+	for _, kind := range kinds {
+		var weighted []WeightedTemplate
+		for _, t := range stageTemplates {
+			if templating.KindOf(t.Name) == kind {
+				weighted = append(weighted, t)
+			}
+		}
+		if name := pickWeighted(weighted, intN); name != "" {
+			return name
+		}
+
+		var enabled []string
+		for _, name := range enabledTemplates {
+			if templating.KindOf(name) == kind {
+				enabled = append(enabled, name)
+			}
+		}
+		if len(enabled) > 0 {
+			return enabled[intN(len(enabled))]
+		}
+
+		if name := s.tm.GetRandomTemplateOfKind(kind, templating.WithRand(pageRand)); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// withContentType returns a copy of headers with the Content-Type replaced, however its key is capitalised.
+func withContentType(headers map[string]string, contentType string) map[string]string {
+	result := make(map[string]string, len(headers)+1)
+	for key, value := range headers {
+		if !strings.EqualFold(key, "Content-Type") {
+			result[key] = value
+		}
+	}
+	result["Content-Type"] = contentType
+	return result
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amenyxia/Sarracenia/pkg/templating"
+)
+
+const sitemapXMLNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+var sitemapChangeFreqs = []string{"always", "hourly", "daily", "weekly", "monthly"}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod"`
+	ChangeFreq string `xml:"changefreq"`
+	Priority   string `xml:"priority"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name       `xml:"sitemapindex"`
+	XMLNS    string         `xml:"xmlns,attr"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// registerCrawlerFiles adds the generated robots.txt, sitemap, and llms.txt handlers to the tarpit mux.
+func (s *Server) registerCrawlerFiles(mux *http.ServeMux) {
+	mux.HandleFunc("GET /robots.txt", s.handleRobots)
+	mux.HandleFunc("GET /sitemap.xml", s.handleSitemapIndex)
+	mux.HandleFunc("GET /sitemaps/{file}", s.handleSitemap)
+	mux.HandleFunc("GET /llms.txt", s.handleLLMs)
+}
+
+// beginCrawlerFile does the checks shared by every generated crawler file. If the files are disabled, the request
+// gets a regular tarpit page instead, and whitelisted clients get a 404 like they would anywhere else. In both cases
+// ok is false and the request has already been answered. Otherwise, it returns the config and the random source to
+// generate the file with, which is seeded by the URL if deterministic pages are enabled.
+func (s *Server) beginCrawlerFile(w http.ResponseWriter, r *http.Request) (files CrawlerFilesConfig, rng *rand.Rand, ok bool) {
+	config := s.cm.Get()
+	if !config.Server.CrawlerFiles.Enabled {
+		s.handleTarpit(w, r)
+		return files, nil, false
+	}
+
+	ipAddr := s.getClientIP(r)
+	if s.wlc.Matches(ipAddr, r.UserAgent()) {
+		s.logger.Debug("Request from whitelisted client, serving 404.", "remote_addr", r.RemoteAddr, "user_agent", r.UserAgent())
+		http.NotFound(w, r)
+		return files, nil, false
+	}
+	if _, err := s.requestMetrics(r, ipAddr); err != nil {
+		s.logger.Warn("Failed to log and get metrics", "error", err)
+	}
+	s.logger.Info("Serving crawler file", "path", r.URL.Path, "remote_addr", ipAddr)
+
+	rng = s.pageRand(r, *config.Server.TarpitConfig)
+	if rng == nil {
+		rng = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	return *config.Server.CrawlerFiles, rng, true
+}
+
+// handleRobots serves a robots.txt that disallows the configured bait paths and points to the sitemap.
+func (s *Server) handleRobots(w http.ResponseWriter, r *http.Request) {
+	files, _, ok := s.beginCrawlerFile(w, r)
+	if !ok {
+		return
+	}
+
+	var builder strings.Builder
+	builder.WriteString("User-agent: *\n")
+	for _, path := range files.RobotsDisallow {
+		builder.WriteString("Disallow: " + path + "\n")
+	}
+	builder.WriteString("Allow: /\n\n")
+	builder.WriteString("Sitemap: " + baseURL(r) + "/sitemap.xml\n")
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(builder.String()))
+}
+
+// handleSitemapIndex serves one page of an endless sitemap index. Each page lists the next SitemapIndexSize
+// sitemaps and ends with a link to the following index page.
+func (s *Server) handleSitemapIndex(w http.ResponseWriter, r *http.Request) {
+	files, rng, ok := s.beginCrawlerFile(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	base := baseURL(r)
+	index := sitemapIndex{XMLNS: sitemapXMLNS}
+	first := (page-1)*files.SitemapIndexSize + 1
+	for i := first; i < first+files.SitemapIndexSize; i++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapEntry{
+			Loc:     fmt.Sprintf("%s/sitemaps/%d.xml", base, i),
+			LastMod: randomLastMod(rng),
+		})
+	}
+	index.Sitemaps = append(index.Sitemaps, sitemapEntry{
+		Loc:     fmt.Sprintf("%s/sitemap.xml?page=%d", base, page+1),
+		LastMod: randomLastMod(rng),
+	})
+	s.writeXML(w, index)
+}
+
+// handleSitemap serves a sitemap page full of generated URLs. Any page number is valid.
+func (s *Server) handleSitemap(w http.ResponseWriter, r *http.Request) {
+	number, found := strings.CutSuffix(r.PathValue("file"), ".xml")
+	if _, err := strconv.Atoi(number); !found || err != nil {
+		s.handleTarpit(w, r)
+		return
+	}
+	files, rng, ok := s.beginCrawlerFile(w, r)
+	if !ok {
+		return
+	}
+
+	base := baseURL(r)
+	urlSet := sitemapURLSet{XMLNS: sitemapXMLNS}
+	for _, link := range s.tm.GenerateLinks(files.SitemapURLs, templating.WithRand(rng)) {
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{
+			Loc:        base + link,
+			LastMod:    randomLastMod(rng),
+			ChangeFreq: sitemapChangeFreqs[rng.IntN(len(sitemapChangeFreqs))],
+			Priority:   fmt.Sprintf("%.1f", float64(rng.IntN(10)+1)/10),
+		})
+	}
+	s.writeXML(w, urlSet)
+}
+
+// handleLLMs serves an llms.txt in the usual markdown layout, listing generated pages.
+func (s *Server) handleLLMs(w http.ResponseWriter, r *http.Request) {
+	files, rng, ok := s.beginCrawlerFile(w, r)
+	if !ok {
+		return
+	}
+
+	var builder strings.Builder
+	builder.WriteString("# " + strings.TrimSuffix(s.tm.GenerateSentence(3, templating.WithRand(rng)), ".") + "\n\n")
+	builder.WriteString("> " + s.tm.GenerateSentence(16, templating.WithRand(rng)) + "\n\n")
+	builder.WriteString("## Docs\n\n")
+	base := baseURL(r)
+	for _, link := range s.tm.GenerateLinks(files.LLMsLinks, templating.WithRand(rng)) {
+		title := strings.TrimSuffix(s.tm.GenerateSentence(rng.IntN(3)+2, templating.WithRand(rng)), ".")
+		description := s.tm.GenerateSentence(rng.IntN(8)+6, templating.WithRand(rng))
+		builder.WriteString(fmt.Sprintf("- [%s](%s%s): %s\n", title, base, link, description))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(builder.String()))
+}
+
+// writeXML sends v as an XML document.
+func (s *Server) writeXML(w http.ResponseWriter, v any) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		s.logger.Error("Failed to marshal crawler file", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(data)
+}
+
+// baseURL returns the scheme and host the request was made to, for building absolute URLs.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// randomLastMod returns a date within the past year, formatted for a sitemap.
+func randomLastMod(rng *rand.Rand) string {
+	return time.Now().AddDate(0, 0, -rng.IntN(365)).Format(time.DateOnly)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/natefinch/atomic"
+)
+
+// defaultCrawlerCatalog is the bundled catalogue, written to the configured path if there is no file there yet.
+//
+//go:embed crawlers.json
+var defaultCrawlerCatalog []byte
+
+// CrawlerFamily is a group of user agents run by the same operator, e.g. every OpenAI crawler.
+type CrawlerFamily struct {
+	// ID is the key used for the family in the threat config's crawler_family_bonus and in the stats.
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Patterns are UA globs, matched the same way as UA globs in the whitelist: case-insensitive, and anywhere in the
+	// UA if they have no wildcards.
+	Patterns []string `json:"patterns"`
+}
+
+// CrawlerCatalogFile is the format of the catalogue file.
+type CrawlerCatalogFile struct {
+	Version  string          `json:"version"`
+	Families []CrawlerFamily `json:"families"`
+}
+
+// CrawlerCatalog maps user agents to crawler families. The catalogue is read from a JSON file, so it can be updated
+// without a new release. All methods are concurrent-safe.
+type CrawlerCatalog struct {
+	mu       sync.RWMutex
+	path     string
+	file     CrawlerCatalogFile
+	patterns []*regexp.Regexp // One per family, in the same order.
+	logger   *slog.Logger
+}
+
+// NewCrawlerCatalog loads the catalogue at path, writing the bundled one there first if the file doesn't exist.
+func NewCrawlerCatalog(path string, logger *slog.Logger) (*CrawlerCatalog, error) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for crawler catalogue: %w", err)
+		}
+		if err = atomic.WriteFile(path, bytes.NewReader(defaultCrawlerCatalog)); err != nil {
+			return nil, fmt.Errorf("failed to write default crawler catalogue: %w", err)
+		}
+		logger.Info("Wrote default crawler catalogue", "path", path)
+	}
+
+	c := &CrawlerCatalog{path: path, logger: logger}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// compileCrawlerCatalog parses and validates a catalogue, and compiles each family's patterns into one regex.
+func compileCrawlerCatalog(data []byte) (CrawlerCatalogFile, []*regexp.Regexp, error) {
+	var file CrawlerCatalogFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, nil, fmt.Errorf("invalid crawler catalogue: %w", err)
+	}
+
+	ids := make(map[string]bool)
+	patterns := make([]*regexp.Regexp, 0, len(file.Families))
+	for i, family := range file.Families {
+		if family.ID == "" {
+			return file, nil, fmt.Errorf("family %d: id is required", i)
+		}
+		if ids[family.ID] {
+			return file, nil, fmt.Errorf("family %s: id is used more than once", family.ID)
+		}
+		ids[family.ID] = true
+		if len(family.Patterns) == 0 {
+			return file, nil, fmt.Errorf("family %s: patterns must not be empty", family.ID)
+		}
+
+		alternatives := make([]string, 0, len(family.Patterns))
+		for _, pattern := range family.Patterns {
+			if pattern == "" {
+				return file, nil, fmt.Errorf("family %s: pattern is empty", family.ID)
+			}
+			if _, err := normalizeListValue(listTypeUserAgentGlob, pattern); err != nil {
+				return file, nil, fmt.Errorf("family %s: %w", family.ID, err)
+			}
+			alternatives = append(alternatives, "(?:"+globToRegex(pattern)+")")
+		}
+		pattern, err := regexp.Compile(strings.Join(alternatives, "|"))
+		if err != nil {
+			return file, nil, fmt.Errorf("family %s: patterns don't compile together: %w", family.ID, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return file, patterns, nil
+}
+
+// Reload re-reads the catalogue file. If it is invalid, the current catalogue is kept.
+func (c *CrawlerCatalog) Reload() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read crawler catalogue: %w", err)
+	}
+	file, patterns, err := compileCrawlerCatalog(data)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.file, c.patterns = file, patterns
+	c.mu.Unlock()
+	c.logger.Info("Loaded crawler catalogue", "version", file.Version, "families", len(file.Families))
+	return nil
+}
+
+// Replace validates a new catalogue, saves it over the catalogue file, and switches to it.
+func (c *CrawlerCatalog) Replace(data []byte) error {
+	file, patterns, err := compileCrawlerCatalog(data)
+	if err != nil {
+		return err
+	}
+	if err = atomic.WriteFile(c.path, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write crawler catalogue: %w", err)
+	}
+
+	c.mu.Lock()
+	c.file, c.patterns = file, patterns
+	c.mu.Unlock()
+	c.logger.Info("Replaced crawler catalogue", "version", file.Version, "families", len(file.Families))
+	return nil
+}
+
+// Get returns the current catalogue.
+func (c *CrawlerCatalog) Get() CrawlerCatalogFile {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.file
+}
+
+// Classify returns the ID of the first family with a pattern matching the user agent, or "" if there is none.
+func (c *CrawlerCatalog) Classify(userAgent string) string {
+	if userAgent == "" {
+		return ""
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i, pattern := range c.patterns {
+		if pattern.MatchString(userAgent) {
+			return c.file.Families[i].ID
+		}
+	}
+	return ""
+}
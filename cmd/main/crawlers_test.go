@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileCrawlerCatalog(t *testing.T) {
+	testCases := []struct {
+		name    string
+		catalog string
+		wantErr string
+	}{
+		{"valid", `{"families": [{"id": "a", "patterns": ["ABot", "a-*-crawler"]}]}`, ""},
+		{"invalid json", `{"families": [`, "invalid crawler catalogue"},
+		{"missing id", `{"families": [{"patterns": ["ABot"]}]}`, "id is required"},
+		{"duplicate id", `{"families": [{"id": "a", "patterns": ["ABot"]}, {"id": "a", "patterns": ["BBot"]}]}`, "used more than once"},
+		{"no patterns", `{"families": [{"id": "a", "patterns": []}]}`, "must not be empty"},
+		{"empty pattern", `{"families": [{"id": "a", "patterns": ["ABot", ""]}]}`, "pattern is empty"},
+		{"pattern too long", `{"families": [{"id": "a", "patterns": ["` + strings.Repeat("x", maxListPatternLength+1) + `"]}]}`, "longer than"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, patterns, err := compileCrawlerCatalog([]byte(tc.catalog))
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("compileCrawlerCatalog: %v", err)
+				}
+				if len(patterns) != 1 {
+					t.Errorf("got %d patterns, want one per family", len(patterns))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("compileCrawlerCatalog: got error %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCrawlerCatalog_Classify(t *testing.T) {
+	file, patterns, err := compileCrawlerCatalog(defaultCrawlerCatalog)
+	if err != nil {
+		t.Fatalf("bundled catalogue doesn't compile: %v", err)
+	}
+	c := &CrawlerCatalog{file: file, patterns: patterns}
+
+	testCases := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)", "openai"},
+		{"Mozilla/5.0 (compatible; ClaudeBot/1.0; +claudebot@anthropic.com)", "anthropic"},
+		{"ccbot/2.0 (https://commoncrawl.org/faq/)", "common_crawl"},
+		{"Mozilla/5.0 (Linux; Android 5.0) AppleWebKit/537.36 (KHTML, like Gecko) Mobile Safari/537.36 (compatible; Bytespider; spider-feedback@bytedance.com)", "bytedance"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", ""},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			if got := c.Classify(tc.userAgent); got != tc.want {
+				t.Errorf("Classify(%q): got %q, want %q", tc.userAgent, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/amenyxia/Sarracenia/pkg/tarpit"
+	"github.com/amenyxia/Sarracenia/pkg/templating"
+)
+
+const (
+	endlessParagraphs = 2
+	endlessLinks      = 5
+	// endlessMaxHold caps how long an endless page holds a connection, including when endless_max_hold_sec is 0.
+	endlessMaxHold = time.Hour
+)
+
+// serveEndless keeps appending generated paragraphs and links to a response whose page has already been sent.
+// Content is written at the drip-feed rate until the client disconnects, the maximum hold time passes, or the
+// byte cap is reached. This holds a crawler on one connection instead of letting it fan out to new URLs.
+// If pageRand is set, the filler continues from the page's random source, so it is deterministic as well.
+func (s *Server) serveEndless(w http.ResponseWriter, r *http.Request, tarpitConfig TarpitConfig, pageRand *rand.Rand) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.logger.Warn("ResponseWriter does not support flushing, skipping endless page.")
+		return
+	}
+
+	hold := time.Duration(tarpitConfig.EndlessMaxHoldSec) * time.Second
+	if hold <= 0 || hold > endlessMaxHold {
+		hold = endlessMaxHold
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), hold)
+	defer cancel()
+
+	start := time.Now()
+	sent := 0
+	reason := "byte limit reached"
+
+	dw := tarpit.NewDripWriter(ctx, w, flusher, tarpitConfig.Drip())
+	// The page itself was just sent, so wait before the first piece of filler as well.
+	err := tarpit.SleepContext(ctx, tarpitConfig.Drip().Delay())
+	for err == nil && (tarpitConfig.EndlessMaxBytes <= 0 || sent < tarpitConfig.EndlessMaxBytes) {
+		var filler string
+		filler, err = s.tm.GenerateFiller(tarpitConfig.EndlessMarkovModel, endlessParagraphs, endlessLinks, templating.WithRand(pageRand))
+		if err != nil {
+			s.logger.Error("Failed to generate endless page filler", "error", err)
+			break
+		}
+		if tarpitConfig.EndlessMaxBytes > 0 && sent+len(filler) > tarpitConfig.EndlessMaxBytes {
+			// Cut at a rune boundary so the page doesn't end on half a character.
+			cut := tarpitConfig.EndlessMaxBytes - sent
+			for cut > 0 && !utf8.RuneStart(filler[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				break
+			}
+			filler = filler[:cut]
+		}
+
+		var n int
+		n, err = dw.Write([]byte(filler))
+		sent += n
+	}
+	if err == nil {
+		err = dw.Close()
+	}
+
+	switch {
+	case r.Context().Err() != nil:
+		reason = "client disconnected"
+	case errors.Is(err, context.DeadlineExceeded):
+		reason = "max hold time reached"
+	case err != nil:
+		reason = err.Error()
+	}
+	s.logger.Debug("Endless page ended",
+		"remote_addr", r.RemoteAddr,
+		"reason", reason,
+		"bytes", sent,
+		"duration", time.Since(start))
+}
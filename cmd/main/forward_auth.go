@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ForwardAuthConfig configures the forward-auth endpoint, which lets an existing reverse proxy (nginx auth_request,
+// Traefik forwardAuth, Caddy forward_auth) ask which requests to tarpit. Decisions follow the same rules as
+// reverse-proxy mode, from the proxy config.
+type ForwardAuthConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// DenyStatus is the status returned for requests that should be tarpitted, 401 or 403.
+	DenyStatus int `json:"deny_status"`
+
+	// TarpitURL is the base URL of the tarpit, e.g. "https://tarpit.example.com". Denied requests get a Location
+	// header pointing to it, with the original URI appended. Empty leaves the header out.
+	TarpitURL string `json:"tarpit_url"`
+}
+
+// Validate checks the settings for values that can't be used.
+func (c *ForwardAuthConfig) Validate() error {
+	if c.DenyStatus != http.StatusUnauthorized && c.DenyStatus != http.StatusForbidden {
+		return fmt.Errorf("deny_status must be 401 or 403, got %d", c.DenyStatus)
+	}
+	if c.TarpitURL != "" {
+		tarpitURL, err := url.Parse(c.TarpitURL)
+		if err != nil {
+			return fmt.Errorf("invalid tarpit_url: %w", err)
+		}
+		if (tarpitURL.Scheme != "http" && tarpitURL.Scheme != "https") || tarpitURL.Host == "" {
+			return fmt.Errorf("tarpit_url must be an absolute http or https URL, got %q", c.TarpitURL)
+		}
+	}
+	return nil
+}
+
+// firstHeader returns the value of the first of the named headers that is set.
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// originalRequest rebuilds the request a reverse proxy is asking about from the headers it forwarded. The method,
+// URI, host, and scheme come from the X-Forwarded-* headers sent by Traefik and Caddy, or the X-Original-* headers
+// usually set up for nginx. Other headers, like the User-Agent, are passed through by the proxy as they are.
+func originalRequest(r *http.Request) (*http.Request, error) {
+	method := firstHeader(r, "X-Forwarded-Method", "X-Original-Method")
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	uri := firstHeader(r, "X-Forwarded-Uri", "X-Original-URI")
+	host := firstHeader(r, "X-Forwarded-Host", "X-Original-Host")
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	if originalURL := r.Header.Get("X-Original-URL"); uri == "" && originalURL != "" {
+		parsed, err := url.Parse(originalURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid X-Original-URL: %w", err)
+		}
+		uri = parsed.RequestURI()
+		if host == "" {
+			host = parsed.Host
+		}
+		if proto == "" {
+			proto = parsed.Scheme
+		}
+	}
+	if uri == "" {
+		uri = "/"
+	}
+	parsed, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid original URI %q: %w", uri, err)
+	}
+
+	original := r.Clone(context.WithValue(r.Context(), forwardAuthKey{}, true))
+	original.Method = strings.ToUpper(method)
+	original.URL = parsed
+	original.RequestURI = uri
+	if host != "" {
+		original.Host = host
+	}
+	// The connection state is the proxy's, so whether the client used TLS can only come from the headers.
+	original.TLS = nil
+	if strings.EqualFold(strings.TrimSpace(proto), "https") {
+		original.TLS = &tls.ConnectionState{}
+	}
+	return original, nil
+}
+
+type forwardAuthKey struct{}
+
+// isForwardAuthRequest reports whether a request was rebuilt by originalRequest from a forward-auth request's headers.
+func isForwardAuthRequest(r *http.Request) bool {
+	return r.Context().Value(forwardAuthKey{}) != nil
+}
+
+// handleForwardAuth answers a reverse proxy's question of whether to let a request through to the real site.
+// Allowed requests get 200, and requests to tarpit get the configured deny status, with a Location header pointing
+// to the same URI on the tarpit. Only trusted proxies may ask, and the client IP is taken from the headers they send,
+// as usual.
+func (s *Server) handleForwardAuth(w http.ResponseWriter, r *http.Request) {
+	config := s.cm.Get()
+	forwardAuth := *config.Server.ForwardAuth
+	if !forwardAuth.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+	if !s.cm.IsTrusted(remoteIP) {
+		s.logger.Warn("Forward-auth request from untrusted address", "remote_addr", r.RemoteAddr)
+		respondWithError(w, http.StatusForbidden, "Forbidden: not a trusted proxy")
+		return
+	}
+
+	original, err := originalRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ipAddr := s.getClientIP(r)
+	decision := s.classifyRequest(original, ipAddr, *config.Server.Proxy)
+	s.statsAPI.RecordDecision(original, decision)
+	if decision.Value == nil && decision.Reason != reasonWhitelisted {
+		if _, err = s.statsAPI.LogAndGetMetrics(original, ipAddr); err != nil {
+			s.logger.Warn("Failed to log and get metrics", "error", err)
+		}
+	}
+
+	s.logger.Debug("Forward-auth decision",
+		"tarpit", decision.Tarpit,
+		"reason", decision.Reason,
+		"method", original.Method,
+		"uri", original.RequestURI,
+		"remote_addr", ipAddr,
+		"Threat_level", decision.ThreatLevel)
+
+	w.Header().Set("X-Sarracenia-Reason", decision.Reason)
+	w.Header().Set("X-Sarracenia-Threat-Level", strconv.Itoa(decision.ThreatLevel))
+	if !decision.Tarpit {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if forwardAuth.TarpitURL != "" {
+		w.Header().Set("Location", strings.TrimSuffix(forwardAuth.TarpitURL, "/")+original.RequestURI)
+	}
+	w.WriteHeader(forwardAuth.DenyStatus)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestServer creates a Server with the given config JSON and a fresh stats database, with everything needed to
+// classify and log requests. Templates and the other APIs aren't set up.
+func newTestServer(t *testing.T, config string) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := NewConfigManager(path)
+	if err != nil {
+		t.Fatalf("NewConfigManager: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cm.SetLogger(logger)
+
+	statsDB, err := initDB(filepath.Join(dir, "stats.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = statsDB.Close() })
+	if err = setupStatsSchema(statsDB); err != nil {
+		t.Fatal(err)
+	}
+	crawlers, err := NewCrawlerCatalog(filepath.Join(dir, "crawlers.json"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connections := NewConnectionTracker()
+	statsAPI := NewStatsAPI(statsDB, logger, connections, crawlers)
+	if err = statsAPI.InitializeCache(cm.Get().Server.StatsConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Server{
+		cm:          cm,
+		statsDB:     statsDB,
+		logger:      logger,
+		tc:          NewThreatCalculator(cm.Get().Threat, logger),
+		wlc:         NewWhitelistCache(),
+		blc:         NewBlocklistCache(),
+		statsAPI:    statsAPI,
+		connections: connections,
+		tarpitMux:   http.NewServeMux(),
+	}
+}
+
+const forwardAuthTestConfig = `{
+	"server_config": {
+		"trusted_proxies": ["127.0.0.1"],
+		"proxy": {"tarpit_threshold": 1000, "blocked_user_agents": ["GPTBot"], "bait_paths": ["/wp-login.php"]},
+		"forward_auth": {"enabled": true, "deny_status": 403, "tarpit_url": "https://tarpit.example.com/"}
+	}
+}`
+
+func TestServer_HandleForwardAuth(t *testing.T) {
+	s := newTestServer(t, forwardAuthTestConfig)
+	if err := s.blc.Add(listTypeCIDR, "192.0.2.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	const browser = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+	testCases := []struct {
+		name         string
+		remoteAddr   string
+		headers      map[string]string
+		wantStatus   int
+		wantReason   string
+		wantLocation string
+	}{
+		{
+			name:       "allowed",
+			remoteAddr: "127.0.0.1:50000",
+			headers: map[string]string{
+				"X-Forwarded-Uri":  "/index.html",
+				"X-Forwarded-Host": "www.example.com",
+				"X-Forwarded-For":  "198.51.100.1",
+				"User-Agent":       browser,
+			},
+			wantStatus: http.StatusOK,
+			wantReason: "threat level",
+		},
+		{
+			name:       "bait path from traefik headers",
+			remoteAddr: "127.0.0.1:50000",
+			headers: map[string]string{
+				"X-Forwarded-Method": "POST",
+				"X-Forwarded-Uri":    "/wp-login.php?redirect_to=%2F",
+				"X-Forwarded-For":    "198.51.100.2",
+				"User-Agent":         browser,
+			},
+			wantStatus:   http.StatusForbidden,
+			wantReason:   "bait path",
+			wantLocation: "https://tarpit.example.com/wp-login.php?redirect_to=%2F",
+		},
+		{
+			name:       "blocked user agent from nginx headers",
+			remoteAddr: "127.0.0.1:50000",
+			headers: map[string]string{
+				"X-Original-URL":  "https://www.example.com/docs/page?id=1",
+				"X-Forwarded-For": "198.51.100.3",
+				"User-Agent":      "Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2)",
+			},
+			wantStatus:   http.StatusForbidden,
+			wantReason:   "blocked user agent",
+			wantLocation: "https://tarpit.example.com/docs/page?id=1",
+		},
+		{
+			name:       "blocklisted",
+			remoteAddr: "127.0.0.1:50000",
+			headers: map[string]string{
+				"X-Forwarded-Uri":  "/index.html",
+				"X-Forwarded-Host": "www.example.com",
+				"X-Forwarded-For":  "192.0.2.10",
+				"User-Agent":       browser,
+			},
+			wantStatus:   http.StatusForbidden,
+			wantReason:   "blocklisted",
+			wantLocation: "https://tarpit.example.com/index.html",
+		},
+		{
+			name:       "untrusted proxy",
+			remoteAddr: "203.0.113.9:50000",
+			headers: map[string]string{
+				"X-Forwarded-Uri": "/wp-login.php",
+				"X-Forwarded-For": "198.51.100.4",
+				"User-Agent":      browser,
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid uri",
+			remoteAddr: "127.0.0.1:50000",
+			headers:    map[string]string{"X-Forwarded-Uri": "not a uri"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/forward_auth", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			s.handleForwardAuth(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("status: got %d, want %d", w.Code, tc.wantStatus)
+			}
+			if got := w.Header().Get("X-Sarracenia-Reason"); got != tc.wantReason {
+				t.Errorf("X-Sarracenia-Reason: got %q, want %q", got, tc.wantReason)
+			}
+			if got := w.Header().Get("Location"); got != tc.wantLocation {
+				t.Errorf("Location: got %q, want %q", got, tc.wantLocation)
+			}
+		})
+	}
+}
+
+func TestServer_HandleForwardAuth_Disabled(t *testing.T) {
+	s := newTestServer(t, `{"server_config": {"trusted_proxies": ["127.0.0.1"]}}`)
+	r := httptest.NewRequest(http.MethodGet, "/api/forward_auth", nil)
+	r.RemoteAddr = "127.0.0.1:50000"
+	w := httptest.NewRecorder()
+	s.handleForwardAuth(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestOriginalRequest(t *testing.T) {
+	testCases := []struct {
+		name       string
+		headers    map[string]string
+		wantMethod string
+		wantHost   string
+		wantURI    string
+		wantTLS    bool
+	}{
+		{
+			name:       "defaults",
+			headers:    map[string]string{},
+			wantMethod: http.MethodGet,
+			wantHost:   "sarracenia.internal",
+			wantURI:    "/",
+		},
+		{
+			name: "forwarded headers",
+			headers: map[string]string{
+				"X-Forwarded-Method": "put",
+				"X-Forwarded-Uri":    "/a/b?c=d",
+				"X-Forwarded-Host":   "www.example.com",
+				"X-Forwarded-Proto":  "https",
+			},
+			wantMethod: http.MethodPut,
+			wantHost:   "www.example.com",
+			wantURI:    "/a/b?c=d",
+			wantTLS:    true,
+		},
+		{
+			name: "first of several protos",
+			headers: map[string]string{
+				"X-Forwarded-Proto": "HTTPS, http",
+			},
+			wantMethod: http.MethodGet,
+			wantHost:   "sarracenia.internal",
+			wantURI:    "/",
+			wantTLS:    true,
+		},
+		{
+			name: "plain http",
+			headers: map[string]string{
+				"X-Forwarded-Proto": "http",
+			},
+			wantMethod: http.MethodGet,
+			wantHost:   "sarracenia.internal",
+			wantURI:    "/",
+		},
+		{
+			name: "original url",
+			headers: map[string]string{
+				"X-Original-Method": "HEAD",
+				"X-Original-URL":    "https://www.example.com/x?y=z",
+			},
+			wantMethod: http.MethodHead,
+			wantHost:   "www.example.com",
+			wantURI:    "/x?y=z",
+			wantTLS:    true,
+		},
+		{
+			name: "forwarded proto overrides original url",
+			headers: map[string]string{
+				"X-Original-URL":    "https://www.example.com/",
+				"X-Forwarded-Proto": "http",
+			},
+			wantMethod: http.MethodGet,
+			wantHost:   "www.example.com",
+			wantURI:    "/",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The subrequest itself comes in over TLS, which says nothing about the original request.
+			r := httptest.NewRequest(http.MethodGet, "https://sarracenia.internal/api/forward_auth", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			original, err := originalRequest(r)
+			if err != nil {
+				t.Fatalf("originalRequest: %v", err)
+			}
+			if original.Method != tc.wantMethod {
+				t.Errorf("method: got %q, want %q", original.Method, tc.wantMethod)
+			}
+			if original.Host != tc.wantHost {
+				t.Errorf("host: got %q, want %q", original.Host, tc.wantHost)
+			}
+			if original.URL.RequestURI() != tc.wantURI {
+				t.Errorf("uri: got %q, want %q", original.URL.RequestURI(), tc.wantURI)
+			}
+			if (original.TLS != nil) != tc.wantTLS {
+				t.Errorf("TLS: got %v, want %v", original.TLS != nil, tc.wantTLS)
+			}
+		})
+	}
+}
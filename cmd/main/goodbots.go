@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxGoodBotCacheEntries caps the verification cache. Once it is full, expired entries are dropped, and if that isn't
+// enough the whole cache is, rather than letting a flood of spoofed UAs grow it without bound.
+const maxGoodBotCacheEntries = 100000
+
+// errGoodBotUnverified is returned when none of an IP's hostnames both belong to the bot and resolve back to the IP.
+var errGoodBotUnverified = errors.New("no forward-confirmed hostname under the bot's domains")
+
+// GoodBot is a crawler that is let through the tarpit like a whitelisted client, but only once its IP has been
+// verified, since anyone can send its UA.
+type GoodBot struct {
+	// Name is only used for logging.
+	Name string `json:"name"`
+
+	// UserAgents are UA globs, matched the same way as UA globs in the whitelist. A client with a matching UA is
+	// claiming to be the bot.
+	UserAgents []string `json:"user_agents"`
+
+	// Hostnames are the domains the bot's IPs reverse-resolve under, e.g. "googlebot.com".
+	Hostnames []string `json:"hostnames"`
+}
+
+// GoodBotsConfig holds settings for verifying good bots with forward-confirmed reverse DNS: the client IP's PTR
+// records must include a hostname under one of the bot's domains, and that hostname must resolve back to the IP.
+type GoodBotsConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Resolver is the host:port of the DNS server to use. Empty uses the system resolver.
+	Resolver string `json:"resolver"`
+
+	// LookupTimeoutMs bounds the whole verification of one client, both lookups included.
+	LookupTimeoutMs int `json:"lookup_timeout_ms"`
+
+	// How long verified and failed results are cached. Failures include lookups that timed out.
+	CacheTTLSec       int `json:"cache_ttl_sec"`
+	FailedCacheTTLSec int `json:"failed_cache_ttl_sec"`
+
+	Bots []GoodBot `json:"bots"`
+}
+
+// compiledGoodBot is a GoodBot with its UA globs compiled into one regex, ready for matching.
+type compiledGoodBot struct {
+	bot       GoodBot
+	userAgent *regexp.Regexp
+}
+
+// compileGoodBots validates the good bots config and precompiles the bots' UA globs.
+func compileGoodBots(c *GoodBotsConfig) ([]compiledGoodBot, error) {
+	if c.Resolver != "" {
+		if _, _, err := net.SplitHostPort(c.Resolver); err != nil {
+			return nil, fmt.Errorf("resolver must be host:port: %w", err)
+		}
+	}
+	if c.LookupTimeoutMs <= 0 {
+		return nil, fmt.Errorf("lookup_timeout_ms must be positive")
+	}
+	if c.CacheTTLSec < 0 || c.FailedCacheTTLSec < 0 {
+		return nil, fmt.Errorf("cache TTLs must not be negative")
+	}
+
+	compiled := make([]compiledGoodBot, 0, len(c.Bots))
+	for i, bot := range c.Bots {
+		if len(bot.UserAgents) == 0 || len(bot.Hostnames) == 0 {
+			return nil, fmt.Errorf("bot %d (%s): user_agents and hostnames must not be empty", i, bot.Name)
+		}
+		alternatives := make([]string, 0, len(bot.UserAgents))
+		for _, glob := range bot.UserAgents {
+			if glob == "" {
+				return nil, fmt.Errorf("bot %d (%s): user agent glob is empty", i, bot.Name)
+			}
+			if _, err := normalizeListValue(listTypeUserAgentGlob, glob); err != nil {
+				return nil, fmt.Errorf("bot %d (%s): %w", i, bot.Name, err)
+			}
+			alternatives = append(alternatives, "(?:"+globToRegex(glob)+")")
+		}
+		for _, hostname := range bot.Hostnames {
+			if strings.Trim(hostname, ".") == "" {
+				return nil, fmt.Errorf("bot %d (%s): hostname is empty", i, bot.Name)
+			}
+		}
+		userAgent, err := regexp.Compile(strings.Join(alternatives, "|"))
+		if err != nil {
+			return nil, fmt.Errorf("bot %d (%s): user agent globs don't compile together: %w", i, bot.Name, err)
+		}
+		compiled = append(compiled, compiledGoodBot{bot: bot, userAgent: userAgent})
+	}
+	return compiled, nil
+}
+
+// ownsHostname reports whether a hostname is one of the bot's domains or under one.
+func (b *GoodBot) ownsHostname(hostname string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	for _, domain := range b.Hostnames {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// GoodBot returns the first good bot whose UAs match the user agent, or nil if there is none or good bots are
+// disabled.
+func (cm *ConfigManager) GoodBot(userAgent string) *GoodBot {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	if !cm.config.Server.GoodBots.Enabled {
+		return nil
+	}
+	for i := range cm.goodBots {
+		if cm.goodBots[i].userAgent.MatchString(userAgent) {
+			bot := cm.goodBots[i].bot
+			return &bot
+		}
+	}
+	return nil
+}
+
+// DNSResolver is the part of *net.Resolver used for verification, so a stub can be swapped in.
+type DNSResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// newDNSResolver returns a resolver that queries the DNS server at addr, or the system resolver if addr is empty.
+func newDNSResolver(addr string) DNSResolver {
+	if addr == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// goodBotResult is a cached verification of one IP for one bot.
+type goodBotResult struct {
+	verified bool
+	expires  time.Time
+}
+
+// goodBotLookup is a verification in progress. done is closed once verified is set.
+type goodBotLookup struct {
+	done     chan struct{}
+	verified bool
+}
+
+// GoodBotVerifier verifies clients claiming to be good bots, and caches the results. It is concurrent-safe.
+type GoodBotVerifier struct {
+	cm     *ConfigManager
+	logger *slog.Logger
+
+	// resolver is used for every lookup if set. Otherwise, one is made from the config's resolver.
+	resolver DNSResolver
+
+	mu       sync.Mutex
+	cache    map[string]goodBotResult  // Keyed by bot name and IP.
+	inflight map[string]*goodBotLookup // Same keys; lookups being done, which other requests wait for.
+
+	now func() time.Time
+}
+
+// NewGoodBotVerifier creates a verifier. resolver may be nil to use the one in the config.
+func NewGoodBotVerifier(cm *ConfigManager, resolver DNSResolver, logger *slog.Logger) *GoodBotVerifier {
+	return &GoodBotVerifier{
+		cm:       cm,
+		logger:   logger,
+		resolver: resolver,
+		cache:    make(map[string]goodBotResult),
+		inflight: make(map[string]*goodBotLookup),
+		now:      time.Now,
+	}
+}
+
+// Verify checks whether the client claims to be a good bot, and if so, whether its IP really belongs to the bot. The
+// first request from an IP waits for the DNS lookups, as do any others from it that arrive meanwhile; later ones use
+// the cached result until it expires.
+func (v *GoodBotVerifier) Verify(ip, userAgent string) (claimed, verified bool) {
+	bot := v.cm.GoodBot(userAgent)
+	if bot == nil {
+		return false, false
+	}
+
+	key := bot.Name + "|" + ip
+	now := v.now()
+	v.mu.Lock()
+	if result, found := v.cache[key]; found && now.Before(result.expires) {
+		v.mu.Unlock()
+		return true, result.verified
+	}
+	if lookup, found := v.inflight[key]; found {
+		v.mu.Unlock()
+		<-lookup.done
+		return true, lookup.verified
+	}
+	lookup := &goodBotLookup{done: make(chan struct{})}
+	v.inflight[key] = lookup
+	v.mu.Unlock()
+
+	config := *v.cm.Get().Server.GoodBots
+	resolver := v.resolver
+	if resolver == nil {
+		resolver = newDNSResolver(config.Resolver)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.LookupTimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	hostname, err := verifyGoodBot(ctx, resolver, ip, bot)
+	ttl := time.Duration(config.CacheTTLSec) * time.Second
+	if err != nil {
+		ttl = time.Duration(config.FailedCacheTTLSec) * time.Second
+		v.logger.Info("Good bot failed verification", "bot", bot.Name, "remote_addr", ip, "user_agent", userAgent, "error", err)
+	} else {
+		v.logger.Info("Verified good bot", "bot", bot.Name, "remote_addr", ip, "hostname", hostname)
+	}
+
+	lookup.verified = err == nil
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.inflight, key)
+	close(lookup.done)
+	if len(v.cache) >= maxGoodBotCacheEntries {
+		for k, r := range v.cache {
+			if !now.Before(r.expires) {
+				delete(v.cache, k)
+			}
+		}
+		if len(v.cache) >= maxGoodBotCacheEntries {
+			clear(v.cache)
+		}
+	}
+	v.cache[key] = goodBotResult{verified: err == nil, expires: now.Add(ttl)}
+	return true, err == nil
+}
+
+// verifyGoodBot does the forward-confirmed reverse DNS check, returning the hostname that confirmed the IP.
+func verifyGoodBot(ctx context.Context, resolver DNSResolver, ip string, bot *GoodBot) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+	addr = addr.Unmap()
+
+	hostnames, err := resolver.LookupAddr(ctx, addr.String())
+	if err != nil {
+		return "", fmt.Errorf("reverse lookup failed: %w", err)
+	}
+	for _, hostname := range hostnames {
+		if !bot.ownsHostname(hostname) {
+			continue
+		}
+		addrs, err := resolver.LookupIPAddr(ctx, hostname)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("forward lookup of %s failed: %w", hostname, err)
+			}
+			continue
+		}
+		for _, a := range addrs {
+			if forward, ok := netip.AddrFromSlice(a.IP); ok && forward.Unmap() == addr {
+				return strings.TrimSuffix(hostname, "."), nil
+			}
+		}
+	}
+	return "", errGoodBotUnverified
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubResolver answers lookups from maps, and counts them.
+type stubResolver struct {
+	mu      sync.Mutex
+	ptr     map[string][]string // IP to hostnames.
+	forward map[string][]string // Hostname to IPs.
+	calls   int
+
+	// hang makes lookups wait for their context to end. release, if set, makes them wait for it to be closed.
+	hang    bool
+	release chan struct{}
+	started chan struct{} // Sent to, if set, when a reverse lookup starts.
+}
+
+func (s *stubResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	s.mu.Lock()
+	s.calls++
+	hostnames, found := s.ptr[addr]
+	hang, release, started := s.hang, s.release, s.started
+	s.mu.Unlock()
+
+	if started != nil {
+		started <- struct{}{}
+	}
+	if release != nil {
+		<-release
+	}
+	if hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if !found {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return hostnames, nil
+}
+
+func (s *stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	ips, found := s.forward[host]
+	if !found {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func (s *stubResolver) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// newTestGoodBotVerifier returns a verifier for Googlebot, with a 50ms lookup timeout, and results cached for 60s, or
+// 10s if verification failed.
+func newTestGoodBotVerifier(t *testing.T, resolver DNSResolver) *GoodBotVerifier {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{"server_config": {"good_bots": {
+		"enabled": true, "lookup_timeout_ms": 50, "cache_ttl_sec": 60, "failed_cache_ttl_sec": 10,
+		"bots": [{"name": "Googlebot", "user_agents": ["Googlebot"], "hostnames": ["googlebot.com"]}]
+	}}}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := NewConfigManager(path)
+	if err != nil {
+		t.Fatalf("NewConfigManager: %v", err)
+	}
+	return NewGoodBotVerifier(cm, resolver, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+const testGooglebotUA = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+func TestGoodBotVerifier_Verify(t *testing.T) {
+	resolver := &stubResolver{
+		ptr: map[string][]string{
+			"66.249.66.1":   {"crawl-66-249-66-1.googlebot.com."},
+			"203.0.113.7":   {"crawl-66-249-66-1.googlebot.com.evil.example."},
+			"198.51.100.9":  {"crawl-198-51-100-9.googlebot.com."},
+			"2001:db8::1":   {"other.example.", "crawl-v6.googlebot.com"},
+			"192.0.2.44":    {"nxdomain.googlebot.com."},
+			"66.249.66.200": {"notgooglebot.com."},
+		},
+		forward: map[string][]string{
+			"crawl-66-249-66-1.googlebot.com.":              {"66.249.66.1"},
+			"crawl-66-249-66-1.googlebot.com.evil.example.": {"203.0.113.7"},
+			"crawl-198-51-100-9.googlebot.com.":             {"198.51.100.10"}, // Doesn't resolve back.
+			"crawl-v6.googlebot.com":                        {"66.249.66.1", "2001:db8::1"},
+			"notgooglebot.com.":                             {"66.249.66.200"},
+		},
+	}
+	v := newTestGoodBotVerifier(t, resolver)
+
+	testCases := []struct {
+		name         string
+		ip           string
+		userAgent    string
+		wantClaimed  bool
+		wantVerified bool
+	}{
+		{"not a good bot", "66.249.66.1", "curl/8.0", false, false},
+		{"verified", "66.249.66.1", testGooglebotUA, true, true},
+		{"verified IPv4-mapped", "::ffff:66.249.66.1", testGooglebotUA, true, true},
+		{"verified IPv6", "2001:db8::1", testGooglebotUA, true, true},
+		{"spoofed", "203.0.113.7", testGooglebotUA, true, false},
+		{"lookalike domain", "66.249.66.200", testGooglebotUA, true, false},
+		{"forward mismatch", "198.51.100.9", testGooglebotUA, true, false},
+		{"forward lookup fails", "192.0.2.44", testGooglebotUA, true, false},
+		{"no PTR record", "192.0.2.1", testGooglebotUA, true, false},
+		{"invalid IP", "not an ip", testGooglebotUA, true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claimed, verified := v.Verify(tc.ip, tc.userAgent)
+			if claimed != tc.wantClaimed || verified != tc.wantVerified {
+				t.Errorf("got claimed %v, verified %v, want %v, %v", claimed, verified, tc.wantClaimed, tc.wantVerified)
+			}
+		})
+	}
+}
+
+func TestGoodBotVerifier_Timeout(t *testing.T) {
+	resolver := &stubResolver{hang: true}
+	v := newTestGoodBotVerifier(t, resolver)
+
+	start := time.Now()
+	if claimed, verified := v.Verify("66.249.66.1", testGooglebotUA); !claimed || verified {
+		t.Errorf("got claimed %v, verified %v, want true, false", claimed, verified)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("lookup took %v, want about the 50ms timeout", elapsed)
+	}
+
+	// Timeouts are cached as failures.
+	if _, verified := v.Verify("66.249.66.1", testGooglebotUA); verified || resolver.callCount() != 1 {
+		t.Errorf("after timeout: got verified %v after %d lookups, want false after 1", verified, resolver.callCount())
+	}
+}
+
+func TestGoodBotVerifier_CacheTTL(t *testing.T) {
+	resolver := &stubResolver{
+		ptr:     map[string][]string{"66.249.66.1": {"crawl.googlebot.com."}},
+		forward: map[string][]string{"crawl.googlebot.com.": {"66.249.66.1"}},
+	}
+	v := newTestGoodBotVerifier(t, resolver)
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+
+	verify := func(ip string, wantVerified bool, wantCalls int) {
+		t.Helper()
+		if _, verified := v.Verify(ip, testGooglebotUA); verified != wantVerified {
+			t.Errorf("%s: got verified %v, want %v", ip, verified, wantVerified)
+		}
+		if calls := resolver.callCount(); calls != wantCalls {
+			t.Errorf("%s: got %d lookups, want %d", ip, calls, wantCalls)
+		}
+	}
+
+	// A verified result is kept for cache_ttl_sec.
+	verify("66.249.66.1", true, 2)
+	now = now.Add(59 * time.Second)
+	verify("66.249.66.1", true, 2)
+	now = now.Add(time.Second)
+	verify("66.249.66.1", true, 4)
+
+	// A failed one for failed_cache_ttl_sec, even if the IP would now verify.
+	verify("66.249.66.2", false, 5)
+	resolver.mu.Lock()
+	resolver.ptr["66.249.66.2"] = []string{"crawl.googlebot.com."}
+	resolver.forward["crawl.googlebot.com."] = append(resolver.forward["crawl.googlebot.com."], "66.249.66.2")
+	resolver.mu.Unlock()
+	now = now.Add(9 * time.Second)
+	verify("66.249.66.2", false, 5)
+	now = now.Add(time.Second)
+	verify("66.249.66.2", true, 7)
+}
+
+func TestGoodBotVerifier_DedupesLookups(t *testing.T) {
+	resolver := &stubResolver{
+		ptr:     map[string][]string{"66.249.66.1": {"crawl.googlebot.com."}},
+		forward: map[string][]string{"crawl.googlebot.com.": {"66.249.66.1"}},
+		release: make(chan struct{}),
+		started: make(chan struct{}, 10),
+	}
+	v := newTestGoodBotVerifier(t, resolver)
+
+	const clients = 10
+	results := make(chan bool, clients)
+	verify := func() {
+		_, verified := v.Verify("66.249.66.1", testGooglebotUA)
+		results <- verified
+	}
+	go verify()
+	<-resolver.started
+
+	// The rest arrive while the first lookup is still waiting, and should wait for it rather than do their own.
+	for i := 1; i < clients; i++ {
+		go verify()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(resolver.release)
+
+	for i := 0; i < clients; i++ {
+		if !<-results {
+			t.Error("a client wasn't verified")
+		}
+	}
+	if calls := resolver.callCount(); calls != 2 {
+		t.Errorf("got %d lookups, want 2 (one reverse, one forward)", calls)
+	}
+	select {
+	case <-resolver.started:
+		t.Error("a second reverse lookup was started")
+	default:
+	}
+}
+
+func TestVerifyGoodBot_Errors(t *testing.T) {
+	bot := &GoodBot{Name: "Googlebot", Hostnames: []string{"googlebot.com"}}
+	resolver := &stubResolver{ptr: map[string][]string{"192.0.2.1": {"evil.example."}}}
+
+	if _, err := verifyGoodBot(context.Background(), resolver, "192.0.2.1", bot); !errors.Is(err, errGoodBotUnverified) {
+		t.Errorf("spoofed: got %v, want %v", err, errGoodBotUnverified)
+	}
+	var dnsErr *net.DNSError
+	if _, err := verifyGoodBot(context.Background(), resolver, "192.0.2.2", bot); !errors.As(err, &dnsErr) {
+		t.Errorf("no PTR record: got %v, want a DNS error", err)
+	}
+}
+
+func TestCompileGoodBots(t *testing.T) {
+	valid := func() *GoodBotsConfig {
+		return &GoodBotsConfig{
+			LookupTimeoutMs: 100,
+			Bots:            []GoodBot{{Name: "Bot", UserAgents: []string{"GoodBot", "Good*Crawler/?.0"}, Hostnames: []string{"bot.example"}}},
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		modify  func(c *GoodBotsConfig)
+		wantErr bool
+	}{
+		{"valid", func(c *GoodBotsConfig) {}, false},
+		{"resolver with port", func(c *GoodBotsConfig) { c.Resolver = "1.1.1.1:53" }, false},
+		{"resolver without port", func(c *GoodBotsConfig) { c.Resolver = "1.1.1.1" }, true},
+		{"zero timeout", func(c *GoodBotsConfig) { c.LookupTimeoutMs = 0 }, true},
+		{"negative ttl", func(c *GoodBotsConfig) { c.FailedCacheTTLSec = -1 }, true},
+		{"no user agents", func(c *GoodBotsConfig) { c.Bots[0].UserAgents = nil }, true},
+		{"no hostnames", func(c *GoodBotsConfig) { c.Bots[0].Hostnames = nil }, true},
+		{"empty glob", func(c *GoodBotsConfig) { c.Bots[0].UserAgents = []string{""} }, true},
+		{"glob too long", func(c *GoodBotsConfig) { c.Bots[0].UserAgents = []string{strings.Repeat("x", maxListPatternLength+1)} }, true},
+		{"empty hostname", func(c *GoodBotsConfig) { c.Bots[0].Hostnames = []string{"."} }, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := valid()
+			tc.modify(c)
+			_, err := compileGoodBots(c)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("compileGoodBots: got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigManager_GoodBot(t *testing.T) {
+	compiled, err := compileGoodBots(DefaultServerConfig().GoodBots)
+	if err != nil {
+		t.Fatalf("default good bots don't compile: %v", err)
+	}
+	cm := &ConfigManager{config: &Config{Server: DefaultServerConfig()}, goodBots: compiled}
+	cm.config.Server.GoodBots.Enabled = true
+
+	testCases := []struct {
+		userAgent string
+		want      string
+	}{
+		{testGooglebotUA, "Googlebot"},
+		{"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)", "Bingbot"},
+		{"Mozilla/5.0 (compatible; BINGBOT/2.0)", "Bingbot"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.1 Safari/605.1.15 (Applebot/0.1)", "Applebot"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", ""},
+	}
+
+	for _, tc := range testCases {
+		var got string
+		if bot := cm.GoodBot(tc.userAgent); bot != nil {
+			got = bot.Name
+		}
+		if got != tc.want {
+			t.Errorf("GoodBot(%q): got %q, want %q", tc.userAgent, got, tc.want)
+		}
+	}
+
+	cm.config.Server.GoodBots.Enabled = false
+	if bot := cm.GoodBot(testGooglebotUA); bot != nil {
+		t.Errorf("disabled: got %q, want nil", bot.Name)
+	}
+}
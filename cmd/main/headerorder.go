@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// maxHeaderOrderBuffer caps how much of a connection's input is kept for finding the header order of its requests.
+// Requests with larger headers get no header order.
+const maxHeaderOrderBuffer = 16 << 10
+
+// headerOrderListener keeps the input of its connections, so the order of request headers, which Go's HTTP server
+// doesn't keep, can be read back from it. It only works for plain HTTP/1.x; over TLS, the server decrypts the input
+// itself.
+type headerOrderListener struct {
+	net.Listener
+}
+
+func (l headerOrderListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &headerOrderConn{Conn: conn}, nil
+}
+
+// headerOrderConn keeps the input read from a connection since the end of the last request head it was asked about.
+type headerOrderConn struct {
+	net.Conn
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (c *headerOrderConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.buf = append(c.buf, p[:n]...)
+		if over := len(c.buf) - maxHeaderOrderBuffer; over > 0 {
+			c.buf = append(c.buf[:0], c.buf[over:]...)
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// takeHeaderOrder finds the head of the request with the given request line prefix in the input, returns its header
+// names in order, and drops the input up to the end of the head. It returns nil if the head isn't there.
+func (c *headerOrderConn) takeHeaderOrder(requestLine string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := bytes.Index(c.buf, []byte(requestLine))
+	if start < 0 {
+		return nil
+	}
+	head := c.buf[start:]
+	end := bytes.Index(head, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil
+	}
+
+	lines := strings.Split(string(head[:end]), "\r\n")
+	names := make([]string, 0, len(lines)-1)
+	for _, line := range lines[1:] {
+		// Skip obsolete folded continuation lines.
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if name, _, ok := strings.Cut(line, ":"); ok {
+			names = append(names, textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)))
+		}
+	}
+	c.buf = append(c.buf[:0], head[end+4:]...)
+	return names
+}
+
+type headerOrderConnKey struct{}
+
+type headerOrderKey struct{}
+
+// headerOrderConnContext is the tarpit server's ConnContext. It makes the connection available to withHeaderOrder.
+func headerOrderConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if c, ok := conn.(*headerOrderConn); ok {
+		return context.WithValue(ctx, headerOrderConnKey{}, c)
+	}
+	return ctx
+}
+
+// withHeaderOrder reads the header order of every request from its connection's input, once, before passing the
+// request on. headerOrder returns it.
+func withHeaderOrder(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := r.Context().Value(headerOrderConnKey{}).(*headerOrderConn); ok && r.ProtoMajor == 1 {
+			if order := c.takeHeaderOrder(r.Method + " " + r.RequestURI + " "); order != nil {
+				r = r.WithContext(context.WithValue(r.Context(), headerOrderKey{}, order))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// headerOrder returns the names of the request's headers in the order the client sent them, or nil if they aren't
+// known.
+func headerOrder(r *http.Request) []string {
+	order, _ := r.Context().Value(headerOrderKey{}).([]string)
+	return order
+}
+
+// Relative orders in which browsers send the common headers, leaving out the ones that vary by request.
+var (
+	chromiumHeaderOrder = []string{"Host", "User-Agent", "Accept", "Accept-Encoding", "Accept-Language"}
+	firefoxHeaderOrder  = []string{"Host", "User-Agent", "Accept", "Accept-Language", "Accept-Encoding"}
+)
+
+// hasHeaderOrderAnomaly reports whether a request with a browser UA sent its headers in an order that browser
+// wouldn't. Every browser sends Host first, and Firefox and Chromium send the common headers in a fixed relative
+// order; HTTP libraries and scripts faking a browser UA usually don't.
+func hasHeaderOrderAnomaly(userAgent string, order []string) bool {
+	if len(order) == 0 || !strings.HasPrefix(userAgent, "Mozilla/") {
+		return false
+	}
+	if order[0] != "Host" {
+		return true
+	}
+
+	var expected []string
+	switch {
+	case strings.Contains(userAgent, "Firefox/"):
+		expected = firefoxHeaderOrder
+	case strings.Contains(userAgent, "Chrome/"):
+		expected = chromiumHeaderOrder
+	default:
+		return false
+	}
+	last := -1
+	for _, name := range order {
+		for i, want := range expected {
+			if name == want {
+				if i < last {
+					return true
+				}
+				last = i
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHasHeaderOrderAnomaly(t *testing.T) {
+	testCases := []struct {
+		name      string
+		userAgent string
+		order     string
+		want      bool
+	}{
+		{"unknown order", testFirefoxUA, "", false},
+		{"not a browser", "python-requests/2.32", "User-Agent Host Accept-Encoding Accept", false},
+		{"firefox", testFirefoxUA, "Host User-Agent Accept Accept-Language Accept-Encoding Connection Upgrade-Insecure-Requests", false},
+		{"firefox with chrome's order", testFirefoxUA, "Host User-Agent Accept Accept-Encoding Accept-Language", true},
+		{"chrome", testChromeUA, "Host Connection Sec-Ch-Ua Upgrade-Insecure-Requests User-Agent Accept Sec-Fetch-Site Accept-Encoding Accept-Language", false},
+		{"chrome with firefox's order", testChromeUA, "Host User-Agent Accept Accept-Language Accept-Encoding", true},
+		{"requests library faking chrome", testChromeUA, "Host User-Agent Accept-Encoding Accept Connection", true},
+		{"host not first", testChromeUA, "User-Agent Host Accept", true},
+		{"missing headers are fine", testFirefoxUA, "Host Accept-Encoding", false},
+		{"other browsers only need host first", "Mozilla/5.0 (Macintosh) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15", "Host Accept Accept-Language User-Agent Accept-Encoding", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := hasHeaderOrderAnomaly(tc.userAgent, strings.Fields(tc.order)); got != tc.want {
+				t.Errorf("hasHeaderOrderAnomaly(%q): got %v, want %v", tc.order, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithHeaderOrder(t *testing.T) {
+	orders := make(chan []string, 3)
+	server := &http.Server{
+		Handler: withHeaderOrder(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			orders <- headerOrder(r)
+		})),
+		ConnContext: headerOrderConnContext,
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve(headerOrderListener{listener}) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// Three requests on one keep-alive connection: the same request line twice, and one with a body in between.
+	requests := []struct {
+		head string
+		body string
+		want []string
+	}{
+		{"GET / HTTP/1.1\r\nhost: example.com\r\nuser-agent: test\r\naccept: */*\r\n", "", []string{"Host", "User-Agent", "Accept"}},
+		{"POST / HTTP/1.1\r\nContent-Length: 11\r\nHost: example.com\r\n", "GET / HTTP/", []string{"Content-Length", "Host"}},
+		{"GET / HTTP/1.1\r\nAccept: */*\r\nHost: example.com\r\n", "", []string{"Accept", "Host"}},
+	}
+	for i, req := range requests {
+		if _, err = fmt.Fprintf(conn, "%s\r\n%s", req.head, req.body); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		_ = resp.Body.Close()
+		if got := <-orders; !slices.Equal(got, req.want) {
+			t.Errorf("request %d: got header order %v, want %v", i, got, req.want)
+		}
+	}
+}
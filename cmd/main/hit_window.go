@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// hitWindowBuckets is the number of one-minute buckets kept per IP and user agent: enough for the longest window,
+// plus the minute that is only partly inside it.
+const hitWindowBuckets = 61
+
+// hitWindowSize is the size of an encoded hitWindow: its latest minute, then every bucket.
+const hitWindowSize = 8 + 4*hitWindowBuckets
+
+// WindowRates are hit rates in hits per minute over the last minute, ten minutes and hour. Unlike the lifetime rate,
+// they fall back to zero once a client goes quiet, and jump as soon as it bursts.
+type WindowRates struct {
+	OneMinute  float64 `json:"1m"`
+	TenMinutes float64 `json:"10m"`
+	OneHour    float64 `json:"1h"`
+}
+
+// hitWindow counts hits in a ring of one-minute buckets, indexed by Unix minute.
+type hitWindow struct {
+	buckets [hitWindowBuckets]uint32
+	minute  int64 // The Unix minute of the latest bucket.
+}
+
+// add counts a hit at t. Hits older than the ring are dropped.
+func (w *hitWindow) add(t time.Time) {
+	minute := t.Unix() / 60
+	if minute > w.minute {
+		if minute-w.minute >= hitWindowBuckets {
+			w.buckets = [hitWindowBuckets]uint32{}
+		} else {
+			for m := w.minute + 1; m <= minute; m++ {
+				w.buckets[m%hitWindowBuckets] = 0
+			}
+		}
+		w.minute = minute
+	} else if w.minute-minute >= hitWindowBuckets {
+		return
+	}
+	w.buckets[minute%hitWindowBuckets]++
+}
+
+// hits returns the hits in the bucket for a Unix minute, or 0 if it isn't in the ring.
+func (w *hitWindow) hits(minute int64) float64 {
+	if minute > w.minute || w.minute-minute >= hitWindowBuckets {
+		return 0
+	}
+	return float64(w.buckets[minute%hitWindowBuckets])
+}
+
+// rate returns the hits per minute over the last minutes before now. The oldest bucket is only partly in the window,
+// so it is weighted by how much of it is, which smooths out the jump at each minute boundary.
+func (w *hitWindow) rate(now time.Time, minutes int) float64 {
+	minute := now.Unix() / 60
+	elapsed := float64(now.UnixNano()%int64(time.Minute)) / float64(time.Minute)
+
+	total := w.hits(minute-int64(minutes)) * (1 - elapsed)
+	for m := minute - int64(minutes) + 1; m <= minute; m++ {
+		total += w.hits(m)
+	}
+	return total / float64(minutes)
+}
+
+// rates returns the rates over every window.
+func (w *hitWindow) rates(now time.Time) WindowRates {
+	return WindowRates{
+		OneMinute:  w.rate(now, 1),
+		TenMinutes: w.rate(now, 10),
+		OneHour:    w.rate(now, 60),
+	}
+}
+
+// encode returns the window for storing in the stats database, or nil if it has no hits left in it at now.
+func (w *hitWindow) encode(now time.Time) []byte {
+	if now.Unix()/60-w.minute >= hitWindowBuckets {
+		return nil
+	}
+	data := make([]byte, hitWindowSize)
+	binary.LittleEndian.PutUint64(data, uint64(w.minute))
+	for i, count := range w.buckets {
+		binary.LittleEndian.PutUint32(data[8+4*i:], count)
+	}
+	return data
+}
+
+// decodeHitWindow reads a window stored by encode. Anything else, such as NULL from rows stored before windows
+// existed, is an empty window.
+func decodeHitWindow(data []byte) hitWindow {
+	var w hitWindow
+	if len(data) != hitWindowSize {
+		return w
+	}
+	w.minute = int64(binary.LittleEndian.Uint64(data))
+	for i := range w.buckets {
+		w.buckets[i] = binary.LittleEndian.Uint32(data[8+4*i:])
+	}
+	return w
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHitWindow_Rates(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name string
+		hits []time.Duration // Offsets from start.
+		now  time.Duration
+		want WindowRates
+	}{
+		{"empty", nil, 0, WindowRates{}},
+		{"burst this minute", []time.Duration{0, 10 * time.Second, 20 * time.Second}, 30 * time.Second,
+			WindowRates{OneMinute: 3, TenMinutes: 0.3, OneHour: 0.05}},
+		{"oldest minute is weighted", []time.Duration{0, 0}, 90 * time.Second,
+			WindowRates{OneMinute: 1, TenMinutes: 0.2, OneHour: 2.0 / 60}},
+		{"out of the last minute", []time.Duration{0, 0}, 2 * time.Minute,
+			WindowRates{OneMinute: 0, TenMinutes: 0.2, OneHour: 2.0 / 60}},
+		{"gone quiet", []time.Duration{0, 0, 0}, 2 * time.Hour, WindowRates{}},
+		{"late hits count", []time.Duration{5 * time.Minute, 0}, 5 * time.Minute,
+			WindowRates{OneMinute: 1, TenMinutes: 0.2, OneHour: 2.0 / 60}},
+		{"hits older than the ring are dropped", []time.Duration{2 * time.Hour, 0}, 2 * time.Hour,
+			WindowRates{OneMinute: 1, TenMinutes: 0.1, OneHour: 1.0 / 60}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var w hitWindow
+			for _, hit := range tc.hits {
+				w.add(start.Add(hit))
+			}
+			got := w.rates(start.Add(tc.now))
+			if !closeTo(got.OneMinute, tc.want.OneMinute) || !closeTo(got.TenMinutes, tc.want.TenMinutes) ||
+				!closeTo(got.OneHour, tc.want.OneHour) {
+				t.Errorf("rates: got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHitWindow_Encode(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 30, 0, time.UTC)
+	var w hitWindow
+	w.add(now.Add(-30 * time.Minute))
+	w.add(now)
+	w.add(now)
+
+	data := w.encode(now)
+	if len(data) != hitWindowSize {
+		t.Fatalf("encode: got %d bytes, want %d", len(data), hitWindowSize)
+	}
+	if decoded := decodeHitWindow(data); decoded != w {
+		t.Errorf("decodeHitWindow: got a different window back")
+	}
+	if data = w.encode(now.Add(2 * time.Hour)); data != nil {
+		t.Errorf("encode of an expired window: got %d bytes, want nil", len(data))
+	}
+	if decoded := decodeHitWindow(nil); decoded != (hitWindow{}) {
+		t.Errorf("decodeHitWindow(nil): got %+v, want an empty window", decoded.rates(now))
+	}
+}
+
+func closeTo(got, want float64) bool {
+	return got-want < 1e-9 && want-got < 1e-9
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Actions for requests over the connection limits.
+const (
+	overLimitServe  = "serve"
+	overLimitReject = "reject"
+)
+
+// ConnectionLimitsConfig caps the number of tarpit connections held open at once, i.e. requests that are being
+// delayed, drip-fed, or kept open by an endless page or compressed payload.
+type ConnectionLimitsConfig struct {
+	// MaxHeld is the maximum number of held connections in total. 0 disables the limit.
+	MaxHeld int `json:"max_held"`
+
+	// MaxHeldPerIP is the maximum number of held connections per client IP. 0 disables the limit.
+	MaxHeldPerIP int `json:"max_held_per_ip"`
+
+	// OverLimitAction is what happens to requests over either limit: "serve" sends the page straight away, without
+	// any delay, and "reject" responds with 503 Service Unavailable.
+	OverLimitAction string `json:"over_limit_action"`
+
+	// RetryAfterSec is sent in the Retry-After header of rejected requests.
+	RetryAfterSec int `json:"retry_after_sec"`
+}
+
+// Validate checks that the limits are usable.
+func (c *ConnectionLimitsConfig) Validate() error {
+	if c.MaxHeld < 0 || c.MaxHeldPerIP < 0 || c.RetryAfterSec < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if c.OverLimitAction != overLimitServe && c.OverLimitAction != overLimitReject {
+		return fmt.Errorf("over_limit_action must be %q or %q, got %q", overLimitServe, overLimitReject, c.OverLimitAction)
+	}
+	return nil
+}
+
+// ConnectionTracker counts the tarpit connections currently held, in total and per client IP.
+// All methods are concurrent-safe.
+type ConnectionTracker struct {
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// HeldIP is the number of connections held for a single client IP.
+type HeldIP struct {
+	IPAddress string `json:"ip_address"`
+	Held      int    `json:"held"`
+}
+
+// NewConnectionTracker creates an empty ConnectionTracker.
+func NewConnectionTracker() *ConnectionTracker {
+	return &ConnectionTracker{perIP: make(map[string]int)}
+}
+
+// Acquire registers a held connection for ip, unless that would go over one of the limits. It reports whether the
+// connection was registered, in which case Release must be called once it is done.
+func (t *ConnectionTracker) Acquire(ip string, limits ConnectionLimitsConfig) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if limits.MaxHeld > 0 && t.total >= limits.MaxHeld {
+		return false
+	}
+	if limits.MaxHeldPerIP > 0 && t.perIP[ip] >= limits.MaxHeldPerIP {
+		return false
+	}
+	t.total++
+	t.perIP[ip]++
+	return true
+}
+
+// Release unregisters a connection registered by Acquire.
+func (t *ConnectionTracker) Release(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total--
+	if t.perIP[ip] <= 1 {
+		delete(t.perIP, ip)
+	} else {
+		t.perIP[ip]--
+	}
+}
+
+// Snapshot returns the total number of held connections, and the limit IPs holding the most connections.
+func (t *ConnectionTracker) Snapshot(limit int) (total int, top []HeldIP) {
+	t.mu.Lock()
+	total = t.total
+	top = make([]HeldIP, 0, len(t.perIP))
+	for ip, held := range t.perIP {
+		top = append(top, HeldIP{IPAddress: ip, Held: held})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Held != top[j].Held {
+			return top[i].Held > top[j].Held
+		}
+		return top[i].IPAddress < top[j].IPAddress
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return total, top
+}
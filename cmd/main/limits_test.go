@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestConnectionTracker_Limits(t *testing.T) {
+	limits := ConnectionLimitsConfig{MaxHeld: 3, MaxHeldPerIP: 2, OverLimitAction: overLimitServe}
+	tracker := NewConnectionTracker()
+
+	steps := []struct {
+		name    string
+		ip      string
+		release bool
+		want    bool
+	}{
+		{"first a", "10.0.0.1", false, true},
+		{"second a", "10.0.0.1", false, true},
+		{"third a is over the per-IP limit", "10.0.0.1", false, false},
+		{"first b", "10.0.0.2", false, true},
+		{"first c is over the total limit", "10.0.0.3", false, false},
+		{"release a", "10.0.0.1", true, true},
+		{"first c after a release", "10.0.0.3", false, true},
+		{"third a is over the total limit", "10.0.0.1", false, false},
+	}
+	for _, step := range steps {
+		if step.release {
+			tracker.Release(step.ip)
+			continue
+		}
+		if got := tracker.Acquire(step.ip, limits); got != step.want {
+			t.Errorf("%s: Acquire got %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	total, top := tracker.Snapshot(10)
+	if total != 3 {
+		t.Errorf("Snapshot total: got %d, want 3", total)
+	}
+	wantTop := []HeldIP{{"10.0.0.1", 1}, {"10.0.0.2", 1}, {"10.0.0.3", 1}}
+	if !reflect.DeepEqual(top, wantTop) {
+		t.Errorf("Snapshot top: got %v, want %v", top, wantTop)
+	}
+}
+
+func TestConnectionTracker_Unlimited(t *testing.T) {
+	tracker := NewConnectionTracker()
+	for i := 0; i < 100; i++ {
+		if !tracker.Acquire("10.0.0.1", ConnectionLimitsConfig{}) {
+			t.Fatalf("Acquire %d: rejected with no limits", i)
+		}
+	}
+	if total, _ := tracker.Snapshot(1); total != 100 {
+		t.Errorf("Snapshot total: got %d, want 100", total)
+	}
+}
+
+func TestConnectionTracker_Snapshot(t *testing.T) {
+	tracker := NewConnectionTracker()
+	held := map[string]int{"10.0.0.1": 1, "10.0.0.2": 3, "10.0.0.3": 2, "10.0.0.4": 3}
+	for ip, n := range held {
+		for i := 0; i < n; i++ {
+			tracker.Acquire(ip, ConnectionLimitsConfig{})
+		}
+	}
+	// Released IPs are dropped from the snapshot entirely.
+	tracker.Acquire("10.0.0.5", ConnectionLimitsConfig{})
+	tracker.Release("10.0.0.5")
+
+	total, top := tracker.Snapshot(3)
+	if total != 9 {
+		t.Errorf("total: got %d, want 9", total)
+	}
+	// Sorted by held connections, then by IP.
+	want := []HeldIP{{"10.0.0.2", 3}, {"10.0.0.4", 3}, {"10.0.0.3", 2}}
+	if !reflect.DeepEqual(top, want) {
+		t.Errorf("top: got %v, want %v", top, want)
+	}
+}
+
+func TestConnectionTracker_Concurrent(t *testing.T) {
+	limits := ConnectionLimitsConfig{MaxHeld: 50, MaxHeldPerIP: 5}
+	tracker := NewConnectionTracker()
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 300; i++ {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			if tracker.Acquire(ip, limits) {
+				if total, _ := tracker.Snapshot(0); total > limits.MaxHeld {
+					t.Errorf("total %d is over the limit", total)
+				}
+				tracker.Release(ip)
+			}
+		}(ips[i%len(ips)])
+	}
+	wg.Wait()
+
+	if total, top := tracker.Snapshot(10); total != 0 || len(top) != 0 {
+		t.Errorf("after releasing everything: got total %d and %v, want nothing held", total, top)
+	}
+}
+
+func TestConnectionLimitsConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  ConnectionLimitsConfig
+		wantErr bool
+	}{
+		{"serve", ConnectionLimitsConfig{MaxHeld: 10, OverLimitAction: overLimitServe}, false},
+		{"reject", ConnectionLimitsConfig{MaxHeldPerIP: 1, OverLimitAction: overLimitReject, RetryAfterSec: 30}, false},
+		{"unlimited", ConnectionLimitsConfig{OverLimitAction: overLimitServe}, false},
+		{"negative limit", ConnectionLimitsConfig{MaxHeld: -1, OverLimitAction: overLimitServe}, true},
+		{"negative retry", ConnectionLimitsConfig{OverLimitAction: overLimitReject, RetryAfterSec: -1}, true},
+		{"missing action", ConnectionLimitsConfig{MaxHeld: 10}, true},
+		{"unknown action", ConnectionLimitsConfig{OverLimitAction: "drop"}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate: got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -106,6 +107,9 @@ func run(actionChan chan string) (string, error) {
 	if err = setupWhitelistSchema(authDB); err != nil {
 		logger.Error("Failed to setup whitelist schema", "error", err)
 	}
+	if err = setupBlocklistSchema(authDB); err != nil {
+		logger.Error("Failed to setup blocklist schema", "error", err)
+	}
 
 	apiHttpServer := &http.Server{
 		Addr:              activeConfig.Server.ApiAddr,
@@ -121,6 +125,7 @@ func run(actionChan chan string) (string, error) {
 		ReadTimeout:  5 * time.Second,  // Still protect against slow requests.
 		WriteTimeout: 0,                // No timeout on writes so the tarpit can drip-feed for a long time.
 		IdleTimeout:  60 * time.Second, // Clean up idle keep-alive connections.
+		ConnContext:  headerOrderConnContext,
 	}
 
 	server, err := NewServer(cm, logger, markovDB, authDB, statsDB, actionChan)
@@ -131,23 +136,41 @@ func run(actionChan chan string) (string, error) {
 		return "", fmt.Errorf("failed to create server object: %w", err)
 	}
 
-	tarpitHttpServer.Handler = server.tarpitMux
+	tarpitHttpServer.Handler = server.tarpitHandler
 	apiHttpServer.Handler = server.apiMux
 
+	if tlsConfig := activeConfig.Server.TLS; tlsConfig.Enabled() {
+		certs, err := newCertReloader(*tlsConfig, logger)
+		if err != nil {
+			_ = markovDB.Close()
+			_ = authDB.Close()
+			_ = statsDB.Close()
+			return "", fmt.Errorf("failed to set up TLS: %w", err)
+		}
+		if tlsConfig.Tarpit {
+			tarpitHttpServer.TLSConfig = certs.TLSConfig()
+		}
+		if tlsConfig.API {
+			apiHttpServer.TLSConfig = certs.TLSConfig()
+		}
+	}
+
 	go func() {
-		logger.Info("Starting api/dashboard server", "address", apiHttpServer.Addr)
-		if err := apiHttpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Info("Starting api/dashboard server", "address", apiHttpServer.Addr, "tls", apiHttpServer.TLSConfig != nil)
+		if err := listenAndServe(apiHttpServer); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("Api server failed", "error", err)
 		}
 	}()
 
 	go func() {
-		logger.Info("Starting Sarracenia tarpit server", "address", tarpitHttpServer.Addr)
-		if err := tarpitHttpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Info("Starting Sarracenia tarpit server", "address", tarpitHttpServer.Addr, "tls", tarpitHttpServer.TLSConfig != nil)
+		if err := listenAndServe(tarpitHttpServer); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("Tarpit server failed", "error", err)
 		}
 	}()
 
+	stopTCPTarpits := server.startTCPTarpits(*activeConfig.Server.TCPTarpits)
+
 	action := <-actionChan // Block here until API or OS signal sends an action.
 
 	logger.Info("Stopping servers for " + action + "...")
@@ -161,6 +184,8 @@ func run(actionChan chan string) (string, error) {
 		logger.Error("Tarpit server shutdown failed", "error", err)
 	}
 	logger.Info("HTTP servers stopped.")
+	stopTCPTarpits()
+	logger.Info("TCP tarpits stopped.")
 
 	logger.Info("Closing database connections.")
 	if err = markovDB.Close(); err != nil {
@@ -176,6 +201,26 @@ func run(actionChan chan string) (string, error) {
 	return action, nil
 }
 
+// listenAndServe serves over TLS if the server has a TLS config, and plain HTTP otherwise. Plain HTTP connections
+// keep their input for the server's ConnContext to find the header order in, if it has one.
+func listenAndServe(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	if server.ConnContext == nil {
+		return server.ListenAndServe()
+	}
+	addr := server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(headerOrderListener{listener})
+}
+
 // cleanupOldTempFiles removes any orphaned temporary files from previous runs.
 func cleanupOldTempFiles(logger *slog.Logger) {
 	tempDir := filepath.Join("./data", "tmp")
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Kinds of client list entries, as stored in the type column of the whitelist.
+const (
+	listTypeIP             = "ip"
+	listTypeCIDR           = "cidr"
+	listTypeUserAgent      = "user_agent"
+	listTypeUserAgentGlob  = "user_agent_glob"
+	listTypeUserAgentRegex = "user_agent_regex"
+)
+
+// maxListPatternLength caps UA globs and regexes, which are compiled into a single expression.
+const maxListPatternLength = 512
+
+// normalizeListValue validates a list entry of the given type, and returns it in the form it is stored in, so that
+// e.g. "10.0.0.7/24" and "10.0.0.0/24" are the same entry.
+func normalizeListValue(listType, value string) (string, error) {
+	switch listType {
+	case listTypeIP:
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid IP address %q", value)
+		}
+		return addr.Unmap().String(), nil
+	case listTypeCIDR:
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid CIDR range %q", value)
+		}
+		return normalizePrefix(prefix).String(), nil
+	case listTypeUserAgent:
+		return value, nil
+	case listTypeUserAgentGlob, listTypeUserAgentRegex:
+		if len(value) > maxListPatternLength {
+			return "", fmt.Errorf("pattern is longer than %d characters", maxListPatternLength)
+		}
+		if listType == listTypeUserAgentRegex {
+			if _, err := regexp.Compile(value); err != nil {
+				return "", fmt.Errorf("invalid regex: %w", err)
+			}
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf("unknown list type %q", listType)
+	}
+}
+
+// normalizePrefix masks a prefix, and turns IPv4-mapped IPv6 prefixes into plain IPv4 ones, so they match the
+// unmapped addresses they are looked up with.
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked()
+}
+
+// globToRegex turns a UA glob into a case-insensitive regex. "*" matches any run of characters and "?" any single
+// one, and the glob has to match the whole UA. A glob without wildcards matches anywhere in the UA instead, so
+// "UptimeRobot" is enough to match every UptimeRobot UA.
+func globToRegex(glob string) string {
+	if !strings.ContainsAny(glob, "*?") {
+		return "(?i)" + regexp.QuoteMeta(glob)
+	}
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// ipTrie is a binary prefix trie of CIDR ranges, with separate roots for IPv4 and IPv6. A lookup walks at most one
+// node per address bit, however many ranges there are.
+type ipTrie struct {
+	v4, v6 *ipTrieNode
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	terminal bool // A range ends at this node.
+}
+
+func newIPTrie() *ipTrie {
+	return &ipTrie{v4: &ipTrieNode{}, v6: &ipTrieNode{}}
+}
+
+// root returns the root for the address family of addr.
+func (t *ipTrie) root(addr netip.Addr) *ipTrieNode {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+// Insert adds a masked prefix to the trie.
+func (t *ipTrie) Insert(prefix netip.Prefix) {
+	node := t.root(prefix.Addr())
+	addr := prefix.Addr().AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := addrBit(addr, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+}
+
+// Contains reports whether addr is in any of the ranges in the trie.
+func (t *ipTrie) Contains(addr netip.Addr) bool {
+	node := t.root(addr)
+	bytes := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == len(bytes)*8 {
+			return false
+		}
+		node = node.children[addrBit(bytes, i)]
+	}
+	return false
+}
+
+// addrBit returns bit i of an address, counting from the most significant bit.
+func addrBit(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-i%8)) & 1
+}
+
+// clientMatcher matches clients against a list of IPs, CIDR ranges, exact UAs, and UA globs and regexes. It isn't
+// concurrent-safe; the caches that use it hold their own lock.
+type clientMatcher struct {
+	// entries holds every value by type, so the trie and pattern can be rebuilt when one is removed.
+	entries map[string]map[string]struct{}
+
+	ips        map[string]struct{}
+	cidrs      *ipTrie
+	userAgents map[string]struct{}
+
+	// uaPattern is every UA glob and regex, compiled into one alternation. Nil if there are none.
+	uaPattern *regexp.Regexp
+}
+
+func newClientMatcher() *clientMatcher {
+	return &clientMatcher{
+		entries:    make(map[string]map[string]struct{}),
+		ips:        make(map[string]struct{}),
+		cidrs:      newIPTrie(),
+		userAgents: make(map[string]struct{}),
+	}
+}
+
+// add adds an entry. Entries that don't validate, e.g. ones stored before validation existed, are matched exactly
+// where possible and otherwise ignored. UA globs and regexes only take effect once compilePatterns is called, so a
+// batch of them is compiled once.
+func (m *clientMatcher) add(listType, value string) {
+	if normalized, err := normalizeListValue(listType, value); err == nil {
+		value = normalized
+	} else if listType != listTypeIP {
+		return
+	}
+	if m.entries[listType] == nil {
+		m.entries[listType] = make(map[string]struct{})
+	}
+	m.entries[listType][value] = struct{}{}
+
+	switch listType {
+	case listTypeIP:
+		m.ips[value] = struct{}{}
+	case listTypeCIDR:
+		m.cidrs.Insert(netip.MustParsePrefix(value))
+	case listTypeUserAgent:
+		m.userAgents[value] = struct{}{}
+	}
+}
+
+// remove removes an entry. Removing a UA glob or regex recompiles the rest, and returns the error if they don't
+// compile, in which case uaPattern is left as it was.
+func (m *clientMatcher) remove(listType, value string) error {
+	if normalized, err := normalizeListValue(listType, value); err == nil {
+		value = normalized
+	}
+	delete(m.entries[listType], value)
+
+	switch listType {
+	case listTypeIP:
+		delete(m.ips, value)
+	case listTypeCIDR:
+		m.cidrs = newIPTrie()
+		for cidr := range m.entries[listTypeCIDR] {
+			m.cidrs.Insert(netip.MustParsePrefix(cidr))
+		}
+	case listTypeUserAgent:
+		delete(m.userAgents, value)
+	case listTypeUserAgentGlob, listTypeUserAgentRegex:
+		return m.compilePatterns()
+	}
+	return nil
+}
+
+// compilePatterns rebuilds uaPattern from the glob and regex entries. If they don't compile together, uaPattern is
+// left as it was.
+func (m *clientMatcher) compilePatterns() error {
+	pattern, err := m.patternsWith("", nil)
+	if err != nil {
+		return err
+	}
+	m.uaPattern = pattern
+	return nil
+}
+
+// checkPatterns reports whether the glob and regex entries would still compile with the given values of listType
+// added, without adding them.
+func (m *clientMatcher) checkPatterns(listType string, values []string) error {
+	if listType != listTypeUserAgentGlob && listType != listTypeUserAgentRegex {
+		return nil
+	}
+	_, err := m.patternsWith(listType, values)
+	return err
+}
+
+// patternsWith compiles the glob and regex entries, plus extra values of extraType, into one alternation. It
+// returns nil if there are none.
+func (m *clientMatcher) patternsWith(extraType string, extra []string) (*regexp.Regexp, error) {
+	globs := slices.Collect(maps.Keys(m.entries[listTypeUserAgentGlob]))
+	regexes := slices.Collect(maps.Keys(m.entries[listTypeUserAgentRegex]))
+	switch extraType {
+	case listTypeUserAgentGlob:
+		globs = append(globs, extra...)
+	case listTypeUserAgentRegex:
+		regexes = append(regexes, extra...)
+	}
+
+	var alternatives []string
+	for _, glob := range globs {
+		alternatives = append(alternatives, "(?:"+globToRegex(glob)+")")
+	}
+	for _, regex := range regexes {
+		alternatives = append(alternatives, "(?:"+regex+")")
+	}
+	if len(alternatives) == 0 {
+		return nil, nil
+	}
+	// Each alternative compiles on its own, but together they can still be more than the regexp package accepts.
+	pattern, err := regexp.Compile(strings.Join(alternatives, "|"))
+	if err != nil {
+		return nil, fmt.Errorf("UA globs and regexes don't compile together: %w", err)
+	}
+	return pattern, nil
+}
+
+// matchesIP reports whether the IP matches an IP or CIDR range entry.
+func (m *clientMatcher) matchesIP(ip string) bool {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		addr = addr.Unmap()
+		if _, found := m.ips[addr.String()]; found {
+			return true
+		}
+		return m.cidrs.Contains(addr)
+	}
+	_, found := m.ips[ip]
+	return found
+}
+
+// matchesUserAgent reports whether the UA matches an exact UA, UA glob or UA regex entry.
+func (m *clientMatcher) matchesUserAgent(userAgent string) bool {
+	if _, found := m.userAgents[userAgent]; found {
+		return true
+	}
+	return m.uaPattern != nil && m.uaPattern.MatchString(userAgent)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/netip"
+	"regexp"
+	"testing"
+)
+
+func TestIPTrie_Contains(t *testing.T) {
+	trie := newIPTrie()
+	for _, cidr := range []string{
+		"10.0.0.0/8",
+		"10.1.2.0/24", // Inside 10.0.0.0/8.
+		"192.168.1.128/25",
+		"203.0.113.7/32",
+		"2001:db8::/32",
+		"2001:db8:abcd::/48", // Inside 2001:db8::/32.
+		"fd00:1::/64",
+	} {
+		trie.Insert(netip.MustParsePrefix(cidr))
+	}
+
+	testCases := []struct {
+		addr string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.255.255.255", true},
+		{"10.1.2.3", true},
+		{"11.0.0.0", false},
+		{"9.255.255.255", false},
+		{"192.168.1.128", true},
+		{"192.168.1.255", true},
+		{"192.168.1.127", false},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"2001:db8::1", true},
+		{"2001:db8:abcd:1::1", true},
+		{"2001:db9::1", false},
+		{"fd00:1::ffff", true},
+		{"fd00:1:0:1::1", false},
+		// IPv4 ranges don't match IPv6 addresses with the same leading bits, and vice versa.
+		{"a00:1::", false},
+		{"32.1.13.184", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.addr, func(t *testing.T) {
+			if got := trie.Contains(netip.MustParseAddr(tc.addr)); got != tc.want {
+				t.Errorf("Contains(%s): got %v, want %v", tc.addr, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("Everything", func(t *testing.T) {
+		all := newIPTrie()
+		all.Insert(netip.MustParsePrefix("0.0.0.0/0"))
+		if !all.Contains(netip.MustParseAddr("198.51.100.1")) {
+			t.Error("0.0.0.0/0 does not contain 198.51.100.1")
+		}
+		if all.Contains(netip.MustParseAddr("::1")) {
+			t.Error("0.0.0.0/0 contains ::1")
+		}
+	})
+}
+
+func TestGlobToRegex(t *testing.T) {
+	testCases := []struct {
+		name string
+		glob string
+		ua   string
+		want bool
+	}{
+		{"plain matches anywhere", "UptimeRobot", "Mozilla/5.0 (compatible; UptimeRobot/2.0)", true},
+		{"plain ignores case", "uptimerobot", "UptimeRobot/2.0", true},
+		{"plain mismatch", "UptimeRobot", "Pingdom", false},
+		{"plain dot is literal", "v1.0", "v1x0", false},
+		{"plain parens are literal", "(compatible)", "Foo (compatible) Bar", true},
+		{"star", "Mozilla/*", "Mozilla/5.0 (X11)", true},
+		{"star anchors the whole UA", "Mozilla/*", "NotMozilla/5.0", false},
+		{"star matches newlines", "a*b", "a\nb", true},
+		{"question mark", "Bot/?.0", "Bot/2.0", true},
+		{"question mark is one character", "Bot/?.0", "Bot/10.0", false},
+		{"dot is literal next to a wildcard", "*.example.com*", "crawler xexample.com", false},
+		{"plus is literal", "C++ client*", "C++ client 1.0", true},
+		{"brackets are literal", "*[bot]*", "some [bot] here", true},
+		{"brackets are not a class", "*[bot]*", "b", false},
+		{"backslash is literal", `*\d*`, `a\db`, true},
+		{"caret and dollar are literal", "^*$", "^anything$", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			re, err := regexp.Compile(globToRegex(tc.glob))
+			if err != nil {
+				t.Fatalf("globToRegex(%q) does not compile: %v", tc.glob, err)
+			}
+			if got := re.MatchString(tc.ua); got != tc.want {
+				t.Errorf("%q matching %q: got %v, want %v", tc.glob, tc.ua, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClientMatcher_Matches(t *testing.T) {
+	m := newClientMatcher()
+	m.add(listTypeIP, "198.51.100.1")
+	m.add(listTypeCIDR, "10.0.0.7/24") // Stored masked.
+	m.add(listTypeCIDR, "::ffff:172.16.0.0/108")
+	m.add(listTypeUserAgent, "ExactBot/1.0")
+	m.add(listTypeUserAgentGlob, "*Pingdom*")
+	m.add(listTypeUserAgentRegex, `^Uptime-Kuma/\d+`)
+	if err := m.compilePatterns(); err != nil {
+		t.Fatalf("compilePatterns: %v", err)
+	}
+	matches := func(ip, userAgent string) bool {
+		return m.matchesIP(ip) || m.matchesUserAgent(userAgent)
+	}
+
+	testCases := []struct {
+		name string
+		ip   string
+		ua   string
+		want bool
+	}{
+		{"ip", "198.51.100.1", "", true},
+		{"mapped ip", "::ffff:198.51.100.1", "", true},
+		{"cidr", "10.0.0.200", "", true},
+		{"mapped cidr", "172.16.5.5", "", true},
+		{"exact ua", "203.0.113.1", "ExactBot/1.0", true},
+		{"exact ua is exact", "203.0.113.1", "ExactBot/1.0 extra", false},
+		{"glob", "203.0.113.1", "Mozilla/5.0 (compatible; Pingdom.com_bot)", true},
+		{"regex", "203.0.113.1", "Uptime-Kuma/1.23", true},
+		{"nothing", "203.0.113.1", "curl/8.0", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := matches(tc.ip, tc.ua); got != tc.want {
+				t.Errorf("matches(%q, %q): got %v, want %v", tc.ip, tc.ua, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("Remove", func(t *testing.T) {
+		if err := m.remove(listTypeCIDR, "10.0.0.0/24"); err != nil {
+			t.Fatalf("remove: %v", err)
+		}
+		if err := m.remove(listTypeUserAgentGlob, "*Pingdom*"); err != nil {
+			t.Fatalf("remove: %v", err)
+		}
+		if matches("10.0.0.200", "Pingdom") {
+			t.Error("removed CIDR range or glob still matches")
+		}
+		if !m.matchesIP("172.16.5.5") || !m.matchesUserAgent("Uptime-Kuma/2.0") {
+			t.Error("remaining CIDR range or regex no longer matches")
+		}
+	})
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// mmdbMetadataMarker precedes the metadata at the end of an MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbMaxDepth bounds the nesting of decoded values and pointers, so a corrupt file can't recurse forever.
+const mmdbMaxDepth = 32
+
+var errMMDBCorrupt = errors.New("mmdb file is corrupt")
+
+// mmdbReader reads MaxMind DB (.mmdb) files, the format of the GeoLite2 and IPinfo databases. Only what is needed for
+// lookups is implemented. The whole file is read into memory, and is never modified, so lookups are concurrent-safe.
+type mmdbReader struct {
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	dataStart  int  // Offset of the data section.
+	ipv4Start  uint // Node for ::/96, where IPv4 lookups start in an IPv6 tree.
+	ipVersion  uint
+}
+
+// openMMDB reads and checks an .mmdb file.
+func openMMDB(path string) (*mmdbReader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMMDB(data)
+}
+
+// parseMMDB checks the contents of an .mmdb file.
+func parseMMDB(data []byte) (*mmdbReader, error) {
+	markerAt := bytes.LastIndex(data, mmdbMetadataMarker)
+	if markerAt < 0 {
+		return nil, fmt.Errorf("not an mmdb file: metadata marker not found")
+	}
+
+	metadataStart := markerAt + len(mmdbMetadataMarker)
+	metadata, _, err := (&mmdbReader{data: data[metadataStart:]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mmdb metadata: %w", err)
+	}
+	fields, ok := metadata.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid mmdb metadata: not a map")
+	}
+	nodeCount, _ := fields["node_count"].(uint64)
+	recordSize, _ := fields["record_size"].(uint64)
+	ipVersion, _ := fields["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("unsupported mmdb record size %d", recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported mmdb ip version %d", ipVersion)
+	}
+
+	treeSize := nodeCount * recordSize / 4
+	if treeSize+16 > uint64(markerAt) {
+		return nil, errMMDBCorrupt
+	}
+	r := &mmdbReader{
+		data:       data[:markerAt],
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		dataStart:  int(treeSize) + 16, // The tree is followed by 16 zero bytes.
+		ipVersion:  uint(ipVersion),
+	}
+	if ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			if node, err = r.record(node, 0); err != nil {
+				return nil, err
+			}
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a search tree node.
+func (r *mmdbReader) record(node uint, bit int) (uint, error) {
+	size := r.recordSize / 4 // Bytes per node.
+	offset := node * size
+	if offset+size > uint(r.dataStart) {
+		return 0, errMMDBCorrupt
+	}
+	b := r.data[offset : offset+size]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:])), nil
+	}
+}
+
+// lookup returns the record for an address, or nil if the address isn't in the database.
+func (r *mmdbReader) lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+	node := uint(0)
+	if addr.Is4() && r.ipVersion == 6 {
+		node = r.ipv4Start
+	} else if !addr.Is4() && r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	ip := addr.AsSlice()
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		var err error
+		if node, err = r.record(node, addrBit(ip, i)); err != nil {
+			return nil, err
+		}
+	}
+	if node <= r.nodeCount {
+		// Equal to the node count means no data; still below it means the tree is deeper than an address.
+		return nil, nil
+	}
+
+	// Data records point past the tree and its 16-byte separator, into the data section.
+	dataSection := r.data[r.dataStart:]
+	offset := int(node-r.nodeCount) - 16
+	if offset < 0 || offset >= len(dataSection) {
+		return nil, errMMDBCorrupt
+	}
+	value, _, err := (&mmdbReader{data: dataSection}).decode(offset, 0)
+	return value, err
+}
+
+// decode decodes the value at offset in r.data, returning it and the offset after it. Maps decode to map[string]any,
+// arrays to []any, unsigned integers to uint64, signed ones to int64, and floats to float64.
+func (r *mmdbReader) decode(offset, depth int) (any, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errMMDBCorrupt
+	}
+	next := func(n int) ([]byte, error) {
+		if n < 0 || offset+n > len(r.data) {
+			return nil, errMMDBCorrupt
+		}
+		b := r.data[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	ctrl, err := next(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	kind := int(ctrl[0] >> 5)
+
+	if kind == 1 {
+		// A pointer, to an offset in the data section. Its value is decoded in place of it.
+		ss, vvv := int(ctrl[0]>>3)&0x3, uint(ctrl[0]&0x7)
+		b, err := next(ss + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		var pointer uint
+		switch ss {
+		case 0:
+			pointer = vvv<<8 | uint(b[0])
+		case 1:
+			pointer = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			pointer = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			pointer = uint(binary.BigEndian.Uint32(b))
+		}
+		value, _, err := r.decode(int(pointer), depth+1)
+		return value, offset, err
+	}
+
+	if kind == 0 {
+		b, err := next(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + int(b[0])
+	}
+	size := int(ctrl[0] & 0x1f)
+	if size >= 29 {
+		b, err := next(size - 28)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch size {
+		case 29:
+			size = 29 + int(b[0])
+		case 30:
+			size = 285 + (int(b[0])<<8 | int(b[1]))
+		default:
+			size = 65821 + (int(b[0])<<16 | int(b[1])<<8 | int(b[2]))
+		}
+	}
+
+	switch kind {
+	case 2: // UTF-8 string
+		b, err := next(size)
+		return string(b), offset, err
+	case 3: // double
+		b, err := next(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4, 10: // bytes, and uint128, which no ASN database uses
+		b, err := next(size)
+		return b, offset, err
+	case 5, 6, 9: // uint16, uint32, uint64
+		b, err := next(size)
+		if err != nil || size > 8 {
+			return nil, 0, errMMDBCorrupt
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case 8: // int32
+		b, err := next(size)
+		if err != nil || size > 4 {
+			return nil, 0, errMMDBCorrupt
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), offset, nil
+	case 7: // map
+		m := make(map[string]any, min(size, 64))
+		for i := 0; i < size; i++ {
+			var key, value any
+			if key, offset, err = r.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errMMDBCorrupt
+			}
+			if value, offset, err = r.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]any, 0, min(size, 64))
+		for i := 0; i < size; i++ {
+			var value any
+			if value, offset, err = r.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case 14: // boolean, stored in the size
+		return size != 0, offset, nil
+	case 15: // float
+		b, err := next(4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	default:
+		return nil, 0, errMMDBCorrupt
+	}
+}
+
+// ASNDatabase looks up the autonomous system of IPs in a MaxMind GeoLite2-ASN or IPinfo ASN .mmdb file.
+type ASNDatabase struct {
+	reader *mmdbReader
+}
+
+// OpenASNDatabase reads an ASN database.
+func OpenASNDatabase(path string) (*ASNDatabase, error) {
+	reader, err := openMMDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ASNDatabase{reader: reader}, nil
+}
+
+// Lookup returns the AS number and organization of an IP. ok is false if the IP isn't in the database, or can't be
+// parsed.
+func (db *ASNDatabase) Lookup(ip string) (asn uint32, organization string, ok bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return 0, "", false
+	}
+	value, err := db.reader.lookup(addr)
+	fields, isMap := value.(map[string]any)
+	if err != nil || !isMap {
+		return 0, "", false
+	}
+
+	// MaxMind uses autonomous_system_number, and IPinfo an "asn" string such as "AS13335".
+	switch n := fields["autonomous_system_number"].(type) {
+	case uint64:
+		asn = uint32(n)
+	default:
+		if s, isString := fields["asn"].(string); isString {
+			parsed, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "AS"), 10, 32)
+			if err != nil {
+				return 0, "", false
+			}
+			asn = uint32(parsed)
+		}
+	}
+	if asn == 0 {
+		return 0, "", false
+	}
+	for _, key := range []string{"autonomous_system_organization", "as_name", "name"} {
+		if s, isString := fields[key].(string); isString {
+			organization = s
+			break
+		}
+	}
+	return asn, organization, true
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The tests build their .mmdb files with the encoder below, rather than checking in binary fixtures.
+
+// mmdbTestPointer encodes as a pointer of the given size class (0 to 3) to an offset in the data section.
+type mmdbTestPointer struct {
+	sizeClass int
+	offset    int
+}
+
+// mmdbTestInt32 and mmdbTestUint16 encode as the MaxMind DB types of the same name; plain ints encode as uint32.
+type (
+	mmdbTestInt32  int32
+	mmdbTestUint16 uint16
+)
+
+// mmdbControl encodes the control byte, and the extended type and size bytes, of a value.
+func mmdbControl(kind, size int) []byte {
+	var b []byte
+	if kind > 7 {
+		b = []byte{0, byte(kind - 7)}
+	} else {
+		b = []byte{byte(kind << 5)}
+	}
+	switch {
+	case size < 29:
+		b[0] |= byte(size)
+	case size < 285:
+		b[0] |= 29
+		b = append(b, byte(size-29))
+	case size < 65821:
+		b[0] |= 30
+		b = binary.BigEndian.AppendUint16(b, uint16(size-285))
+	default:
+		b[0] |= 31
+		size -= 65821
+		b = append(b, byte(size>>16), byte(size>>8), byte(size))
+	}
+	return b
+}
+
+// mmdbEncode encodes a value in the MaxMind DB data format. Maps are encoded with their keys sorted.
+func mmdbEncode(v any) []byte {
+	switch v := v.(type) {
+	case string:
+		return append(mmdbControl(2, len(v)), v...)
+	case float64:
+		return binary.BigEndian.AppendUint64(mmdbControl(3, 8), math.Float64bits(v))
+	case float32:
+		return binary.BigEndian.AppendUint32(mmdbControl(15, 4), math.Float32bits(v))
+	case []byte:
+		return append(mmdbControl(4, len(v)), v...)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		return mmdbControl(14, size)
+	case mmdbTestUint16:
+		return binary.BigEndian.AppendUint16(mmdbControl(5, 2), uint16(v))
+	case int:
+		b := binary.BigEndian.AppendUint32(nil, uint32(v))
+		for len(b) > 0 && b[0] == 0 {
+			b = b[1:]
+		}
+		return append(mmdbControl(6, len(b)), b...)
+	case uint64:
+		return binary.BigEndian.AppendUint64(mmdbControl(9, 8), v)
+	case mmdbTestInt32:
+		return binary.BigEndian.AppendUint32(mmdbControl(8, 4), uint32(v))
+	case map[string]any:
+		b := mmdbControl(7, len(v))
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			b = append(b, mmdbEncode(key)...)
+			b = append(b, mmdbEncode(v[key])...)
+		}
+		return b
+	case []any:
+		b := mmdbControl(11, len(v))
+		for _, value := range v {
+			b = append(b, mmdbEncode(value)...)
+		}
+		return b
+	case mmdbTestPointer:
+		p := v.offset
+		switch v.sizeClass {
+		case 0:
+			return []byte{0x20 | byte(p>>8), byte(p)}
+		case 1:
+			p -= 2048
+			return []byte{0x28 | byte(p>>16), byte(p >> 8), byte(p)}
+		case 2:
+			p -= 526336
+			return []byte{0x30 | byte(p>>24), byte(p >> 16), byte(p >> 8), byte(p)}
+		default:
+			return binary.BigEndian.AppendUint32([]byte{0x38}, uint32(p))
+		}
+	}
+	panic("mmdbEncode: unsupported type")
+}
+
+// buildMMDB builds an .mmdb file with the given data section, mapping each network to the value at an offset in it.
+func buildMMDB(recordSize, ipVersion int, data []byte, networks map[string]int) []byte {
+	// Each node is its left and right record: a node index, -1 for no data, or -2-offset for a data offset.
+	nodes := [][2]int{{-1, -1}}
+	for network, offset := range networks {
+		prefix := netip.MustParsePrefix(network)
+		bits := prefix.Addr().AsSlice()
+		length := prefix.Bits()
+		if prefix.Addr().Is4() && ipVersion == 6 {
+			// IPv4 networks are under ::/96 in an IPv6 tree.
+			bits = append(make([]byte, 12), bits...)
+			length += 96
+		}
+		node := 0
+		for i := 0; i < length-1; i++ {
+			bit := addrBit(bits, i)
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+		nodes[node][addrBit(bits, length-1)] = -2 - offset
+	}
+
+	count := len(nodes)
+	value := func(record int) uint32 {
+		switch {
+		case record == -1:
+			return uint32(count)
+		case record < -1:
+			return uint32(count + 16 + (-2 - record))
+		default:
+			return uint32(record)
+		}
+	}
+	var file []byte
+	for _, node := range nodes {
+		left, right := value(node[0]), value(node[1])
+		switch recordSize {
+		case 24:
+			file = append(file, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			file = append(file, byte(left>>16), byte(left>>8), byte(left), byte(left>>24<<4|right>>24&0x0f),
+				byte(right>>16), byte(right>>8), byte(right))
+		default:
+			file = binary.BigEndian.AppendUint32(file, left)
+			file = binary.BigEndian.AppendUint32(file, right)
+		}
+	}
+	file = append(file, make([]byte, 16)...)
+	file = append(file, data...)
+	file = append(file, mmdbMetadataMarker...)
+	return append(file, mmdbEncode(map[string]any{
+		"node_count":                  count,
+		"record_size":                 recordSize,
+		"ip_version":                  ipVersion,
+		"database_type":               "GeoLite2-ASN",
+		"languages":                   []any{"en"},
+		"binary_format_major_version": mmdbTestUint16(2),
+		"binary_format_minor_version": mmdbTestUint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"description":                 map[string]any{"en": "test"},
+	})...)
+}
+
+// testASNData is a data section with MaxMind and IPinfo ASN records, whose organizations are reached through a
+// pointer of each size class, and the networks that map to them.
+func testASNData() ([]byte, map[string]int) {
+	var data []byte
+	appendValue := func(v any) int {
+		offset := len(data)
+		data = append(data, mmdbEncode(v)...)
+		return offset
+	}
+	padTo := func(offset int) {
+		data = append(data, make([]byte, offset-len(data))...)
+	}
+
+	google := appendValue("GOOGLE")
+	padTo(2048 + 100)
+	long := appendValue(strings.Repeat("X", 300))
+	padTo(526336 + 100)
+	cloudflare := appendValue("Cloudflare, Inc.")
+	example := appendValue("EXAMPLE")
+
+	networks := map[string]int{
+		"1.2.3.0/24": appendValue(map[string]any{
+			"autonomous_system_number":       15169,
+			"autonomous_system_organization": mmdbTestPointer{0, google},
+		}),
+		"10.0.0.0/8": appendValue(map[string]any{
+			"autonomous_system_number":       64512,
+			"autonomous_system_organization": mmdbTestPointer{1, long},
+		}),
+		"2001:db8::/32": appendValue(map[string]any{
+			"asn":    "AS13335",
+			"name":   mmdbTestPointer{2, cloudflare},
+			"domain": "cloudflare.com",
+		}),
+		"192.0.2.0/24": appendValue(map[string]any{
+			"autonomous_system_number":       uint64(64500),
+			"autonomous_system_organization": mmdbTestPointer{3, example},
+		}),
+		"203.0.113.0/24": appendValue(map[string]any{"autonomous_system_number": 0}),
+	}
+	// A record that is itself a pointer to another record.
+	networks["198.51.100.0/24"] = appendValue(mmdbTestPointer{3, networks["1.2.3.0/24"]})
+	return data, networks
+}
+
+func TestASNDatabase_Lookup(t *testing.T) {
+	data, networks := testASNData()
+
+	testCases := []struct {
+		ip      string
+		wantASN uint32
+		wantOrg string
+		wantOK  bool
+	}{
+		{"1.2.3.4", 15169, "GOOGLE", true},
+		{"::ffff:1.2.3.255", 15169, "GOOGLE", true},
+		{"10.200.0.1", 64512, strings.Repeat("X", 300), true},
+		{"2001:db8:1::1", 13335, "Cloudflare, Inc.", true},
+		{"192.0.2.1", 64500, "EXAMPLE", true},
+		{"198.51.100.7", 15169, "GOOGLE", true},
+		{"203.0.113.1", 0, "", false}, // ASN 0 isn't an ASN.
+		{"1.2.4.1", 0, "", false},
+		{"8.8.8.8", 0, "", false},
+		{"2001:db9::1", 0, "", false},
+		{"::1", 0, "", false},
+		{"not an ip", 0, "", false},
+	}
+
+	for _, recordSize := range []int{24, 28, 32} {
+		path := filepath.Join(t.TempDir(), "asn.mmdb")
+		if err := os.WriteFile(path, buildMMDB(recordSize, 6, data, networks), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		db, err := OpenASNDatabase(path)
+		if err != nil {
+			t.Fatalf("%d-bit records: OpenASNDatabase: %v", recordSize, err)
+		}
+		for _, tc := range testCases {
+			asn, org, ok := db.Lookup(tc.ip)
+			if asn != tc.wantASN || org != tc.wantOrg || ok != tc.wantOK {
+				t.Errorf("%d-bit records: Lookup(%q): got %d, %.20q, %v, want %d, %.20q, %v",
+					recordSize, tc.ip, asn, org, ok, tc.wantASN, tc.wantOrg, tc.wantOK)
+			}
+		}
+	}
+}
+
+func TestMMDBReader_Record(t *testing.T) {
+	// Node 1 of each tree, after a node of zeros, with a left record of 0xa123456 (0x123456 for 24 bits) and a right
+	// one of 0xb789abc (0x789abc). 28-bit records keep the high nibble of each in the middle byte.
+	testCases := []struct {
+		recordSize          uint
+		node                []byte
+		wantLeft, wantRight uint
+	}{
+		{24, []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc}, 0x123456, 0x789abc},
+		{28, []byte{0x12, 0x34, 0x56, 0xab, 0x78, 0x9a, 0xbc}, 0xa123456, 0xb789abc},
+		{32, []byte{0x0a, 0x12, 0x34, 0x56, 0x0b, 0x78, 0x9a, 0xbc}, 0xa123456, 0xb789abc},
+	}
+
+	for _, tc := range testCases {
+		size := len(tc.node)
+		r := &mmdbReader{
+			data:       append(make([]byte, size), tc.node...),
+			nodeCount:  2,
+			recordSize: tc.recordSize,
+			dataStart:  2 * size,
+		}
+		left, errLeft := r.record(1, 0)
+		right, errRight := r.record(1, 1)
+		if left != tc.wantLeft || right != tc.wantRight || errLeft != nil || errRight != nil {
+			t.Errorf("%d-bit records: got %#x, %#x, want %#x, %#x", tc.recordSize, left, right, tc.wantLeft, tc.wantRight)
+		}
+		if _, err := r.record(2, 0); err != errMMDBCorrupt {
+			t.Errorf("%d-bit records: node past the tree: got %v, want %v", tc.recordSize, err, errMMDBCorrupt)
+		}
+	}
+}
+
+func TestMMDBReader_IPv4Tree(t *testing.T) {
+	data, networks := testASNData()
+	delete(networks, "2001:db8::/32")
+	r, err := parseMMDB(buildMMDB(24, 4, data, networks))
+	if err != nil {
+		t.Fatalf("parseMMDB: %v", err)
+	}
+	db := &ASNDatabase{reader: r}
+
+	if asn, _, ok := db.Lookup("1.2.3.4"); !ok || asn != 15169 {
+		t.Errorf("Lookup(1.2.3.4): got %d, %v, want 15169, true", asn, ok)
+	}
+	if asn, _, ok := db.Lookup("::ffff:10.0.0.1"); !ok || asn != 64512 {
+		t.Errorf("Lookup(::ffff:10.0.0.1): got %d, %v, want 64512, true", asn, ok)
+	}
+	if _, _, ok := db.Lookup("2001:db8::1"); ok {
+		t.Error("Lookup(2001:db8::1): found an IPv6 address in an IPv4 tree")
+	}
+}
+
+func TestMMDBReader_DecodeTypes(t *testing.T) {
+	record := map[string]any{
+		"string": "héllo",
+		"double": 1.5,
+		"float":  float32(0.25),
+		"bytes":  []byte{1, 2, 3},
+		"uint16": mmdbTestUint16(65535),
+		"uint32": 70000,
+		"uint64": uint64(math.MaxUint64),
+		"int32":  mmdbTestInt32(-5),
+		"true":   true,
+		"false":  false,
+		"array":  []any{"a", 1, []any{}},
+		"map":    map[string]any{"nested": map[string]any{}},
+		"empty":  "",
+		"long":   strings.Repeat("y", 70000),
+	}
+	r, err := parseMMDB(buildMMDB(32, 6, mmdbEncode(record), map[string]int{"::/0": 0}))
+	if err != nil {
+		t.Fatalf("parseMMDB: %v", err)
+	}
+	value, err := r.lookup(netip.MustParseAddr("2001:db8::1"))
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	got, ok := value.(map[string]any)
+	if !ok {
+		t.Fatalf("got a %T, want a map", value)
+	}
+
+	want := map[string]any{
+		"string": "héllo",
+		"double": 1.5,
+		"float":  0.25,
+		"bytes":  []byte{1, 2, 3},
+		"uint16": uint64(65535),
+		"uint32": uint64(70000),
+		"uint64": uint64(math.MaxUint64),
+		"int32":  int64(-5),
+		"true":   true,
+		"false":  false,
+		"array":  []any{"a", uint64(1), []any{}},
+		"map":    map[string]any{"nested": map[string]any{}},
+		"empty":  "",
+		"long":   strings.Repeat("y", 70000),
+	}
+	for key, wantValue := range want {
+		if !mmdbValuesEqual(got[key], wantValue) {
+			t.Errorf("%s: got %#.40v, want %#.40v", key, got[key], wantValue)
+		}
+	}
+}
+
+// mmdbValuesEqual compares decoded values, which may be maps, slices or scalars.
+func mmdbValuesEqual(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			if !mmdbValuesEqual(value, b[key]) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, mmdbValuesEqual)
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && slices.Equal(a, b)
+	default:
+		return a == b
+	}
+}
+
+func TestParseMMDB_Invalid(t *testing.T) {
+	valid := buildMMDB(24, 6, mmdbEncode(map[string]any{"autonomous_system_number": 1}), map[string]int{"1.0.0.0/8": 0})
+	markerAt := bytes.LastIndex(valid, mmdbMetadataMarker)
+	withMetadata := func(metadata any) []byte {
+		file := slices.Clone(valid[:markerAt+len(mmdbMetadataMarker)])
+		return append(file, mmdbEncode(metadata)...)
+	}
+
+	testCases := []struct {
+		name    string
+		file    []byte
+		wantErr string
+	}{
+		{"empty", nil, "metadata marker not found"},
+		{"no marker", []byte("not an mmdb file"), "metadata marker not found"},
+		{"truncated metadata", valid[:len(valid)-5], "invalid mmdb metadata"},
+		{"metadata not a map", withMetadata("metadata"), "invalid mmdb metadata"},
+		{"record size", withMetadata(map[string]any{"node_count": 1, "record_size": 16, "ip_version": 6}), "unsupported mmdb record size 16"},
+		{"ip version", withMetadata(map[string]any{"node_count": 1, "record_size": 24, "ip_version": 5}), "unsupported mmdb ip version 5"},
+		{"node count", withMetadata(map[string]any{"node_count": 1 << 30, "record_size": 24, "ip_version": 6}), "corrupt"},
+		{"huge node count", withMetadata(map[string]any{"node_count": uint64(1) << 62, "record_size": 32, "ip_version": 6}), "corrupt"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseMMDB(tc.file)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestMMDBReader_CorruptData(t *testing.T) {
+	self := mmdbEncode(mmdbTestPointer{0, 0})
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{"pointer loop", self},
+		{"pointer past the end", mmdbEncode(mmdbTestPointer{3, 1 << 30})},
+		{"truncated string", mmdbEncode("truncated")[:5]},
+		{"truncated size", mmdbControl(2, 70000)[:2]},
+		{"truncated map", mmdbEncode(map[string]any{"a": "b", "c": "d"})[:6]},
+		{"map key not a string", append(mmdbControl(7, 1), append(mmdbEncode(1), mmdbEncode(1)...)...)},
+		{"uint too long", append(mmdbControl(6, 9), make([]byte, 9)...)},
+		{"int32 too long", append(mmdbControl(8, 5), make([]byte, 5)...)},
+		{"unknown type", []byte{0x00, 0xff}},
+		{"deep nesting", []byte(strings.Repeat("\xe1\x41a", mmdbMaxDepth+2))}, // {"a": {"a": ...
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := parseMMDB(buildMMDB(24, 6, tc.data, map[string]int{"1.0.0.0/8": 0}))
+			if err != nil {
+				t.Fatalf("parseMMDB: %v", err)
+			}
+			if _, err = r.lookup(netip.MustParseAddr("1.2.3.4")); err != errMMDBCorrupt {
+				t.Errorf("got %v, want %v", err, errMMDBCorrupt)
+			}
+			if _, _, ok := (&ASNDatabase{reader: r}).Lookup("1.2.3.4"); ok {
+				t.Error("Lookup found a corrupt record")
+			}
+		})
+	}
+
+	t.Run("record past the data", func(t *testing.T) {
+		file := buildMMDB(24, 6, mmdbEncode("x"), map[string]int{"1.0.0.0/8": 1000})
+		r, err := parseMMDB(file)
+		if err != nil {
+			t.Fatalf("parseMMDB: %v", err)
+		}
+		if _, err = r.lookup(netip.MustParseAddr("1.2.3.4")); err != errMMDBCorrupt {
+			t.Errorf("got %v, want %v", err, errMMDBCorrupt)
+		}
+	})
+}
+
+// TestMMDBReader_NoPanics truncates the data section at every length, and flips every byte of a whole file, and
+// checks that opening and looking up never panics.
+func TestMMDBReader_NoPanics(t *testing.T) {
+	data := mmdbEncode(map[string]any{
+		"autonomous_system_number":       15169,
+		"autonomous_system_organization": "GOOGLE",
+		"networks":                       []any{"a", map[string]any{"b": 1.5}},
+	})
+	networks := map[string]int{"1.2.3.0/24": 0, "2001:db8::/32": 0}
+	ips := []string{"1.2.3.4", "2001:db8::1", "9.9.9.9"}
+	lookupAll := func(file []byte) {
+		r, err := parseMMDB(file)
+		if err != nil {
+			return
+		}
+		db := &ASNDatabase{reader: r}
+		for _, ip := range ips {
+			db.Lookup(ip)
+		}
+	}
+
+	for _, recordSize := range []int{24, 28, 32} {
+		for n := 0; n < len(data); n++ {
+			lookupAll(buildMMDB(recordSize, 6, data[:n], networks))
+		}
+		file := buildMMDB(recordSize, 6, data, networks)
+		for i := range file {
+			for _, flip := range []byte{0x01, 0x80, 0xff} {
+				corrupt := slices.Clone(file)
+				corrupt[i] ^= flip
+				lookupAll(corrupt)
+			}
+		}
+		for n := 0; n < len(file); n++ {
+			lookupAll(file[:n])
+		}
+	}
+}
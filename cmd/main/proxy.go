@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/amenyxia/Sarracenia/pkg/tarpit"
+)
+
+// Decision reasons that other parts of the server check for.
+const (
+	reasonWhitelisted   = "whitelisted"
+	reasonProxyDisabled = "proxy disabled"
+)
+
+// ProxyConfig puts the tarpit listener in front of a real site. Legitimate requests are forwarded to the upstream,
+// and everything else gets the tarpit.
+type ProxyConfig struct {
+	// Enabled turns on reverse-proxy mode. Without it, every request on the tarpit listener is tarpitted.
+	Enabled bool `json:"enabled"`
+
+	// Upstream is the base URL of the site to forward to, e.g. "http://127.0.0.1:8080".
+	Upstream string `json:"upstream"`
+
+	// PreserveHost forwards the client's Host header instead of the upstream's.
+	PreserveHost bool `json:"preserve_host"`
+
+	// TarpitThreshold is the threat score at which requests are tarpitted instead of forwarded.
+	TarpitThreshold int `json:"tarpit_threshold"`
+
+	// BlockedUserAgents are always tarpitted. They match anywhere in the User-Agent, ignoring case.
+	BlockedUserAgents []string `json:"blocked_user_agents"`
+
+	// BaitPaths are path globs, as in routing rules, that are always tarpitted. Routing rules themselves only
+	// choose what the tarpit serves, and don't make a request tarpitted.
+	BaitPaths []string `json:"bait_paths"`
+}
+
+// Validate checks the settings for values that can't be used, and returns the parsed upstream URL.
+// The upstream is only required if the proxy is enabled.
+func (c *ProxyConfig) Validate() (*url.URL, error) {
+	if c.TarpitThreshold < 0 {
+		return nil, fmt.Errorf("tarpit_threshold must not be negative")
+	}
+	for _, pattern := range c.BaitPaths {
+		if pattern == "" {
+			return nil, fmt.Errorf("bait path is empty")
+		}
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return nil, fmt.Errorf("invalid bait path glob %q: %w", pattern, err)
+		}
+	}
+	for _, ua := range c.BlockedUserAgents {
+		if ua == "" {
+			return nil, fmt.Errorf("blocked user agent is empty")
+		}
+	}
+
+	if c.Upstream == "" {
+		if c.Enabled {
+			return nil, fmt.Errorf("upstream is required when the proxy is enabled")
+		}
+		return nil, nil
+	}
+	upstream, err := url.Parse(c.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream: %w", err)
+	}
+	if (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("upstream must be an absolute http or https URL, got %q", c.Upstream)
+	}
+	return upstream, nil
+}
+
+// isBlockedUserAgent reports whether the User-Agent contains any of the blocked strings.
+func (c *ProxyConfig) isBlockedUserAgent(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, blocked := range c.BlockedUserAgents {
+		if strings.Contains(userAgent, strings.ToLower(blocked)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBaitPath reports whether the request path matches any of the bait path globs.
+func (c *ProxyConfig) isBaitPath(requestPath string) bool {
+	for _, pattern := range c.BaitPaths {
+		if matchPathGlob(pattern, requestPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestMetrics returns the request's metrics, logging the request unless the classifier already did.
+func (s *Server) requestMetrics(r *http.Request, ipAddr string) (*RequestMetrics, error) {
+	if decision, ok := tarpit.FromContext(r.Context()); ok {
+		if metrics, ok := decision.Value.(*RequestMetrics); ok {
+			return metrics, nil
+		}
+	}
+	return s.statsAPI.LogAndGetMetrics(r, ipAddr)
+}
+
+// assessThreat returns the request's threat level and stage, and whether they were pinned by the blocklist. If the
+// classifier already logged and scored the request, its decision is used as is; blocklisted clients are tarpitted
+// before they get that far. Otherwise the request is logged and scored here, with default metrics if it can't be
+// logged.
+func (s *Server) assessThreat(r *http.Request, ipAddr string) (threatLevel, threatStage int, blocklisted bool) {
+	if decision, ok := tarpit.FromContext(r.Context()); ok {
+		if _, scored := decision.Value.(*RequestMetrics); scored {
+			return decision.ThreatLevel, decision.ThreatStage, false
+		}
+	}
+	metrics, err := s.statsAPI.LogAndGetMetrics(r, ipAddr)
+	if err != nil {
+		s.logger.Warn("Failed to log and get metrics, proceeding with default threat assessment", "error", err)
+		metrics = &RequestMetrics{
+			IPAddress: ipAddr,
+			UserAgent: r.UserAgent(),
+			// Everything else default (0)
+		}
+	}
+	return s.blocklistedThreat(metrics, s.requestFeatures(r))
+}
+
+// newReverseProxy creates the proxy that forwards requests to the upstream. The upstream is looked up for every
+// request, so config updates apply straight away.
+func (s *Server) newReverseProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(s.cm.Upstream())
+			pr.SetXForwarded()
+			if s.cm.Get().Server.Proxy.PreserveHost {
+				pr.Out.Host = pr.In.Host
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			s.logger.Error("Failed to proxy request", "path", r.URL.Path, "error", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
+
+// classifyRequest decides whether a request for the real site should be tarpitted, using the rules of the proxy
+// config: whitelisted clients never are, blocklisted clients, blocked user agents and bait paths always are, and
+// anything else is if its threat score reaches the threshold or a threat rule forces a stage. Only requests that get as
+// far as the threat score are logged, in which case the decision's Value holds their metrics, and the tarpit uses its
+// threat level and stage as they are.
+func (s *Server) classifyRequest(r *http.Request, ipAddr string, proxyConfig ProxyConfig) tarpit.Decision {
+	if s.wlc.Matches(ipAddr, r.UserAgent()) {
+		return tarpit.Decision{Reason: reasonWhitelisted}
+	}
+	switch {
+	case s.blc.Matches(ipAddr, r.UserAgent()):
+		return tarpit.Decision{Tarpit: true, Reason: "blocklisted"}
+	case proxyConfig.isBlockedUserAgent(r.UserAgent()):
+		return tarpit.Decision{Tarpit: true, Reason: "blocked user agent"}
+	case proxyConfig.isBaitPath(r.URL.Path):
+		return tarpit.Decision{Tarpit: true, Reason: "bait path"}
+	}
+
+	metrics, err := s.statsAPI.LogAndGetMetrics(r, ipAddr)
+	if err != nil {
+		s.logger.Warn("Failed to log and get metrics, allowing request", "error", err)
+		return tarpit.Decision{Reason: "metrics unavailable"}
+	}
+	threatLevel, threatStage, forcedBy := s.tc.GetThreat(metrics, s.requestFeatures(r))
+	if forcedBy != "" {
+		// A rule that forces a stage is asking for the tarpit, whatever the score.
+		return tarpit.Decision{
+			Tarpit:      true,
+			Reason:      "threat rule",
+			ThreatLevel: threatLevel,
+			ThreatStage: threatStage,
+			Value:       metrics,
+		}
+	}
+	return tarpit.Decision{
+		Tarpit:      threatLevel >= proxyConfig.TarpitThreshold,
+		Reason:      "threat level",
+		ThreatLevel: threatLevel,
+		ThreatStage: threatStage,
+		Value:       metrics,
+	}
+}
+
+// classify is the tarpit listener's classifier. Without reverse-proxy mode, every request is tarpitted. With it,
+// requests are forwarded to the upstream or tarpitted as decided by classifyRequest.
+func (s *Server) classify(r *http.Request) tarpit.Decision {
+	proxyConfig := *s.cm.Get().Server.Proxy
+	if !proxyConfig.Enabled || s.cm.Upstream() == nil {
+		return tarpit.Decision{Tarpit: true, Reason: reasonProxyDisabled}
+	}
+	return s.classifyRequest(r, s.getClientIP(r), proxyConfig)
+}
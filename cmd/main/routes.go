@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RouteRule matches tarpit requests by path, host, and method, and overrides how matching requests are served.
+// Rules are checked in order and the first match wins. Empty match fields match anything.
+type RouteRule struct {
+	// Name is only used for logging.
+	Name string `json:"name"`
+
+	// Path is a glob matched against the request path (e.g. "/wp-login.php" or "/api/*"). A `*` does not match a
+	// slash, so a pattern ending in "/**" is treated as matching everything under that prefix. A trailing slash on the
+	// request path is ignored.
+	Path string `json:"path"`
+
+	// PathRegex is a regular expression matched against the request path. If both Path and PathRegex are set,
+	// both must match.
+	PathRegex string `json:"path_regex"`
+
+	// Host is a glob matched against the request host without its port (e.g. "*.example.com").
+	Host string `json:"host"`
+
+	// Methods is a list of HTTP methods the rule applies to.
+	Methods []string `json:"methods"`
+
+	// Templates replaces EnabledTemplates for matching requests.
+	Templates []string `json:"templates"`
+
+	// StatusCode is the status to respond with. Defaults to 200.
+	StatusCode int `json:"status_code"`
+
+	// Tarpit overrides drip-feed settings and headers for matching requests.
+	Tarpit *TarpitOverrides `json:"tarpit"`
+}
+
+// compiledRoute is a RouteRule with its regex compiled, ready for matching.
+type compiledRoute struct {
+	rule      RouteRule
+	pathRegex *regexp.Regexp
+}
+
+// compileRoutes validates the routing rules and precompiles their regular expressions.
+func compileRoutes(rules []RouteRule) ([]compiledRoute, error) {
+	compiled := make([]compiledRoute, 0, len(rules))
+	for i, rule := range rules {
+		route := compiledRoute{rule: rule}
+		if rule.Path != "" {
+			if _, err := path.Match(rule.Path, "/"); err != nil {
+				return nil, fmt.Errorf("rule %d (%s): invalid path glob %q: %w", i, rule.Name, rule.Path, err)
+			}
+		}
+		if rule.Host != "" {
+			if _, err := path.Match(rule.Host, ""); err != nil {
+				return nil, fmt.Errorf("rule %d (%s): invalid host glob %q: %w", i, rule.Name, rule.Host, err)
+			}
+		}
+		if rule.PathRegex != "" {
+			re, err := regexp.Compile(rule.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d (%s): invalid path regex: %w", i, rule.Name, err)
+			}
+			route.pathRegex = re
+		}
+		if rule.StatusCode != 0 && (rule.StatusCode < 100 || rule.StatusCode > 599) {
+			return nil, fmt.Errorf("rule %d (%s): invalid status code %d", i, rule.Name, rule.StatusCode)
+		}
+		compiled = append(compiled, route)
+	}
+	return compiled, nil
+}
+
+// matches reports whether the request satisfies every condition of the route.
+func (c *compiledRoute) matches(r *http.Request) bool {
+	if len(c.rule.Methods) > 0 {
+		found := false
+		for _, method := range c.rule.Methods {
+			if strings.EqualFold(method, r.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if c.rule.Host != "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if ok, _ := path.Match(strings.ToLower(c.rule.Host), strings.ToLower(host)); !ok {
+			return false
+		}
+	}
+
+	if c.rule.Path != "" && !matchPathGlob(c.rule.Path, r.URL.Path) {
+		return false
+	}
+
+	if c.pathRegex != nil && !c.pathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+
+	return true
+}
+
+// matchPathGlob matches a request path against a glob, with "/**" as a catch-all suffix. A trailing slash on the
+// request path is ignored if the path doesn't match with it, so "/admin" also matches "/admin/".
+func matchPathGlob(pattern, requestPath string) bool {
+	prefix, ok := strings.CutSuffix(pattern, "/**")
+	if !ok {
+		if ok, _ = path.Match(pattern, requestPath); ok || len(requestPath) <= 1 {
+			return ok
+		}
+		trimmed, cut := strings.CutSuffix(requestPath, "/")
+		ok, _ = path.Match(pattern, trimmed)
+		return cut && ok
+	}
+	// Match the prefix against the same number of leading segments of the request path.
+	depth := strings.Count(prefix, "/")
+	segments := strings.Split(requestPath, "/")
+	if len(segments) <= depth {
+		return false
+	}
+	ok, _ = path.Match(prefix, strings.Join(segments[:depth+1], "/"))
+	return ok
+}
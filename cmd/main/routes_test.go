@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchPathGlob(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"exact", "/wp-login.php", "/wp-login.php", true},
+		{"exact mismatch", "/wp-login.php", "/wp-login.phps", false},
+		{"star in segment", "/*.php", "/xmlrpc.php", true},
+		{"star does not cross slash", "/*.php", "/wp/xmlrpc.php", false},
+		{"double star suffix nested", "/api/**", "/api/v1/users/1", true},
+		{"double star suffix direct child", "/api/**", "/api/users", true},
+		{"double star suffix prefix itself", "/api/**", "/api", true},
+		{"double star suffix trailing slash", "/api/**", "/api/", true},
+		{"double star suffix other prefix", "/api/**", "/apis/users", false},
+		{"double star suffix shorter path", "/api/v1/**", "/api", false},
+		{"double star suffix glob prefix", "/*/admin/**", "/site/admin/users/1", true},
+		{"double star mid pattern is one segment", "/a/**/b", "/a/x/b", true},
+		{"double star mid pattern does not cross slash", "/a/**/b", "/a/x/y/b", false},
+		{"trailing slash on request", "/admin", "/admin/", true},
+		{"trailing slash in pattern", "/admin/", "/admin/", true},
+		{"trailing slash in pattern only", "/admin/", "/admin", false},
+		{"trailing slash star pattern", "/static/*", "/static/", true},
+		{"root", "/", "/", true},
+		{"root does not match everything", "/", "/index.html", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := matchPathGlob(tc.pattern, tc.path); got != tc.want {
+				t.Errorf("matchPathGlob(%q, %q): got %v, want %v", tc.pattern, tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompiledRoute_Matches(t *testing.T) {
+	testCases := []struct {
+		name   string
+		rule   RouteRule
+		method string
+		target string
+		want   bool
+	}{
+		{"empty rule matches anything", RouteRule{}, "GET", "http://example.com/x", true},
+		{"host glob", RouteRule{Host: "*.example.com"}, "GET", "http://www.example.com/", true},
+		{"host glob ignores port", RouteRule{Host: "*.example.com"}, "GET", "http://www.example.com:8080/", true},
+		{"host glob is case insensitive", RouteRule{Host: "*.Example.com"}, "GET", "http://WWW.example.COM/", true},
+		{"host glob mismatch", RouteRule{Host: "*.example.com"}, "GET", "http://example.com/", false},
+		{"method", RouteRule{Methods: []string{"POST"}}, "POST", "http://example.com/", true},
+		{"method is case insensitive", RouteRule{Methods: []string{"post"}}, "POST", "http://example.com/", true},
+		{"method mismatch", RouteRule{Methods: []string{"POST", "PUT"}}, "GET", "http://example.com/", false},
+		{"path and regex both match", RouteRule{Path: "/api/**", PathRegex: `/\d+$`}, "GET", "http://example.com/api/users/1", true},
+		{"path matches but regex doesn't", RouteRule{Path: "/api/**", PathRegex: `/\d+$`}, "GET", "http://example.com/api/users", false},
+		{"all conditions", RouteRule{Path: "/login", Host: "example.com", Methods: []string{"POST"}}, "POST", "http://example.com/login/", true},
+		{"all but host", RouteRule{Path: "/login", Host: "example.com", Methods: []string{"POST"}}, "POST", "http://example.org/login", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			routes, err := compileRoutes([]RouteRule{tc.rule})
+			if err != nil {
+				t.Fatalf("compileRoutes: %v", err)
+			}
+			r := httptest.NewRequest(tc.method, tc.target, nil)
+			if got := routes[0].matches(r); got != tc.want {
+				t.Errorf("matches: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileRoutes_Invalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule RouteRule
+	}{
+		{"bad path glob", RouteRule{Path: "/[a"}},
+		{"bad host glob", RouteRule{Host: "[a"}},
+		{"bad regex", RouteRule{PathRegex: "("}},
+		{"bad status", RouteRule{StatusCode: 99}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := compileRoutes([]RouteRule{tc.rule}); err == nil {
+				t.Error("compileRoutes: got nil error, want an error")
+			}
+		})
+	}
+}
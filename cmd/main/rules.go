@@ -0,0 +1,557 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Limits on threat rules, so that a rule's cost to evaluate is bounded by its size. There are no loops or function
+// calls in the language, and regexes are RE2, so evaluation is linear in the size of the rule and its inputs.
+const (
+	maxThreatRules      = 100
+	maxRuleLength       = 1024
+	maxRuleNodes        = 256
+	maxRuleNestingDepth = 32
+)
+
+// ThreatRule adds to the threat score, or forces a threat stage, for requests matching its When expression.
+//
+// An expression compares request and metric fields with literals, e.g. `ua contains "python-requests" && ip_hits > 20`.
+// Strings support ==, !=, contains, startswith, endswith, and matches (a regex, which must be a literal). Numbers
+// support ==, !=, <, <=, > and >=. Conditions combine with &&, || and !, and group with parentheses.
+type ThreatRule struct {
+	// Name is only used for logging. Defaults to "rule N".
+	Name string `json:"name"`
+
+	When string `json:"when"`
+
+	// Score is added to the threat score when the rule matches. It may be negative.
+	Score int `json:"score"`
+
+	// Stage, if set, forces the threat stage with this index when the rule matches, and no later rules are evaluated.
+	Stage *int `json:"stage,omitempty"`
+}
+
+// ruleType is the type of a rule expression or field.
+type ruleType int
+
+const (
+	ruleBool ruleType = iota
+	ruleNumber
+	ruleString
+)
+
+func (t ruleType) String() string {
+	switch t {
+	case ruleBool:
+		return "boolean"
+	case ruleNumber:
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+// ruleFields are the fields rule expressions can use, and their types. TCP tarpit connections have no request, so
+// their request fields are empty, zero or false.
+var ruleFields = map[string]ruleType{
+	"ip":                  ruleString,
+	"ua":                  ruleString,
+	"crawler_family":      ruleString,
+	"subnet":              ruleString,
+	"asn_org":             ruleString,
+	"method":              ruleString,
+	"host":                ruleString,
+	"path":                ruleString,
+	"ip_hits":             ruleNumber,
+	"ua_hits":             ruleNumber,
+	"ip_rate":             ruleNumber, // Hits per minute since first seen.
+	"ua_rate":             ruleNumber,
+	"ip_rate_1m":          ruleNumber,
+	"ip_rate_10m":         ruleNumber,
+	"ip_rate_1h":          ruleNumber,
+	"ua_rate_1m":          ruleNumber,
+	"ua_rate_10m":         ruleNumber,
+	"ua_rate_1h":          ruleNumber,
+	"subnet_hits":         ruleNumber,
+	"subnet_rate_1m":      ruleNumber,
+	"subnet_rate_10m":     ruleNumber,
+	"subnet_rate_1h":      ruleNumber,
+	"asn":                 ruleNumber, // 0 if unknown.
+	"asn_hits":            ruleNumber,
+	"asn_rate_1m":         ruleNumber,
+	"asn_rate_10m":        ruleNumber,
+	"asn_rate_1h":         ruleNumber,
+	"query_length":        ruleNumber,
+	"score":               ruleNumber, // The score so far, before this rule.
+	"http_1_0":            ruleBool,
+	"has_accept_language": ruleBool,
+	"has_accept_encoding": ruleBool,
+	"header_anomaly":      ruleBool,
+	"header_order":        ruleBool,
+	"robots_disallowed":   ruleBool,
+}
+
+// ruleEnv is what a rule is evaluated against.
+type ruleEnv struct {
+	metrics  *RequestMetrics
+	features *RequestFeatures
+	score    float64
+}
+
+func (env *ruleEnv) stringField(name string) string {
+	switch name {
+	case "ip":
+		return env.metrics.IPAddress
+	case "ua":
+		return env.metrics.UserAgent
+	case "crawler_family":
+		return env.metrics.CrawlerFamily
+	case "subnet":
+		return env.metrics.Subnet
+	case "asn_org":
+		return env.metrics.ASNOrganization
+	}
+	if env.features == nil {
+		return ""
+	}
+	switch name {
+	case "method":
+		return env.features.Method
+	case "host":
+		return env.features.Host
+	default:
+		return env.features.Path
+	}
+}
+
+func (env *ruleEnv) numberField(name string) float64 {
+	switch name {
+	case "ip_hits":
+		return float64(env.metrics.IPTotalHits)
+	case "ua_hits":
+		return float64(env.metrics.UATotalHits)
+	case "ip_rate":
+		return hitRate(env.metrics.IPTotalHits, env.metrics.TimeSinceIPFirst)
+	case "ua_rate":
+		return hitRate(env.metrics.UATotalHits, env.metrics.TimeSinceUAFirst)
+	case "ip_rate_1m":
+		return env.metrics.IPRates.OneMinute
+	case "ip_rate_10m":
+		return env.metrics.IPRates.TenMinutes
+	case "ip_rate_1h":
+		return env.metrics.IPRates.OneHour
+	case "ua_rate_1m":
+		return env.metrics.UARates.OneMinute
+	case "ua_rate_10m":
+		return env.metrics.UARates.TenMinutes
+	case "ua_rate_1h":
+		return env.metrics.UARates.OneHour
+	case "subnet_hits":
+		return float64(env.metrics.SubnetTotalHits)
+	case "subnet_rate_1m":
+		return env.metrics.SubnetRates.OneMinute
+	case "subnet_rate_10m":
+		return env.metrics.SubnetRates.TenMinutes
+	case "subnet_rate_1h":
+		return env.metrics.SubnetRates.OneHour
+	case "asn":
+		return float64(env.metrics.ASN)
+	case "asn_hits":
+		return float64(env.metrics.ASNTotalHits)
+	case "asn_rate_1m":
+		return env.metrics.ASNRates.OneMinute
+	case "asn_rate_10m":
+		return env.metrics.ASNRates.TenMinutes
+	case "asn_rate_1h":
+		return env.metrics.ASNRates.OneHour
+	case "score":
+		return env.score
+	}
+	if env.features == nil {
+		return 0
+	}
+	return float64(env.features.QueryLength)
+}
+
+func (env *ruleEnv) boolField(name string) bool {
+	if env.features == nil {
+		return false
+	}
+	switch name {
+	case "http_1_0":
+		return env.features.HTTP10
+	case "has_accept_language":
+		return env.features.HasAcceptLanguage
+	case "has_accept_encoding":
+		return env.features.HasAcceptEncoding
+	case "header_anomaly":
+		return env.features.HeaderAnomaly
+	case "header_order":
+		return env.features.HeaderOrder
+	default:
+		return env.features.RobotsDisallowed
+	}
+}
+
+// ruleExpr is a node of a compiled, type-checked rule expression.
+type ruleExpr struct {
+	op          string // "literal", "field", or an operator.
+	typ         ruleType
+	left, right *ruleExpr
+
+	field   string
+	str     string
+	num     float64
+	boolean bool
+	re      *regexp.Regexp // The compiled right-hand side of "matches".
+}
+
+// evalBool, evalNumber and evalString evaluate an expression of the matching type.
+func (e *ruleExpr) evalBool(env *ruleEnv) bool {
+	switch e.op {
+	case "literal":
+		return e.boolean
+	case "field":
+		return env.boolField(e.field)
+	case "!":
+		return !e.left.evalBool(env)
+	case "&&":
+		return e.left.evalBool(env) && e.right.evalBool(env)
+	case "||":
+		return e.left.evalBool(env) || e.right.evalBool(env)
+	case "contains":
+		return strings.Contains(e.left.evalString(env), e.right.evalString(env))
+	case "startswith":
+		return strings.HasPrefix(e.left.evalString(env), e.right.evalString(env))
+	case "endswith":
+		return strings.HasSuffix(e.left.evalString(env), e.right.evalString(env))
+	case "matches":
+		return e.re.MatchString(e.left.evalString(env))
+	}
+
+	// The comparison operators.
+	switch e.left.typ {
+	case ruleBool:
+		equal := e.left.evalBool(env) == e.right.evalBool(env)
+		return equal == (e.op == "==")
+	case ruleString:
+		equal := e.left.evalString(env) == e.right.evalString(env)
+		return equal == (e.op == "==")
+	}
+	a, b := e.left.evalNumber(env), e.right.evalNumber(env)
+	switch e.op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func (e *ruleExpr) evalNumber(env *ruleEnv) float64 {
+	if e.op == "field" {
+		return env.numberField(e.field)
+	}
+	return e.num
+}
+
+func (e *ruleExpr) evalString(env *ruleEnv) string {
+	if e.op == "field" {
+		return env.stringField(e.field)
+	}
+	return e.str
+}
+
+// ruleToken is a lexical token of a rule expression.
+type ruleToken struct {
+	kind string // "ident", "string", "number", "op", or "end".
+	text string
+	pos  int
+}
+
+// lexRule splits a rule expression into tokens.
+func lexRule(src string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, ruleToken{kind: "ident", text: src[start:i], pos: start})
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			// There is no subtraction, so a "-" before a digit is always a negative number.
+			start := i
+			for i++; i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.'); i++ {
+			}
+			tokens = append(tokens, ruleToken{kind: "number", text: src[start:i], pos: start})
+		case c == '"':
+			start := i
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			value, err := strconv.Unquote(src[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d", start)
+			}
+			tokens = append(tokens, ruleToken{kind: "string", text: value, pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "!", "<", ">", "(", ")"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, ruleToken{kind: "op", text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, ruleToken{kind: "end", pos: len(src)}), nil
+}
+
+// ruleParser is a recursive descent parser for rule expressions, which type-checks as it goes. From loosest to
+// tightest, the precedence is ||, &&, !, then the comparison operators.
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+	nodes  int
+	depth  int
+}
+
+// compileRule parses and type-checks a rule expression, which must be a boolean.
+func compileRule(src string) (*ruleExpr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, fmt.Errorf("expression is empty")
+	}
+	if len(src) > maxRuleLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxRuleLength)
+	}
+	tokens, err := lexRule(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != "end" {
+		return nil, fmt.Errorf("unexpected %q at %d", next.text, next.pos)
+	}
+	if expr.typ != ruleBool {
+		return nil, fmt.Errorf("expression is a %s, not a boolean", expr.typ)
+	}
+	return expr, nil
+}
+
+func (p *ruleParser) peek() ruleToken {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() ruleToken {
+	token := p.tokens[p.pos]
+	if token.kind != "end" {
+		p.pos++
+	}
+	return token
+}
+
+// node counts a new node against the size limit.
+func (p *ruleParser) node(e *ruleExpr) (*ruleExpr, error) {
+	p.nodes++
+	if p.nodes > maxRuleNodes {
+		return nil, fmt.Errorf("expression has more than %d terms", maxRuleNodes)
+	}
+	return e, nil
+}
+
+// logical builds a node for &&, || or !, whose operands must be booleans.
+func (p *ruleParser) logical(op string, left, right *ruleExpr) (*ruleExpr, error) {
+	for _, operand := range []*ruleExpr{left, right} {
+		if operand != nil && operand.typ != ruleBool {
+			return nil, fmt.Errorf("%s needs booleans, got a %s", op, operand.typ)
+		}
+	}
+	return p.node(&ruleExpr{op: op, typ: ruleBool, left: left, right: right})
+}
+
+func (p *ruleParser) parseOr() (*ruleExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek().kind == "op" && p.peek().text == "||" {
+		p.next()
+		var right *ruleExpr
+		if right, err = p.parseAnd(); err == nil {
+			left, err = p.logical("||", left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *ruleParser) parseAnd() (*ruleExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.peek().kind == "op" && p.peek().text == "&&" {
+		p.next()
+		var right *ruleExpr
+		if right, err = p.parseNot(); err == nil {
+			left, err = p.logical("&&", left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *ruleParser) parseNot() (*ruleExpr, error) {
+	if token := p.peek(); token.kind == "op" && token.text == "!" {
+		p.next()
+		if p.depth++; p.depth > maxRuleNestingDepth {
+			return nil, fmt.Errorf("expression is nested more than %d deep", maxRuleNestingDepth)
+		}
+		operand, err := p.parseNot()
+		p.depth--
+		if err != nil {
+			return nil, err
+		}
+		return p.logical("!", operand, nil)
+	}
+	return p.parseComparison()
+}
+
+func (p *ruleParser) parseComparison() (*ruleExpr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	token := p.peek()
+	op := token.text
+	switch {
+	case token.kind == "op" && (op == "==" || op == "!=" || op == "<" || op == "<=" || op == ">" || op == ">="):
+	case token.kind == "ident" && (op == "contains" || op == "startswith" || op == "endswith" || op == "matches"):
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &ruleExpr{op: op, typ: ruleBool, left: left, right: right}
+	switch op {
+	case "==", "!=":
+		if left.typ != right.typ {
+			return nil, fmt.Errorf("%s compares a %s with a %s at %d", op, left.typ, right.typ, token.pos)
+		}
+	case "<", "<=", ">", ">=":
+		if left.typ != ruleNumber || right.typ != ruleNumber {
+			return nil, fmt.Errorf("%s needs numbers at %d", op, token.pos)
+		}
+	default:
+		if left.typ != ruleString || right.typ != ruleString {
+			return nil, fmt.Errorf("%s needs strings at %d", op, token.pos)
+		}
+		if op == "matches" {
+			if right.op != "literal" {
+				return nil, fmt.Errorf("matches needs a literal regex at %d", token.pos)
+			}
+			if len(right.str) > maxListPatternLength {
+				return nil, fmt.Errorf("regex at %d is longer than %d characters", token.pos, maxListPatternLength)
+			}
+			if e.re, err = regexp.Compile(right.str); err != nil {
+				return nil, fmt.Errorf("invalid regex at %d: %w", token.pos, err)
+			}
+		}
+	}
+	return p.node(e)
+}
+
+func (p *ruleParser) parsePrimary() (*ruleExpr, error) {
+	token := p.next()
+	switch token.kind {
+	case "string":
+		return p.node(&ruleExpr{op: "literal", typ: ruleString, str: token.text})
+	case "number":
+		n, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", token.text, token.pos)
+		}
+		return p.node(&ruleExpr{op: "literal", typ: ruleNumber, num: n})
+	case "ident":
+		if token.text == "true" || token.text == "false" {
+			return p.node(&ruleExpr{op: "literal", typ: ruleBool, boolean: token.text == "true"})
+		}
+		typ, ok := ruleFields[token.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q at %d", token.text, token.pos)
+		}
+		return p.node(&ruleExpr{op: "field", typ: typ, field: token.text})
+	case "op":
+		if token.text == "(" {
+			if p.depth++; p.depth > maxRuleNestingDepth {
+				return nil, fmt.Errorf("expression is nested more than %d deep", maxRuleNestingDepth)
+			}
+			e, err := p.parseOr()
+			p.depth--
+			if err != nil {
+				return nil, err
+			}
+			if closing := p.next(); closing.kind != "op" || closing.text != ")" {
+				return nil, fmt.Errorf("expected ) at %d", closing.pos)
+			}
+			return e, nil
+		}
+	case "end":
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", token.text, token.pos)
+}
+
+// compiledThreatRule is a ThreatRule with its expression compiled, ready for evaluation.
+type compiledThreatRule struct {
+	rule ThreatRule
+	expr *ruleExpr
+}
+
+// compileThreatRules validates the threat rules against the number of threat stages, and compiles their expressions.
+func compileThreatRules(rules []ThreatRule, stages int) ([]compiledThreatRule, error) {
+	if len(rules) > maxThreatRules {
+		return nil, fmt.Errorf("more than %d rules", maxThreatRules)
+	}
+	compiled := make([]compiledThreatRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i)
+		}
+		if rule.Stage != nil && (*rule.Stage < 0 || *rule.Stage >= stages) {
+			return nil, fmt.Errorf("rule %d (%s): stage must be between 0 and %d, got %d", i, rule.Name, stages-1, *rule.Stage)
+		}
+		expr, err := compileRule(rule.When)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, rule.Name, err)
+		}
+		compiled = append(compiled, compiledThreatRule{rule: rule, expr: expr})
+	}
+	return compiled, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileRule_Eval(t *testing.T) {
+	env := &ruleEnv{
+		metrics: &RequestMetrics{
+			IPAddress:        "203.0.113.7",
+			UserAgent:        "python-requests/2.31",
+			IPTotalHits:      20,
+			TimeSinceIPFirst: 8 * time.Minute,
+			IPRates:          WindowRates{OneMinute: 6, TenMinutes: 1.5},
+			Subnet:           "203.0.113.0/24",
+			SubnetTotalHits:  120,
+			SubnetRates:      WindowRates{OneHour: 2},
+			ASN:              64496,
+			ASNOrganization:  "Example Hosting",
+		},
+		features: &RequestFeatures{Method: "GET", Host: "example.com", Path: "/wp-login.php", HasAcceptEncoding: true, HeaderOrder: true},
+		score:    -3,
+	}
+
+	testCases := []struct {
+		when string
+		want bool
+	}{
+		// Precedence, from loosest to tightest: ||, &&, !, then comparisons.
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`!false && false`, false},
+		{`!(false && false)`, true},
+		{`!!true`, true},
+		{`!ip_hits > 30`, true},
+		{`ip_hits > 30 || ua contains "python" && !http_1_0`, true},
+
+		// Numbers.
+		{`ip_hits == 20`, true},
+		{`ip_hits != 20`, false},
+		{`ip_hits < 20`, false},
+		{`ip_hits <= 20`, true},
+		{`ip_hits > 19.5`, true},
+		{`ip_hits >= 21`, false},
+		{`ip_rate == 2.5`, true},
+		{`ua_rate == 0`, true},
+		{`ip_rate_1m > ip_rate_10m`, true},
+		{`ip_rate_1m == 6 && ip_rate_10m == 1.5 && ip_rate_1h == 0`, true},
+		{`ua_rate_1m == 0`, true},
+		{`subnet_hits > 100 && subnet_rate_1h == 2`, true},
+		{`asn == 64496 && asn_hits == 0 && asn_rate_1m == 0`, true},
+		{`query_length == 0`, true},
+
+		// Negative literals.
+		{`score > -5`, true},
+		{`score < -5`, false},
+		{`score == -3`, true},
+		{`-3 == score`, true},
+		{`score >= -3.5`, true},
+
+		// Strings.
+		{`ip == "203.0.113.7"`, true},
+		{`method != "GET"`, false},
+		{`ua contains "requests"`, true},
+		{`ua contains "curl"`, false},
+		{`ip startswith "203.0.113."`, true},
+		{`subnet == "203.0.113.0/24"`, true},
+		{`asn_org contains "Hosting"`, true},
+		{`host == "example.com"`, true},
+		{`path endswith ".php"`, true},
+		{`path endswith ".asp"`, false},
+		{`ua matches "^python-requests/[0-9.]+$"`, true},
+		{`ua matches "Python"`, false},
+		{`ua matches "(?i)Python"`, true},
+		{`ua == "PYTHON-REQUESTS/2.31"`, false},
+
+		// Booleans.
+		{`has_accept_encoding`, true},
+		{`has_accept_language == false`, true},
+		{`has_accept_encoding != true`, false},
+		{`header_order && !header_anomaly`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.when, func(t *testing.T) {
+			expr, err := compileRule(tc.when)
+			if err != nil {
+				t.Fatalf("compileRule: %v", err)
+			}
+			if got := expr.evalBool(env); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileRule_TCPConnection(t *testing.T) {
+	env := &ruleEnv{metrics: &RequestMetrics{UserAgent: "tcp/ssh"}}
+	expr, err := compileRule(`path == "" && query_length == 0 && !header_anomaly && ua == "tcp/ssh"`)
+	if err != nil {
+		t.Fatalf("compileRule: %v", err)
+	}
+	if !expr.evalBool(env) {
+		t.Error("request fields of a connection aren't empty")
+	}
+}
+
+func TestCompileRule_Errors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		when    string
+		wantErr string
+	}{
+		{"empty", "  ", "expression is empty"},
+		{"too long", strings.Repeat(" ", maxRuleLength) + "true", "longer than"},
+		{"too many nodes", strings.Repeat("true||", maxRuleNodes/2) + "true", "more than 256 terms"},
+		{"nested parentheses", strings.Repeat("(", maxRuleNestingDepth+1) + "true" + strings.Repeat(")", maxRuleNestingDepth+1), "nested more than"},
+		{"nested negation", strings.Repeat("!", maxRuleNestingDepth+1) + "true", "nested more than"},
+		{"invalid regex", `ua matches "(unclosed"`, "invalid regex"},
+		{"regex too long", `ua matches "` + strings.Repeat("a", maxListPatternLength+1) + `"`, "longer than"},
+		{"regex not a literal", `ua matches ip`, "needs a literal regex"},
+		{"unknown field", `uas contains "x"`, "unknown field"},
+		{"not a boolean", `ip_hits`, "not a boolean"},
+		{"mixed types", `ip_hits == "20"`, "compares a number with a string"},
+		{"string ordering", `ua < "b"`, "needs numbers"},
+		{"number contains", `ip_hits contains 2`, "needs strings"},
+		{"logical on number", `ip_hits && true`, "needs booleans"},
+		{"subtraction", `score - 5 > 0`, "unexpected"},
+		{"lone minus", `score > -`, "unexpected"},
+		{"invalid number", `ip_hits > 1.2.3`, "invalid number"},
+		{"unterminated string", `ua == "abc`, "unterminated string"},
+		{"unclosed parenthesis", `(true`, "expected )"},
+		{"trailing tokens", `true false`, "unexpected"},
+		{"missing operand", `ip_hits >`, "unexpected end"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := compileRule(tc.when)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	// The limits are inclusive.
+	for _, when := range []string{
+		strings.Repeat("(", maxRuleNestingDepth) + "true" + strings.Repeat(")", maxRuleNestingDepth),
+		strings.Repeat("!", maxRuleNestingDepth) + "true",
+		strings.Repeat("true||", (maxRuleNodes-1)/2) + "true",
+	} {
+		if _, err := compileRule(when); err != nil {
+			t.Errorf("%.40s...: %v", when, err)
+		}
+	}
+}
+
+func TestCompileThreatRules(t *testing.T) {
+	stage := func(n int) *int { return &n }
+	testCases := []struct {
+		name    string
+		rules   []ThreatRule
+		stages  int
+		wantErr string
+	}{
+		{"valid", []ThreatRule{{When: "true", Score: -10}, {When: "ip_hits > 5", Stage: stage(4)}}, 5, ""},
+		{"stage below range", []ThreatRule{{Name: "low", When: "true", Stage: stage(-1)}}, 5, "rule 0 (low): stage must be between 0 and 4, got -1"},
+		{"stage above range", []ThreatRule{{When: "true"}, {When: "true", Stage: stage(5)}}, 5, "rule 1 (rule 1): stage must be between 0 and 4, got 5"},
+		{"stage beyond fewer stages", []ThreatRule{{When: "true", Stage: stage(3)}}, 3, "rule 0 (rule 0): stage must be between 0 and 2, got 3"},
+		{"stage within more stages", []ThreatRule{{When: "true", Stage: stage(7)}}, 8, ""},
+		{"invalid expression", []ThreatRule{{Name: "bad", When: "ua matches \"[\""}}, 5, "rule 0 (bad): invalid regex"},
+		{"too many rules", make([]ThreatRule, maxThreatRules+1), 5, "more than 100 rules"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiled, err := compileThreatRules(tc.rules, tc.stages)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				if len(compiled) != len(tc.rules) || compiled[0].rule.Name != "rule 0" {
+					t.Errorf("got %d rules named %q, want %d named \"rule 0\"", len(compiled), compiled[0].rule.Name, len(tc.rules))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestThreatCalculator_GetThreat_Rules(t *testing.T) {
+	stage := func(n int) *int { return &n }
+	config := DefaultThreatConfig()
+	config.BaseThreat = 10
+	config.IPHitFactor, config.UAHitFactor, config.IPHitRateFactor, config.UAHitRateFactor = 0, 0, 0, 0
+	config.Stages[1].Enabled = true
+	metrics := &RequestMetrics{IPAddress: "203.0.113.7", UserAgent: "ExampleBot/1.0", IPTotalHits: 1, UATotalHits: 1}
+
+	testCases := []struct {
+		name         string
+		rules        []ThreatRule
+		wantLevel    int
+		wantStage    int
+		wantForcedBy string
+	}{
+		{"no rules", nil, 10, 0, ""},
+		{"no match", []ThreatRule{{When: `ua contains "curl"`, Score: 20}}, 10, 0, ""},
+		{"score", []ThreatRule{{When: `ua contains "Bot"`, Score: 20}}, 30, 1, ""},
+		{"later rules see the score", []ThreatRule{{When: "true", Score: 20}, {When: "score >= 30", Score: 5}}, 35, 1, ""},
+		{"clamped at 0", []ThreatRule{{When: "true", Score: -50}}, 0, 0, ""},
+		{
+			"forced stage stops the rules",
+			[]ThreatRule{{Name: "pin", When: `ip == "203.0.113.7"`, Score: 5, Stage: stage(4)}, {When: "true", Score: 100}},
+			15, 4, "pin",
+		},
+		{"forced stage below the score", []ThreatRule{{When: "true", Score: 40}, {Name: "calm", When: "true", Stage: stage(0)}}, 50, 0, "calm"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := compileThreatRules(tc.rules, len(config.Stages))
+			if err != nil {
+				t.Fatalf("compileThreatRules: %v", err)
+			}
+			c := NewThreatCalculator(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			c.SetConfig(config, rules)
+
+			level, stage, forcedBy := c.GetThreat(metrics, nil)
+			if level != tc.wantLevel || stage != tc.wantStage || forcedBy != tc.wantForcedBy {
+				t.Errorf("got level %d, stage %d, forced by %q, want %d, %d, %q",
+					level, stage, forcedBy, tc.wantLevel, tc.wantStage, tc.wantForcedBy)
+			}
+		})
+	}
+}
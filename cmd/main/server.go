@@ -2,24 +2,32 @@ package main
 
 import (
 	"bytes"
+	"compress/flate"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"html/template"
 	"log/slog"
-	"math/rand"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"net/http/httputil"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/amenyxia/Sarracenia/pkg/markov"
+	"github.com/amenyxia/Sarracenia/pkg/tarpit"
 	"github.com/amenyxia/Sarracenia/pkg/templating"
 )
 
 type TemplateInput struct {
-	ThreatLevel int
-	ThreatStage int
+	ThreatLevel     int
+	ThreatStage     int    // Index of the stage, from 0.
+	ThreatStageName string // Name of the stage.
 }
 
 type Server struct {
@@ -31,16 +39,26 @@ type Server struct {
 	mg                *markov.Generator
 	tm                *templating.TemplateManager
 	tc                *ThreatCalculator
-	wlc               *WhitelistCache
+	wlc               *ClientListCache
+	blc               *ClientListCache
 	authAPI           *AuthAPI
 	templateAPI       *TemplateAPI
 	markovAPI         *MarkovAPI
 	statsAPI          *StatsAPI
 	serverAPI         *ServerAPI
-	whitelistAPI      *WhitelistAPI
+	whitelistAPI      *ClientListAPI
+	blocklistAPI      *ClientListAPI
+	crawlerAPI        *CrawlerAPI
+	connections       *ConnectionTracker
+	labyrinth         *labyrinth
+	reverseProxy      *httputil.ReverseProxy
+	tarpitHandler     http.Handler
 	tarpitMux         *http.ServeMux
 	apiMux            *http.ServeMux
 	dashboardTemplate *template.Template
+
+	// pageSecret seeds deterministic pages if no page_seed_secret is configured.
+	pageSecret []byte
 }
 
 func NewServer(cm *ConfigManager, logger *slog.Logger, markovDB *sql.DB, authDB *sql.DB, statsDB *sql.DB, actionChan chan string) (*Server, error) {
@@ -54,6 +72,7 @@ func NewServer(cm *ConfigManager, logger *slog.Logger, markovDB *sql.DB, authDB
 	}
 
 	tc := NewThreatCalculator(config.Threat, logger)
+	cm.SetThreatCalculator(tc)
 
 	tm, err := templating.NewTemplateManager(logger, mg, config.Templates, "./data")
 	if err != nil {
@@ -68,20 +87,38 @@ func NewServer(cm *ConfigManager, logger *slog.Logger, markovDB *sql.DB, authDB
 	if err != nil {
 		return nil, fmt.Errorf("failed to load whitelist from db: %w", err)
 	}
+	wlc.SetGoodBotVerifier(NewGoodBotVerifier(cm, nil, logger))
+	blc := NewBlocklistCache()
+	if err = blc.LoadFromDB(authDB); err != nil {
+		return nil, fmt.Errorf("failed to load blocklist from db: %w", err)
+	}
 
 	// api initialization
 	authAPI := NewAuthAPI(authDB, logger)
 	templateAPI := NewTemplateAPI(tm, tc, logger)
 	markovAPI := NewMarkovAPI(mg, tm, logger)
-	statsAPI := NewStatsAPI(statsDB, logger)
+	connections := NewConnectionTracker()
+	crawlers, err := NewCrawlerCatalog(config.Server.CrawlerCatalogPath, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load crawler catalogue: %w", err)
+	}
+	crawlerAPI := NewCrawlerAPI(crawlers, logger)
+	statsAPI := NewStatsAPI(statsDB, logger, connections, crawlers)
 	serverAPI := NewServerAPI(cm, actionChan, tm, logger)
 	whitelistAPI := NewWhitelistAPI(authDB, logger, wlc)
+	blocklistAPI := NewBlocklistAPI(authDB, logger, blc)
 
 	// initialize the stats cache with configuration
 	if err = statsAPI.InitializeCache(config.Server.StatsConfig); err != nil {
 		return nil, fmt.Errorf("failed to initialize stats cache: %w", err)
 	}
 
+	pageSecret := make([]byte, 32)
+	_, _ = crand.Read(pageSecret)
+	if config.Server.TarpitConfig.DeterministicPages && config.Server.TarpitConfig.PageSeedSecret == "" {
+		logger.Warn("Deterministic pages are enabled without a page_seed_secret, pages will change on every restart")
+	}
+
 	// create object, register routes to the mux, and return it
 	server := &Server{
 		cm:           cm,
@@ -93,14 +130,20 @@ func NewServer(cm *ConfigManager, logger *slog.Logger, markovDB *sql.DB, authDB
 		tc:           tc,
 		mg:           mg,
 		wlc:          wlc,
+		blc:          blc,
 		authAPI:      authAPI,
 		templateAPI:  templateAPI,
 		markovAPI:    markovAPI,
 		statsAPI:     statsAPI,
 		serverAPI:    serverAPI,
 		whitelistAPI: whitelistAPI,
+		blocklistAPI: blocklistAPI,
+		crawlerAPI:   crawlerAPI,
+		connections:  connections,
+		labyrinth:    newLabyrinth(),
 		tarpitMux:    http.NewServeMux(),
 		apiMux:       http.NewServeMux(),
+		pageSecret:   pageSecret,
 	}
 
 	apiMux := http.NewServeMux()
@@ -111,11 +154,15 @@ func NewServer(cm *ConfigManager, logger *slog.Logger, markovDB *sql.DB, authDB
 	server.statsAPI.RegisterRoutes(apiMux)
 	server.serverAPI.RegisterRoutes(apiMux)
 	server.whitelistAPI.RegisterRoutes(apiMux)
+	server.blocklistAPI.RegisterRoutes(apiMux)
+	server.crawlerAPI.RegisterRoutes(apiMux)
 
 	// Make sure api functions must pass through authentication first
 	authedAPI := server.authAPI.Authenticate(apiMux)
 	// ... except for the health check, which is unauthed so something like docker can use it
 	server.apiMux.HandleFunc("/api/health", server.serverAPI.handleHealthCheck)
+	// ... and forward-auth, which is only answered for trusted proxies
+	server.apiMux.HandleFunc("/api/forward_auth", server.handleForwardAuth)
 
 	server.apiMux.Handle("/api/", authedAPI)
 
@@ -129,7 +176,15 @@ func NewServer(cm *ConfigManager, logger *slog.Logger, markovDB *sql.DB, authDB
 
 	server.apiMux.HandleFunc("/", server.handleDashboard)
 	server.tarpitMux.HandleFunc("/favicon.ico", handleFavicon)
+	server.registerCrawlerFiles(server.tarpitMux)
 	server.tarpitMux.HandleFunc("/", server.handleTarpit)
+	server.reverseProxy = server.newReverseProxy()
+
+	// The tarpit listener forwards requests to the upstream in reverse-proxy mode, and tarpits everything otherwise.
+	middleware := tarpit.New(tarpit.ClassifierFunc(server.classify), server.tarpitMux,
+		tarpit.WithRecorder(statsAPI),
+		tarpit.WithLogger(logger))
+	server.tarpitHandler = withHeaderOrder(middleware.Wrap(server.reverseProxy))
 
 	return server, nil
 }
@@ -149,72 +204,189 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleTarpit(w http.ResponseWriter, r *http.Request) {
 	ipAddr := s.getClientIP(r)
-	if s.wlc.IsWhitelisted(ipAddr, r.UserAgent()) {
+	if s.wlc.Matches(ipAddr, r.UserAgent()) {
 		s.logger.Debug("Request from whitelisted client, serving 404.", "remote_addr", r.RemoteAddr, "user_agent", r.UserAgent())
 		http.NotFound(w, r)
 		return
 	}
-	metrics, err := s.statsAPI.LogAndGetMetrics(r, ipAddr)
-	if err != nil {
-		s.logger.Warn("Failed to log and get metrics, proceeding with default threat assessment", "error", err)
-		metrics = &RequestMetrics{
-			IPAddress: ipAddr,
-			UserAgent: r.UserAgent(),
-			// Everything else default (0)
-		}
-	}
-	threatLevel := s.tc.GetThreatLevel(metrics)
-	threatState := s.tc.GetStage(threatLevel)
+	threatLevel, threatState, blocklisted := s.assessThreat(r, ipAddr)
 
 	config := s.cm.Get()
 	enabledTemplates := config.Server.EnabledTemplates
 	tarpitConfig := *config.Server.TarpitConfig
+	statusCode := http.StatusOK
+
+	// The threat stage's profile overrides the global drip-feed settings and headers.
+	stage := config.Threat.Stages.Get(threatState)
+	tarpitConfig = stage.Tarpit.Apply(tarpitConfig)
+
+	// A matching routing rule can replace the template list, status code, and drip-feed settings.
+	routeName := ""
+	var routeTemplates []string
+	if route := s.cm.MatchRoute(r); route != nil {
+		routeName = route.Name
+		routeTemplates = route.Templates
+		if route.StatusCode != 0 {
+			statusCode = route.StatusCode
+		}
+		tarpitConfig = route.Tarpit.Apply(tarpitConfig)
+	}
 
-	var templateName string
-	if len(enabledTemplates) > 0 {
-		templateName = enabledTemplates[rand.Intn(len(enabledTemplates))]
-	} else {
-		templateName = s.tm.GetRandomTemplate()
+	// With deterministic pages, everything random about the page (including the template) comes from pageRand.
+	pageRand := s.pageRand(r, tarpitConfig)
+	intN := rand.IntN
+	if pageRand != nil {
+		intN = pageRand.IntN
+	}
+
+	// Requests without a routing rule may get a redirect, refresh page, or error instead of a regular page.
+	behavior := behaviorPage
+	if routeName == "" {
+		behaviors := *config.Server.Behaviors
+		var remaining int
+		behavior, remaining = s.chooseBehavior(r, behaviors, stage, intN)
+		switch behavior {
+		case behaviorRedirect, behaviorRefresh, behaviorUnavailable:
+			code := s.serveBehavior(w, r, behavior, remaining, behaviors, pageRand, intN)
+			s.statsAPI.RecordStatus(code)
+			s.logger.Info("Serving tarpit behavior",
+				"behavior", behavior,
+				"status", code,
+				"remaining_hops", remaining,
+				"remote_addr", ipAddr,
+				"Threat_level", threatLevel,
+				"Threat_state", threatState)
+			return
+		case behaviorNotFound:
+			statusCode = http.StatusNotFound
+		case behaviorServerError:
+			statusCode = http.StatusInternalServerError
+		}
+	}
+
+	// Requests that hold the connection open are capped, in total and per IP. Over the limits, the request is either
+	// rejected or served straight away, without delays, endless page, or compressed payload.
+	endless, payload := stage.EndlessPage, stage.CompressedPayload
+	if tarpitConfig.EnableDripFeed || endless || payload {
+		limits := *config.Server.ConnectionLimits
+		if s.connections.Acquire(ipAddr, limits) {
+			defer s.connections.Release(ipAddr)
+		} else {
+			rejected := limits.OverLimitAction == overLimitReject
+			s.statsAPI.RecordOverLimit(rejected)
+			s.logger.Debug("Connection limit reached", "remote_addr", ipAddr, "action", limits.OverLimitAction)
+			if rejected {
+				s.statsAPI.RecordStatus(http.StatusServiceUnavailable)
+				w.Header().Set("Retry-After", strconv.Itoa(limits.RetryAfterSec))
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			tarpitConfig.EnableDripFeed = false
+			endless, payload = false, false
+		}
+	}
+
+	// The kind of content asked for (JSON, RSS, ...) decides which templates are picked from. Whatever template is
+	// picked, non-HTML content is sent with the Content-Type of its kind.
+	templateName := s.pickTemplate(negotiateKind(r), routeTemplates, stage.Templates, enabledTemplates, pageRand, intN)
+	kind := templating.KindOf(templateName)
+	if kind != templating.KindHTML {
+		tarpitConfig.Headers = withContentType(tarpitConfig.Headers, kind.ContentType())
 	}
 	s.logger.Info(
 		"Serving tarpit page",
 		"template", templateName,
+		"kind", kind,
+		"route", routeName,
+		"behavior", behavior,
+		"blocklisted", blocklisted,
 		"remote_addr", ipAddr,
 		"Threat_level", threatLevel,
 		"Threat_state", threatState)
 
+	// Compress the response if the client accepts it. With a compressed payload to follow, it is worth spending
+	// the time on the best compression level.
+	encoding := ""
+	if tarpitConfig.Compression {
+		encoding = negotiateEncoding(r)
+	}
+	level := flate.DefaultCompression
+	if payload {
+		level = flate.BestCompression
+	}
+	cw := newCompressWriter(w, encoding, level)
+	defer func() {
+		if err := cw.Close(); err != nil {
+			s.logger.Debug("Failed to finish compressed response", "error", err, "remote_addr", r.RemoteAddr)
+		}
+		s.statsAPI.RecordResponseBytes(cw.BytesWritten(), cw.BytesSent())
+		if code := cw.StatusCode(); code != 0 {
+			s.statsAPI.RecordStatus(code)
+		}
+	}()
+	w = cw
+
+	input := TemplateInput{ThreatLevel: threatLevel, ThreatStage: threatState, ThreatStageName: stage.Name}
+
+	var sent bool
+	if tarpitConfig.StreamResponse {
+		s.setTarpitHeaders(w, tarpitConfig.Headers)
+		sent = s.streamTarpit(w, r, templateName, input, tarpitConfig, statusCode, pageRand)
+	} else {
+		sent = s.bufferTarpit(w, r, templateName, input, tarpitConfig, statusCode, pageRand)
+	}
+
+	// The compressed payload is only sent compressed, as it would cost as much to send as to receive otherwise.
+	if sent && payload && cw.Compressed() {
+		s.serveCompressedPayload(cw, r, tarpitConfig)
+	}
+
+	// Endless pages append HTML, so they only apply to HTML content.
+	if sent && endless && kind == templating.KindHTML {
+		s.serveEndless(w, r, tarpitConfig, pageRand)
+	}
+}
+
+// bufferTarpit renders the whole template into memory first, then sends it, drip-fed in a random number of chunks
+// if enabled. It returns true if the full page was sent to the client.
+func (s *Server) bufferTarpit(w http.ResponseWriter, r *http.Request, templateName string, input TemplateInput, tarpitConfig TarpitConfig, statusCode int, pageRand *rand.Rand) bool {
 	var buf bytes.Buffer
-	err = s.tm.Execute(&buf, templateName, TemplateInput{ThreatLevel: threatLevel, ThreatStage: threatState})
+	err := s.tm.Execute(&buf, templateName, input, templating.WithRand(pageRand))
 	if err != nil {
 		s.logger.Error("Failed to execute template", "template", templateName, "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		return false
 	}
 	s.setTarpitHeaders(w, tarpitConfig.Headers)
 
 	// If drip feeding is disabled in the config, or any of the config is invalid, send the response normally.
 	if !tarpitConfig.EnableDripFeed || tarpitConfig.DripFeedChunksMax <= 0 || tarpitConfig.DripFeedDelayMax < 0 || tarpitConfig.DripFeedChunksMax < 0 {
-		_, _ = buf.WriteTo(w)
-		return
+		w.WriteHeader(statusCode)
+		_, err = buf.WriteTo(w)
+		return err == nil
 	}
 
 	// Enforce an initial delay before any data is sent.
 	if tarpitConfig.InitialDelayMax > 0 {
-		time.Sleep(time.Duration(randRangeMinZero(tarpitConfig.InitialDelayMin, tarpitConfig.InitialDelayMax)) * time.Millisecond)
+		delay := randRangeMinZero(tarpitConfig.InitialDelayMin, tarpitConfig.InitialDelayMax)
+		if tarpit.SleepContext(r.Context(), time.Duration(delay)*time.Millisecond) != nil {
+			return false // Client went away during the initial delay.
+		}
 	}
 
 	// Assert that the ResponseWriter supports flushing.
 	flusher, ok := w.(http.Flusher)
+	w.WriteHeader(statusCode)
 	if !ok {
 		s.logger.Warn("ResponseWriter does not support flushing, sending response at once.")
-		_, _ = buf.WriteTo(w)
-		return
+		_, err = buf.WriteTo(w)
+		return err == nil
 	}
 
 	responseBytes := buf.Bytes()
 	totalSize := len(responseBytes)
 	chunks := randRangeMinZero(tarpitConfig.DripFeedChunksMin, tarpitConfig.DripFeedChunksMax)
-	chunkSize := totalSize / chunks
+	chunkSize := totalSize / max(chunks, 1)
 
 	// Ensure chunk size is at least 1 to avoid an infinite loop on small responses.
 	if chunkSize <= 0 {
@@ -231,7 +403,7 @@ func (s *Server) handleTarpit(w http.ResponseWriter, r *http.Request) {
 		// Write the chunk to the client.
 		if _, err = w.Write(responseBytes[i:end]); err != nil {
 			s.logger.Error("Failed to write tarpit chunk to client", "error", err, "remote_addr", r.RemoteAddr)
-			return // Stop if the client closes the connection.
+			return false // Stop if the client closes the connection.
 		}
 
 		// Flush the writer to ensure the chunk is sent over the network immediately.
@@ -239,9 +411,85 @@ func (s *Server) handleTarpit(w http.ResponseWriter, r *http.Request) {
 
 		// Wait before sending the next chunk, but not after the last one.
 		if end < totalSize {
-			time.Sleep(time.Duration(randRangeMinZero(tarpitConfig.DripFeedDelayMin, tarpitConfig.DripFeedDelayMax)) * time.Millisecond)
+			delay := randRangeMinZero(tarpitConfig.DripFeedDelayMin, tarpitConfig.DripFeedDelayMax)
+			if tarpit.SleepContext(r.Context(), time.Duration(delay)*time.Millisecond) != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// streamTarpit renders the template directly into the response instead of buffering the whole page first.
+// If drip-feeding is enabled, the output goes through a DripWriter so the first chunk is sent as soon as
+// it has been rendered, and memory use stays at roughly one chunk per connection.
+// It returns true if the full page was sent to the client.
+func (s *Server) streamTarpit(w http.ResponseWriter, r *http.Request, templateName string, input TemplateInput, tarpitConfig TarpitConfig, statusCode int, pageRand *rand.Rand) bool {
+	dripEnabled := tarpitConfig.EnableDripFeed && tarpitConfig.DripFeedChunkBytesMax > 0 && tarpitConfig.DripFeedDelayMax >= 0
+	flusher, ok := w.(http.Flusher)
+	if dripEnabled && !ok {
+		s.logger.Warn("ResponseWriter does not support flushing, streaming response without drip-feed.")
+		dripEnabled = false
+	}
+
+	if !dripEnabled {
+		// Headers are sent before rendering, so there is no way to report a render failure to the client.
+		w.WriteHeader(statusCode)
+		if err := s.tm.Execute(w, templateName, input, templating.WithRand(pageRand)); err != nil {
+			s.logger.Error("Failed to stream template", "template", templateName, "error", err, "remote_addr", r.RemoteAddr)
+			return false
 		}
+		return true
 	}
+
+	// Enforce an initial delay before any data is sent.
+	if tarpitConfig.InitialDelayMax > 0 {
+		delay := randRangeMinZero(tarpitConfig.InitialDelayMin, tarpitConfig.InitialDelayMax)
+		if err := tarpit.SleepContext(r.Context(), time.Duration(delay)*time.Millisecond); err != nil {
+			return false // Client went away during the initial delay.
+		}
+	}
+
+	dw := tarpit.NewDripWriter(r.Context(), w, flusher, tarpitConfig.Drip())
+	dw.SetStatusCode(statusCode)
+	err := s.tm.Execute(dw, templateName, input, templating.WithRand(pageRand))
+	if err == nil {
+		err = dw.Close()
+	}
+	if err != nil {
+		if r.Context().Err() != nil {
+			s.logger.Debug("Client disconnected during tarpit stream", "remote_addr", r.RemoteAddr)
+			return false
+		}
+		s.logger.Error("Failed to stream template", "template", templateName, "error", err, "remote_addr", r.RemoteAddr)
+		// Nothing has been sent yet, so the client can still get a proper error response.
+		if !dw.Started() {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// pageRand returns the random source for rendering a tarpit page. If deterministic pages are enabled, it is seeded
+// with an HMAC of the request host and path, so every visit to a URL renders the same page, while nobody without
+// the secret can predict it. Otherwise it returns nil, and each render gets its own random source.
+func (s *Server) pageRand(r *http.Request, tarpitConfig TarpitConfig) *rand.Rand {
+	if !tarpitConfig.DeterministicPages {
+		return nil
+	}
+	secret := []byte(tarpitConfig.PageSeedSecret)
+	if len(secret) == 0 {
+		secret = s.pageSecret
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.ToLower(r.Host)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(r.URL.Path))
+
+	var seed [32]byte
+	copy(seed[:], mac.Sum(nil))
+	return rand.New(rand.NewChaCha8(seed))
 }
 
 // I don't want to write all this out twice, I'm sorry.
@@ -249,7 +497,10 @@ func randRangeMinZero(min, max int) int {
 	if min < 0 {
 		min = 0
 	}
-	return rand.Intn(max-min) + min
+	if max <= min {
+		return min
+	}
+	return rand.IntN(max-min) + min
 }
 
 func (s *Server) setTarpitHeaders(w http.ResponseWriter, headers map[string]string) {
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// SignalConfig holds the weights of the request fingerprint signals, each added to the threat score once when the
+// request shows it. A weight of 0 turns a signal off.
+type SignalConfig struct {
+	// Headers every browser sends, and many scripts and scraping libraries don't.
+	MissingAcceptLanguage int `json:"missing_accept_language"`
+	MissingAcceptEncoding int `json:"missing_accept_encoding"`
+
+	// HeaderAnomaly is for a browser UA with headers that browser wouldn't send, or without ones it would.
+	HeaderAnomaly int `json:"header_anomaly"`
+
+	// HeaderOrder is for a browser UA with headers in an order that browser wouldn't send them in. It only applies
+	// to plain HTTP/1.x connections to the tarpit listener, as the order isn't known otherwise.
+	HeaderOrder int `json:"header_order"`
+
+	// HTTP10 is for HTTP/1.0 requests, which no current browser makes.
+	HTTP10 int `json:"http_1_0"`
+
+	// UnusualMethod is for methods other than GET, HEAD and POST.
+	UnusualMethod int `json:"unusual_method"`
+
+	// LongQuery is for query strings longer than LongQueryLength bytes. A length of 0 turns it off.
+	LongQuery       int `json:"long_query"`
+	LongQueryLength int `json:"long_query_length"`
+
+	// SuspiciousPath is for requests to a SuspiciousPaths glob, matched like the path of a routing rule.
+	SuspiciousPath  int      `json:"suspicious_path"`
+	SuspiciousPaths []string `json:"suspicious_paths"`
+
+	// RobotsDisallowed is for requests to a path disallowed in the generated robots.txt, which only crawlers that
+	// ignore it follow.
+	RobotsDisallowed int `json:"robots_disallowed"`
+}
+
+// Validate checks the settings for values that can't be used.
+func (c *SignalConfig) Validate() error {
+	if c.LongQueryLength < 0 {
+		return fmt.Errorf("long_query_length must not be negative")
+	}
+	for _, pattern := range c.SuspiciousPaths {
+		if pattern == "" {
+			return fmt.Errorf("suspicious path is empty")
+		}
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("invalid suspicious path glob %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// RequestFeatures is what the threat calculator knows about a request besides the client's hit counts. It is nil for
+// connections that aren't HTTP requests.
+type RequestFeatures struct {
+	Method            string
+	Host              string
+	Path              string
+	QueryLength       int
+	HTTP10            bool
+	HasAcceptLanguage bool
+	HasAcceptEncoding bool
+	HeaderAnomaly     bool
+	HeaderOrder       bool
+	RobotsDisallowed  bool
+}
+
+// requestFeatures extracts the fingerprint features of a request. For forward-auth requests, the protocol is the
+// reverse proxy's rather than the client's, so the signals that depend on it are left out.
+func (s *Server) requestFeatures(r *http.Request) *RequestFeatures {
+	forwarded := isForwardAuthRequest(r)
+	features := &RequestFeatures{
+		Method:            r.Method,
+		Host:              r.Host,
+		Path:              r.URL.Path,
+		QueryLength:       len(r.URL.RawQuery),
+		HTTP10:            !forwarded && r.ProtoMajor == 1 && r.ProtoMinor == 0,
+		HasAcceptLanguage: r.Header.Get("Accept-Language") != "",
+		HasAcceptEncoding: r.Header.Get("Accept-Encoding") != "",
+		HeaderAnomaly:     hasHeaderAnomaly(r, forwarded),
+		HeaderOrder:       !forwarded && hasHeaderOrderAnomaly(r.UserAgent(), headerOrder(r)),
+	}
+	if files := s.cm.Get().Server.CrawlerFiles; files.Enabled {
+		for _, disallowed := range files.RobotsDisallow {
+			if disallowed != "" && strings.HasPrefix(r.URL.Path, disallowed) {
+				features.RobotsDisallowed = true
+				break
+			}
+		}
+	}
+	return features
+}
+
+// hasHeaderAnomaly reports whether a request with a browser UA has headers that don't fit a browser. Fetch metadata
+// headers are only checked over TLS, as browsers only send them to secure origins. The checks that depend on the
+// protocol are skipped for forward-auth requests, where the protocol is the reverse proxy's.
+func hasHeaderAnomaly(r *http.Request, forwarded bool) bool {
+	userAgent := r.UserAgent()
+	if !strings.HasPrefix(userAgent, "Mozilla/") {
+		return false
+	}
+	if r.Header.Get("Accept") == "" {
+		return true
+	}
+	if forwarded {
+		return false
+	}
+	if r.ProtoMajor == 1 && r.ProtoMinor == 0 {
+		return true
+	}
+	if r.TLS != nil && r.Header.Get("Sec-Fetch-Mode") == "" {
+		return true
+	}
+	// Chromium sends client hints with every request; a Chrome UA without them is usually something else.
+	if strings.Contains(userAgent, "Chrome/") && r.TLS != nil && r.Header.Get("Sec-Ch-Ua") == "" {
+		return true
+	}
+	return false
+}
+
+// score returns the total weight of the signals a request shows, and their names for logging.
+func (c *SignalConfig) score(features *RequestFeatures) (int, []string) {
+	if features == nil {
+		return 0, nil
+	}
+	total := 0
+	var matched []string
+	add := func(name string, weight int, shown bool) {
+		if shown && weight != 0 {
+			total += weight
+			matched = append(matched, name)
+		}
+	}
+
+	add("missing_accept_language", c.MissingAcceptLanguage, !features.HasAcceptLanguage)
+	add("missing_accept_encoding", c.MissingAcceptEncoding, !features.HasAcceptEncoding)
+	add("header_anomaly", c.HeaderAnomaly, features.HeaderAnomaly)
+	add("header_order", c.HeaderOrder, features.HeaderOrder)
+	add("http_1_0", c.HTTP10, features.HTTP10)
+	switch features.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+	default:
+		add("unusual_method", c.UnusualMethod, true)
+	}
+	add("long_query", c.LongQuery, c.LongQueryLength > 0 && features.QueryLength > c.LongQueryLength)
+	add("robots_disallowed", c.RobotsDisallowed, features.RobotsDisallowed)
+	for _, pattern := range c.SuspiciousPaths {
+		if matchPathGlob(pattern, features.Path) {
+			add("suspicious_path", c.SuspiciousPath, true)
+			break
+		}
+	}
+	return total, matched
+}
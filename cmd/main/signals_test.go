@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const (
+	testFirefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+	testChromeUA  = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+)
+
+func TestSignalConfig_Score(t *testing.T) {
+	config := DefaultThreatConfig().Signals
+	browser := RequestFeatures{Method: http.MethodGet, Path: "/", HasAcceptLanguage: true, HasAcceptEncoding: true}
+
+	testCases := []struct {
+		name     string
+		modify   func(c *SignalConfig, f *RequestFeatures)
+		want     int
+		wantSeen []string
+	}{
+		{"clean", func(c *SignalConfig, f *RequestFeatures) {}, 0, nil},
+		{"missing headers", func(c *SignalConfig, f *RequestFeatures) { f.HasAcceptLanguage, f.HasAcceptEncoding = false, false }, 20, []string{"missing_accept_language", "missing_accept_encoding"}},
+		{"header order", func(c *SignalConfig, f *RequestFeatures) { f.HeaderOrder = true }, 15, []string{"header_order"}},
+		{"unusual method", func(c *SignalConfig, f *RequestFeatures) { f.Method = http.MethodPut }, 10, []string{"unusual_method"}},
+		{"long query", func(c *SignalConfig, f *RequestFeatures) { f.QueryLength = 513 }, 10, []string{"long_query"}},
+		{"query at the limit", func(c *SignalConfig, f *RequestFeatures) { f.QueryLength = 512 }, 0, nil},
+		{"long query length 0 is off", func(c *SignalConfig, f *RequestFeatures) { c.LongQueryLength = 0; f.QueryLength = 10000 }, 0, nil},
+		{"suspicious path", func(c *SignalConfig, f *RequestFeatures) { f.Path = "/.git/config" }, 50, []string{"suspicious_path"}},
+		{"weight 0 is off", func(c *SignalConfig, f *RequestFeatures) { c.HTTP10 = 0; f.HTTP10 = true }, 0, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, f := config, browser
+			tc.modify(&c, &f)
+			got, seen := c.score(&f)
+			if got != tc.want || !reflect.DeepEqual(seen, tc.wantSeen) {
+				t.Errorf("score: got %d %v, want %d %v", got, seen, tc.want, tc.wantSeen)
+			}
+		})
+	}
+
+	if got, _ := config.score(nil); got != 0 {
+		t.Errorf("score without features: got %d, want 0", got)
+	}
+}
+
+func TestHasHeaderAnomaly(t *testing.T) {
+	testCases := []struct {
+		name      string
+		userAgent string
+		headers   map[string]string
+		http10    bool
+		tls       bool
+		forwarded bool
+		want      bool
+	}{
+		{"not a browser", "curl/8.0", nil, true, false, false, false},
+		{"browser", testFirefoxUA, map[string]string{"Accept": "*/*"}, false, false, false, false},
+		{"no accept", testFirefoxUA, nil, false, false, false, true},
+		{"http 1.0", testFirefoxUA, map[string]string{"Accept": "*/*"}, true, false, false, true},
+		{"tls without fetch metadata", testFirefoxUA, map[string]string{"Accept": "*/*"}, false, true, false, true},
+		{"tls with fetch metadata", testFirefoxUA, map[string]string{"Accept": "*/*", "Sec-Fetch-Mode": "navigate"}, false, true, false, false},
+		{"chrome over tls without client hints", testChromeUA, map[string]string{"Accept": "*/*", "Sec-Fetch-Mode": "navigate"}, false, true, false, true},
+		{"forwarded http 1.0", testFirefoxUA, map[string]string{"Accept": "*/*"}, true, false, true, false},
+		{"forwarded tls without fetch metadata", testChromeUA, map[string]string{"Accept": "*/*"}, false, true, true, false},
+		{"forwarded without accept", testFirefoxUA, nil, false, false, true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("User-Agent", tc.userAgent)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			if tc.http10 {
+				r.ProtoMinor = 0
+			}
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if got := hasHeaderAnomaly(r, tc.forwarded); got != tc.want {
+				t.Errorf("hasHeaderAnomaly: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServer_RequestFeatures_ForwardAuth(t *testing.T) {
+	s := newTestServer(t, `{}`)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/forward_auth", nil)
+	r.ProtoMinor = 0 // nginx's auth_request subrequests are HTTP/1.0.
+	r.Header.Set("User-Agent", testChromeUA)
+	r.Header.Set("Accept", "text/html")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Uri", "/page")
+
+	if features := s.requestFeatures(r); !features.HTTP10 || !features.HeaderAnomaly {
+		t.Errorf("direct request: got HTTP10 %v, HeaderAnomaly %v, want both", features.HTTP10, features.HeaderAnomaly)
+	}
+	original, err := originalRequest(r)
+	if err != nil {
+		t.Fatalf("originalRequest: %v", err)
+	}
+	if features := s.requestFeatures(original); features.HTTP10 || features.HeaderAnomaly {
+		t.Errorf("forward-auth request: got HTTP10 %v, HeaderAnomaly %v, want neither", features.HTTP10, features.HeaderAnomaly)
+	}
+}
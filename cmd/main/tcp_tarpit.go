@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenyxia/Sarracenia/pkg/tarpit"
+)
+
+// Protocols spoken by TCP tarpits.
+const (
+	tcpProtocolSSH    = "ssh"
+	tcpProtocolSMTP   = "smtp"
+	tcpProtocolTelnet = "telnet"
+	tcpProtocolFTP    = "ftp"
+)
+
+// tcpWriteTimeout is how long a single line may take to send before the client is considered gone.
+const tcpWriteTimeout = 30 * time.Second
+
+// TCPTarpitConfig is a raw TCP listener that speaks just enough of a protocol to hold a connection open, trickling
+// out one line at a time without ever getting to the point:
+//
+//   - ssh sends endless pre-banner lines, which clients must read and discard until the version line arrives.
+//   - smtp and ftp send an endless multi-line 220 greeting.
+//   - telnet sends an endless login banner.
+type TCPTarpitConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Protocol string `json:"protocol"`
+	Addr     string `json:"addr"`
+
+	// Range of the delay between lines.
+	LineDelayMin int `json:"min_line_delay_ms"`
+	LineDelayMax int `json:"max_line_delay_ms"`
+
+	// MaxHoldSec is the longest a connection is held. 0 holds it until the client gives up.
+	MaxHoldSec int `json:"max_hold_sec"`
+
+	// TarpitThreshold is the threat level at which connections are held. Below it, clients get a short, plausible
+	// refusal and are disconnected.
+	TarpitThreshold int `json:"tarpit_threshold"`
+}
+
+// TCPTarpitsConfig holds every TCP tarpit listener.
+type TCPTarpitsConfig []TCPTarpitConfig
+
+// Validate checks each listener, and that no two enabled listeners share an address.
+func (c TCPTarpitsConfig) Validate() error {
+	addrs := make(map[string]bool)
+	for i, t := range c {
+		switch t.Protocol {
+		case tcpProtocolSSH, tcpProtocolSMTP, tcpProtocolTelnet, tcpProtocolFTP:
+		default:
+			return fmt.Errorf("tcp tarpit %d: unknown protocol %q", i, t.Protocol)
+		}
+		if t.Addr == "" {
+			return fmt.Errorf("tcp tarpit %d (%s): addr is required", i, t.Protocol)
+		}
+		if t.LineDelayMin < 0 || t.LineDelayMax < t.LineDelayMin {
+			return fmt.Errorf("tcp tarpit %d (%s): line delays must be non-negative, with the max at least the min", i, t.Protocol)
+		}
+		if t.MaxHoldSec < 0 {
+			return fmt.Errorf("tcp tarpit %d (%s): max_hold_sec must not be negative", i, t.Protocol)
+		}
+		if t.Enabled {
+			if addrs[t.Addr] {
+				return fmt.Errorf("tcp tarpit %d (%s): addr %s is used by another listener", i, t.Protocol, t.Addr)
+			}
+			addrs[t.Addr] = true
+		}
+	}
+	return nil
+}
+
+// userAgent is what a TCP tarpit's connections are recorded as in the user agent stats, and matched against in the
+// whitelist, since raw TCP clients don't send one.
+func (c TCPTarpitConfig) userAgent() string {
+	return "tcp/" + c.Protocol
+}
+
+// refusal is sent to clients below the tarpit threshold before they are disconnected.
+func (c TCPTarpitConfig) refusal() string {
+	switch c.Protocol {
+	case tcpProtocolSSH:
+		return "SSH-2.0-OpenSSH_9.6\r\n"
+	case tcpProtocolSMTP:
+		return "554 5.3.2 Service not available\r\n"
+	case tcpProtocolFTP:
+		return "421 Service not available, closing control connection.\r\n"
+	default:
+		return ""
+	}
+}
+
+// line returns the next line to trickle out.
+func (c TCPTarpitConfig) line() string {
+	switch c.Protocol {
+	case tcpProtocolSSH:
+		// Any line before the version line is allowed, as long as it doesn't look like the version line itself.
+		line := randomText(rand.IntN(30) + 3)
+		if strings.HasPrefix(line, "SSH-") {
+			line = "x" + line
+		}
+		return line + "\r\n"
+	case tcpProtocolSMTP, tcpProtocolFTP:
+		// "220-" continues the greeting; only "220 " would end it.
+		return "220-" + randomText(rand.IntN(50)+10) + "\r\n"
+	default:
+		return randomText(rand.IntN(60)+10) + "\r\n"
+	}
+}
+
+const randomTextAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 .,:;-_/"
+
+// randomText returns n random printable characters.
+func randomText(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = randomTextAlphabet[rand.IntN(len(randomTextAlphabet))]
+	}
+	return string(b)
+}
+
+// startTCPTarpits starts every enabled TCP tarpit listener. Listeners that fail to start are logged and skipped, like
+// the HTTP servers. The returned function closes the listeners and every connection they hold.
+func (s *Server) startTCPTarpits(configs TCPTarpitsConfig) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var listeners []net.Listener
+	var wg sync.WaitGroup
+
+	for _, config := range configs {
+		if !config.Enabled {
+			continue
+		}
+		listener, err := net.Listen("tcp", config.Addr)
+		if err != nil {
+			s.logger.Error("TCP tarpit failed", "protocol", config.Protocol, "address", config.Addr, "error", err)
+			continue
+		}
+		s.logger.Info("Starting TCP tarpit", "protocol", config.Protocol, "address", config.Addr)
+		listeners = append(listeners, listener)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveTCPTarpit(ctx, listener, config, &wg)
+		}()
+	}
+
+	return func() {
+		cancel()
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+		wg.Wait()
+	}
+}
+
+// serveTCPTarpit accepts connections until the listener is closed.
+func (s *Server) serveTCPTarpit(ctx context.Context, listener net.Listener, config TCPTarpitConfig, wg *sync.WaitGroup) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warn("Failed to accept TCP tarpit connection", "protocol", config.Protocol, "error", err)
+			// Back off on e.g. running out of file descriptors, rather than spinning.
+			if tarpit.SleepContext(ctx, time.Second) != nil {
+				return
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { _ = conn.Close() }()
+			s.handleTCPConn(ctx, conn, config)
+		}()
+	}
+}
+
+// handleTCPConn scores a connection the same way as a tarpit request, then either refuses it or trickles lines to it
+// until the client disconnects, the hold time runs out, or the server stops. Blocklisted clients are always held.
+func (s *Server) handleTCPConn(ctx context.Context, conn net.Conn, config TCPTarpitConfig) {
+	ipAddr, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		ipAddr = conn.RemoteAddr().String()
+	}
+	userAgent := config.userAgent()
+	if s.wlc.Matches(ipAddr, userAgent) {
+		s.logger.Debug("TCP connection from whitelisted client, closing.", "protocol", config.Protocol, "remote_addr", ipAddr)
+		return
+	}
+
+	metrics := s.statsAPI.LogAndGetConnMetrics(ipAddr, userAgent)
+	threatLevel, _, blocklisted := s.blocklistedThreat(metrics, nil)
+	if !blocklisted && threatLevel < config.TarpitThreshold {
+		s.logger.Debug("TCP connection below tarpit threshold, refusing.", "protocol", config.Protocol, "remote_addr", ipAddr, "Threat_level", threatLevel)
+		_ = conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+		_, _ = conn.Write([]byte(config.refusal()))
+		return
+	}
+
+	if !s.connections.Acquire(ipAddr, *s.cm.Get().Server.ConnectionLimits) {
+		s.logger.Debug("TCP connection over the connection limits, closing.", "protocol", config.Protocol, "remote_addr", ipAddr)
+		s.statsAPI.RecordOverLimit(true)
+		return
+	}
+	defer s.connections.Release(ipAddr)
+
+	if config.MaxHoldSec > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(config.MaxHoldSec)*time.Second)
+		defer cancel()
+	}
+
+	start := time.Now()
+	lines := 0
+	reason := "hold time reached"
+	for {
+		_ = conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+		if _, err = conn.Write([]byte(config.line())); err != nil {
+			reason = "client disconnected"
+			break
+		}
+		lines++
+		delay := time.Duration(config.LineDelayMin+rand.IntN(config.LineDelayMax-config.LineDelayMin+1)) * time.Millisecond
+		if err = tarpit.SleepContext(ctx, delay); err != nil {
+			if errors.Is(err, context.Canceled) {
+				reason = "server stopping"
+			}
+			break
+		}
+	}
+
+	held := time.Since(start)
+	s.statsAPI.RecordTCPConnection(config.Protocol, held)
+	s.logger.Info("TCP tarpit connection closed",
+		"protocol", config.Protocol,
+		"remote_addr", ipAddr,
+		"Threat_level", threatLevel,
+		"lines", lines,
+		"held", held.Round(time.Second),
+		"reason", reason)
+}
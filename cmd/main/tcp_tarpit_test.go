@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_ServeTCPTarpit(t *testing.T) {
+	s := newTestServer(t, `{}`)
+
+	testCases := []struct {
+		protocol     string
+		wantLine     func(line string) bool
+		wantRefusal  string
+		refusalEmpty bool
+	}{
+		{
+			protocol:    tcpProtocolSSH,
+			wantLine:    func(line string) bool { return !strings.HasPrefix(line, "SSH-") },
+			wantRefusal: "SSH-2.0-",
+		},
+		{
+			protocol:    tcpProtocolSMTP,
+			wantLine:    func(line string) bool { return strings.HasPrefix(line, "220-") },
+			wantRefusal: "554 ",
+		},
+		{
+			protocol:     tcpProtocolTelnet,
+			wantLine:     func(line string) bool { return len(line) > 2 },
+			refusalEmpty: true,
+		},
+		{
+			protocol:    tcpProtocolFTP,
+			wantLine:    func(line string) bool { return strings.HasPrefix(line, "220-") },
+			wantRefusal: "421 ",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.protocol, func(t *testing.T) {
+			t.Run("Held", func(t *testing.T) {
+				config := TCPTarpitConfig{Enabled: true, Protocol: tc.protocol, LineDelayMax: 1}
+				reader := dialTCPTarpit(t, s, config)
+				for i := 0; i < 3; i++ {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						t.Fatalf("line %d: %v", i, err)
+					}
+					if !strings.HasSuffix(line, "\r\n") || !tc.wantLine(line) {
+						t.Errorf("line %d: got %q", i, line)
+					}
+				}
+			})
+
+			t.Run("Refused", func(t *testing.T) {
+				config := TCPTarpitConfig{Enabled: true, Protocol: tc.protocol, TarpitThreshold: 1_000_000}
+				reader := dialTCPTarpit(t, s, config)
+				got, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("reading refusal: %v", err)
+				}
+				if tc.refusalEmpty && len(got) != 0 {
+					t.Errorf("refusal: got %q, want nothing", got)
+				}
+				if !tc.refusalEmpty && !strings.HasPrefix(string(got), tc.wantRefusal) {
+					t.Errorf("refusal: got %q, want it to start with %q", got, tc.wantRefusal)
+				}
+			})
+		})
+	}
+}
+
+// dialTCPTarpit serves a TCP tarpit on a local listener, and connects to it. The tarpit is stopped when the test ends.
+func dialTCPTarpit(t *testing.T, s *Server, config TCPTarpitConfig) *bufio.Reader {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.serveTCPTarpit(ctx, listener, config, &wg)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() {
+		_ = conn.Close()
+		cancel()
+		_ = listener.Close()
+		wg.Wait()
+	})
+	return bufio.NewReader(conn)
+}
+
+func TestServer_ServeTCPTarpit_Blocklisted(t *testing.T) {
+	s := newTestServer(t, `{}`)
+	if err := s.blc.Add(listTypeIP, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocklisted clients are held whatever their threat level.
+	config := TCPTarpitConfig{Enabled: true, Protocol: tcpProtocolSMTP, LineDelayMax: 1, TarpitThreshold: 1_000_000}
+	line, err := dialTCPTarpit(t, s, config).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading line: %v", err)
+	}
+	if !strings.HasPrefix(line, "220-") {
+		t.Errorf("got %q, want a 220- greeting line", line)
+	}
+}
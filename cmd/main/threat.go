@@ -1,23 +1,172 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"math"
+	"sync"
+	"time"
 )
 
 // StageConfig defines the parameters for a single Threat stage.
 type StageConfig struct {
-	Enabled   bool `json:"enabled"`
-	Threshold int  `json:"threshold"`
+	// Name identifies the stage in the config, and is passed to templates along with the stage's index.
+	Name      string `json:"name"`
+	Enabled   bool   `json:"enabled"`
+	Threshold int    `json:"threshold"`
+
+	// EndlessPage keeps appending generated content to the response once the page has been sent,
+	// until the client disconnects or the tarpit's endless limits are reached.
+	EndlessPage bool `json:"endless_page"`
+
+	// CompressedPayload appends a highly compressible run of whitespace, up to the tarpit's
+	// compressed_payload_max_bytes once decompressed, to compressed responses once the page has been sent.
+	// Meant for the top stage, as it is expensive for the client to decompress.
+	CompressedPayload bool `json:"compressed_payload"`
+
+	// Templates replaces the server's EnabledTemplates for requests at this stage, so light pages can go to
+	// low-threat visitors and expensive ones to the worst offenders. Empty uses EnabledTemplates.
+	Templates []WeightedTemplate `json:"templates"`
+
+	// Behaviors replaces the global behavior weights for requests at this stage, e.g. to send the worst offenders
+	// through more redirects. Unset uses the global weights.
+	Behaviors *BehaviorWeights `json:"behaviors,omitempty"`
+
+	// Tarpit overrides the global drip-feed, delay, and header settings for requests at this stage.
+	// A matching routing rule's overrides are applied on top of these.
+	Tarpit TarpitOverrides `json:"tarpit"`
 }
 
-// ThreatStages holds the configuration for the 5 discrete Threat stages.
-type ThreatStages struct {
-	Stage0 StageConfig `json:"stage_1"`
-	Stage1 StageConfig `json:"stage_2"`
-	Stage2 StageConfig `json:"stage_3"`
-	Stage3 StageConfig `json:"stage_4"`
-	Stage4 StageConfig `json:"stage_5"`
+// WeightedTemplate is a template name with a relative weight for random selection.
+type WeightedTemplate struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// UnmarshalJSON accepts either a plain template name or a {"name", "weight"} object.
+// The weight defaults to 1 in both cases.
+func (t *WeightedTemplate) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = WeightedTemplate{Name: name, Weight: 1}
+		return nil
+	}
+	type plain WeightedTemplate // Avoids recursing into this method.
+	p := plain{Weight: 1}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*t = WeightedTemplate(p)
+	return nil
+}
+
+// pickWeighted chooses a template name with a probability proportional to its weight, drawing from intN.
+// It returns an empty string if no template has a positive weight.
+func pickWeighted(templates []WeightedTemplate, intN func(int) int) string {
+	total := 0
+	for _, t := range templates {
+		total += max(t.Weight, 0)
+	}
+	if total == 0 {
+		return ""
+	}
+	choice := intN(total)
+	for _, t := range templates {
+		choice -= max(t.Weight, 0)
+		if choice < 0 {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+// ThreatStages is the list of Threat stages, ordered from the least aggressive to the most. A stage's index in the
+// list is its level.
+type ThreatStages []StageConfig
+
+// UnmarshalJSON accepts either a list of stages or, from configs written before stages were a list, an object with
+// stage_1 to stage_5 keys. Old stages are named after their keys, and stages or fields they leave out keep their
+// defaults, as they did before.
+func (s *ThreatStages) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+		var legacy map[string]json.RawMessage
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+		stages := DefaultThreatConfig().Stages
+		for i := range stages {
+			raw, ok := legacy[stages[i].Name]
+			if !ok {
+				continue
+			}
+			if err := json.Unmarshal(raw, &stages[i]); err != nil {
+				return fmt.Errorf("%s: %w", stages[i].Name, err)
+			}
+		}
+		*s = stages
+		return nil
+	}
+
+	var stages []StageConfig
+	if err := json.Unmarshal(data, &stages); err != nil {
+		return err
+	}
+	*s = stages
+	return nil
+}
+
+// Get returns the configuration for a stage by index. Out of range stages are clamped.
+func (s ThreatStages) Get(stage int) StageConfig {
+	if len(s) == 0 {
+		return StageConfig{}
+	}
+	return s[max(0, min(stage, len(s)-1))]
+}
+
+// Validate checks that there is at least one stage, that stage names are set and unique, that enabled stages'
+// thresholds don't decrease, and the per-stage settings for values that can't be used. Tarpit overrides are checked
+// against the global tarpit settings they will be applied to.
+func (s ThreatStages) Validate(tarpit TarpitConfig) error {
+	if len(s) == 0 {
+		return fmt.Errorf("at least one stage is required")
+	}
+	names := make(map[string]bool, len(s))
+	previous := -1 // The last enabled stage so far.
+	for i, stage := range s {
+		if stage.Name == "" {
+			return fmt.Errorf("stage %d: name is required", i)
+		}
+		if names[stage.Name] {
+			return fmt.Errorf("stage %s: name is used more than once", stage.Name)
+		}
+		names[stage.Name] = true
+		if stage.Enabled {
+			if previous >= 0 && stage.Threshold < s[previous].Threshold {
+				return fmt.Errorf("stage %s: threshold %d is below the threshold of %s before it (%d)", stage.Name, stage.Threshold, s[previous].Name, s[previous].Threshold)
+			}
+			previous = i
+		}
+
+		for _, t := range stage.Templates {
+			if t.Name == "" {
+				return fmt.Errorf("stage %s: template name is empty", stage.Name)
+			}
+			if t.Weight < 0 {
+				return fmt.Errorf("stage %s: template %s has a negative weight", stage.Name, t.Name)
+			}
+		}
+		if stage.Behaviors != nil {
+			if err := stage.Behaviors.Validate(); err != nil {
+				return fmt.Errorf("stage %s: %w", stage.Name, err)
+			}
+		}
+		if err := stage.Tarpit.Validate(tarpit); err != nil {
+			return fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+	}
+	return nil
 }
 
 // ThreatConfig holds all parameters for calculating the Threat score.
@@ -34,42 +183,115 @@ type ThreatConfig struct {
 	UAHitFactor float64 `json:"ua_hit_factor"`
 
 	// IPHitRateFactor determines how much weight is given to the frequency of requests
-	// from an IP (hits per minute). A high value strongly penalizes rapid requests.
+	// from an IP (hits per minute since it was first seen). A high value strongly penalizes rapid requests.
 	IPHitRateFactor float64 `json:"ip_hit_rate_factor"`
 
 	// UAHitRateFactor determines how much weight is given to the frequency of requests
-	// from a User Agent. This can help identify distributed botnets using the same UA.
+	// from a User Agent since it was first seen. This can help identify distributed botnets using the same UA.
 	UAHitRateFactor float64 `json:"ua_hit_rate_factor"`
 
+	// IPWindowRateFactors and UAWindowRateFactors weigh the hit rates over the last minute, ten minutes and hour.
+	// Unlike the lifetime rates above, these catch a client that bursts after a slow start, and forget one that has
+	// stopped.
+	IPWindowRateFactors WindowRateFactors `json:"ip_window_rate_factors"`
+	UAWindowRateFactors WindowRateFactors `json:"ua_window_rate_factors"`
+
+	// SubnetHitFactor and SubnetWindowRateFactors weigh the hits of the client's whole subnet, which catch a scraper
+	// spreading its requests over many addresses so each one stays quiet.
+	SubnetHitFactor         float64           `json:"subnet_hit_factor"`
+	SubnetWindowRateFactors WindowRateFactors `json:"subnet_window_rate_factors"`
+
+	// ASNHitFactor and ASNWindowRateFactors do the same for the client's autonomous system. They only apply with an
+	// ASN database configured.
+	ASNHitFactor         float64           `json:"asn_hit_factor"`
+	ASNWindowRateFactors WindowRateFactors `json:"asn_window_rate_factors"`
+
+	// CrawlerFamilyBonus is added to the score of requests from a crawler family in the crawler catalogue, by family
+	// ID. The "*" key applies to every family without its own entry.
+	CrawlerFamilyBonus map[string]int `json:"crawler_family_bonus"`
+
+	// Signals weighs fingerprint signals taken from the request itself, such as missing headers or bait paths.
+	Signals SignalConfig `json:"signals"`
+
+	// Rules adjust the score, or force a stage, for requests matching an expression. They run after everything
+	// else, in order.
+	Rules []ThreatRule `json:"rules"`
+
 	// MaxThreat is the absolute ceiling for the Threat score to prevent runaway values.
 	MaxThreat int `json:"max_threat"`
 
-	// FallbackLevel is the default Threat level (0-4) to use if an incoming
+	// FallbackLevel is the default Threat stage index to use if an incoming
 	// request's score does not meet any enabled stage thresholds.
 	FallbackLevel int `json:"fallback_level"`
 
-	// Stages defines the score thresholds for each of the 5 Threat levels.
+	// Stages defines the score thresholds and settings of each Threat stage.
 	Stages ThreatStages `json:"stages"`
 }
 
+// WindowRateFactors are multipliers for the hit rates (hits/min) over each window.
+type WindowRateFactors struct {
+	OneMinute  float64 `json:"1m"`
+	TenMinutes float64 `json:"10m"`
+	OneHour    float64 `json:"1h"`
+}
+
+// score returns the weighted sum of the rates.
+func (f WindowRateFactors) score(rates WindowRates) float64 {
+	return rates.OneMinute*f.OneMinute + rates.TenMinutes*f.TenMinutes + rates.OneHour*f.OneHour
+}
+
+// scaledTo returns the factors scaled so that they add up to total.
+func (f WindowRateFactors) scaledTo(total float64) WindowRateFactors {
+	sum := f.OneMinute + f.TenMinutes + f.OneHour
+	if sum == 0 {
+		return f
+	}
+	scale := total / sum
+	return WindowRateFactors{OneMinute: f.OneMinute * scale, TenMinutes: f.TenMinutes * scale, OneHour: f.OneHour * scale}
+}
+
 // DefaultThreatConfig returns a new ThreatConfig with the Threat system disabled by default.
 func DefaultThreatConfig() *ThreatConfig {
 	return &ThreatConfig{
-		BaseThreat:      0,
-		IPHitFactor:     1.0,
-		UAHitFactor:     0.5,
-		IPHitRateFactor: 10.0,
-		UAHitRateFactor: 5.0,
-		MaxThreat:       1000,
-		FallbackLevel:   0, // Default to the least aggressive level.
+		BaseThreat:              0,
+		IPHitFactor:             1.0,
+		UAHitFactor:             0.5,
+		IPHitRateFactor:         0,
+		UAHitRateFactor:         0,
+		IPWindowRateFactors:     WindowRateFactors{OneMinute: 4, TenMinutes: 4, OneHour: 2},
+		UAWindowRateFactors:     WindowRateFactors{OneMinute: 2, TenMinutes: 2, OneHour: 1},
+		SubnetHitFactor:         0.2,
+		SubnetWindowRateFactors: WindowRateFactors{OneMinute: 1, TenMinutes: 1, OneHour: 0.5},
+		ASNHitFactor:            0,
+		ASNWindowRateFactors:    WindowRateFactors{OneMinute: 0.5, TenMinutes: 0.5, OneHour: 0.25},
+		CrawlerFamilyBonus:      map[string]int{},
+		Signals: SignalConfig{
+			MissingAcceptLanguage: 10,
+			MissingAcceptEncoding: 10,
+			HeaderAnomaly:         15,
+			HeaderOrder:           15,
+			HTTP10:                10,
+			UnusualMethod:         10,
+			LongQuery:             10,
+			LongQueryLength:       512,
+			SuspiciousPath:        50,
+			SuspiciousPaths: []string{
+				"/.env", "/.git/**", "/.aws/**", "/wp-admin/**", "/wp-login.php", "/xmlrpc.php",
+				"/phpmyadmin/**", "/server-status", "/cgi-bin/**", "/*.php.bak", "/*.sql",
+			},
+			RobotsDisallowed: 50,
+		},
+		Rules:         []ThreatRule{},
+		MaxThreat:     1000,
+		FallbackLevel: 0, // Default to the least aggressive level.
 		Stages: ThreatStages{
-			// Stage 0 is always enabled with a threshold of 0.
-			Stage0: StageConfig{Enabled: true, Threshold: 0},
+			// The first stage is always enabled with a threshold of 0.
+			{Name: "stage_1", Enabled: true, Threshold: 0},
 			// Subsequent stages are disabled by default, effectively disabling the Threat system
-			Stage1: StageConfig{Enabled: false, Threshold: 25},
-			Stage2: StageConfig{Enabled: false, Threshold: 50},
-			Stage3: StageConfig{Enabled: false, Threshold: 75},
-			Stage4: StageConfig{Enabled: false, Threshold: 100},
+			{Name: "stage_2", Enabled: false, Threshold: 25},
+			{Name: "stage_3", Enabled: false, Threshold: 50},
+			{Name: "stage_4", Enabled: false, Threshold: 75},
+			{Name: "stage_5", Enabled: false, Threshold: 100},
 		},
 	}
 }
@@ -77,11 +299,13 @@ func DefaultThreatConfig() *ThreatConfig {
 // ThreatCalculator is responsible for turning request metrics into a quantifiable
 // Threat score and level.
 type ThreatCalculator struct {
+	mu     sync.RWMutex
 	config *ThreatConfig
+	rules  []compiledThreatRule
 	logger *slog.Logger
 }
 
-// NewThreatCalculator creates a new calculator with the given configuration.
+// NewThreatCalculator creates a new calculator with the given configuration. Its rules are set by SetConfig.
 func NewThreatCalculator(config *ThreatConfig, logger *slog.Logger) *ThreatCalculator {
 	return &ThreatCalculator{
 		config: config,
@@ -89,29 +313,74 @@ func NewThreatCalculator(config *ThreatConfig, logger *slog.Logger) *ThreatCalcu
 	}
 }
 
-// GetThreatLevel calculates a raw threat score based on the provided request metrics
-// and the configured weights and factors.
-func (c *ThreatCalculator) GetThreatLevel(metrics *RequestMetrics) int {
-	score := float64(c.config.BaseThreat)
+// SetConfig replaces the configuration and the compiled rules.
+func (c *ThreatCalculator) SetConfig(config *ThreatConfig, rules []compiledThreatRule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = config
+	c.rules = rules
+}
+
+// hitRate returns hits per minute since the first hit, or 0 for a single hit.
+func hitRate(hits int, sinceFirst time.Duration) float64 {
+	if hits <= 1 {
+		return 0
+	}
+	return float64(hits) / math.Max(sinceFirst.Minutes(), 1.0/60.0)
+}
+
+// GetThreat calculates a threat score based on the provided request metrics and fingerprint features, the
+// configured weights and factors, and the threat rules, and maps it to a stage. features may be nil for connections
+// that aren't HTTP requests. forcedBy is the name of the rule that forced the stage, if any.
+func (c *ThreatCalculator) GetThreat(metrics *RequestMetrics, features *RequestFeatures) (threatLevel, threatStage int, forcedBy string) {
+	c.mu.RLock()
+	config, rules := c.config, c.rules
+	c.mu.RUnlock()
+
+	score := float64(config.BaseThreat)
 
-	score += float64(metrics.IPTotalHits) * c.config.IPHitFactor
-	score += float64(metrics.UATotalHits) * c.config.UAHitFactor
+	score += float64(metrics.IPTotalHits) * config.IPHitFactor
+	score += float64(metrics.UATotalHits) * config.UAHitFactor
+	score += hitRate(metrics.IPTotalHits, metrics.TimeSinceIPFirst) * config.IPHitRateFactor
+	score += hitRate(metrics.UATotalHits, metrics.TimeSinceUAFirst) * config.UAHitRateFactor
+	score += config.IPWindowRateFactors.score(metrics.IPRates)
+	score += config.UAWindowRateFactors.score(metrics.UARates)
+	score += float64(metrics.SubnetTotalHits) * config.SubnetHitFactor
+	score += config.SubnetWindowRateFactors.score(metrics.SubnetRates)
+	score += float64(metrics.ASNTotalHits) * config.ASNHitFactor
+	score += config.ASNWindowRateFactors.score(metrics.ASNRates)
 
-	if metrics.IPTotalHits > 1 {
-		ipMinutes := math.Max(metrics.TimeSinceIPFirst.Minutes(), 1.0/60.0)
-		ipRate := float64(metrics.IPTotalHits) / ipMinutes
-		score += ipRate * c.config.IPHitRateFactor
+	if metrics.CrawlerFamily != "" {
+		bonus, ok := config.CrawlerFamilyBonus[metrics.CrawlerFamily]
+		if !ok {
+			bonus = config.CrawlerFamilyBonus["*"]
+		}
+		score += float64(bonus)
 	}
 
-	if metrics.UATotalHits > 1 {
-		uaMinutes := math.Max(metrics.TimeSinceUAFirst.Minutes(), 1.0/60.0)
-		uaRate := float64(metrics.UATotalHits) / uaMinutes
-		score += uaRate * c.config.UAHitRateFactor
+	signalScore, signals := config.Signals.score(features)
+	score += float64(signalScore)
+
+	// Rules run in order, each seeing the score left by the ones before it, until one forces a stage.
+	var matchedRules []string
+	forcedStage := -1
+	env := &ruleEnv{metrics: metrics, features: features}
+	for _, rule := range rules {
+		env.score = score
+		if !rule.expr.evalBool(env) {
+			continue
+		}
+		matchedRules = append(matchedRules, rule.rule.Name)
+		score += float64(rule.rule.Score)
+		if rule.rule.Stage != nil {
+			forcedStage, forcedBy = *rule.rule.Stage, rule.rule.Name
+			break
+		}
 	}
 
 	finalScore := int(score)
-	if finalScore > c.config.MaxThreat {
-		finalScore = c.config.MaxThreat
+	if finalScore > config.MaxThreat {
+		finalScore = config.MaxThreat
 	} else if finalScore < 0 {
 		finalScore = 0
 	}
@@ -119,36 +388,45 @@ func (c *ThreatCalculator) GetThreatLevel(metrics *RequestMetrics) int {
 	c.logger.Debug("Calculated threat",
 		"ip", metrics.IPAddress,
 		"user_agent", metrics.UserAgent,
+		"crawler_family", metrics.CrawlerFamily,
+		"subnet", metrics.Subnet,
+		"asn", metrics.ASN,
+		"signals", signals,
+		"rules", matchedRules,
 		"raw_score", score,
 		"final_score", finalScore,
 	)
 
-	return finalScore
+	if forcedStage >= 0 {
+		return finalScore, forcedStage, forcedBy
+	}
+	return finalScore, c.GetStage(finalScore), ""
 }
 
-// GetStage maps a raw threat level to a discrete stage from 0-4.
-// It iterates from the highest stage (4) to the lowest, respecting the Enabled
+// GetStage maps a raw threat level to a stage index.
+// It iterates from the last (most aggressive) stage to the first, respecting the Enabled
 // flag for each stage. If no enabled stage threshold is met, it returns the
 // configured FallbackLevel.
 func (c *ThreatCalculator) GetStage(threatLevel int) int {
-	// Create a temporary slice for easy iteration from highest to lowest level.
-	stages := []StageConfig{
-		c.config.Stages.Stage4, // Level 4
-		c.config.Stages.Stage3, // Level 3
-		c.config.Stages.Stage2, // Level 2
-		c.config.Stages.Stage1, // Level 1
-		c.config.Stages.Stage0, // Level 0
-	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
 	// Check from the most aggressive stage downwards.
-	for i, stage := range stages {
-		level := 4 - i // Calculate the corresponding level (4, 3, 2, 1, 0)
-		if stage.Enabled && threatLevel >= stage.Threshold {
+	stages := c.config.Stages
+	for level := len(stages) - 1; level >= 0; level-- {
+		if stages[level].Enabled && threatLevel >= stages[level].Threshold {
 			return level // Return the highest applicable level.
 		}
 	}
 
 	// If no enabled stages were matched, return the fallback.
-	// We clamp the value to ensure it's always within the valid 0-4 range.
-	return max(0, min(c.config.FallbackLevel, 4))
+	// We clamp the value to ensure it's always a valid stage.
+	return max(0, min(c.config.FallbackLevel, len(stages)-1))
+}
+
+// StageName returns the name of a stage by index.
+func (c *ThreatCalculator) StageName(stage int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config.Stages.Get(stage).Name
 }
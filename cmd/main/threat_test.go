@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestThreatStages_UnmarshalJSON(t *testing.T) {
+	t.Run("Legacy", func(t *testing.T) {
+		// Configs from before stages were a list have a stage_1 to stage_5 object, which may leave stages and fields
+		// out.
+		var stages ThreatStages
+		err := json.Unmarshal([]byte(`{
+			"stage_1": {"enabled": true, "threshold": 0},
+			"stage_3": {"enabled": true, "threshold": 40, "endless_page": true, "templates": [{"name": "maze", "weight": 2}]},
+			"stage_5": {"enabled": true}
+		}`), &stages)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+
+		want := DefaultThreatConfig().Stages
+		want[2].Enabled, want[2].Threshold, want[2].EndlessPage = true, 40, true
+		want[2].Templates = []WeightedTemplate{{Name: "maze", Weight: 2}}
+		want[4].Enabled = true
+		if len(stages) != len(want) {
+			t.Fatalf("got %d stages, want %d", len(stages), len(want))
+		}
+		for i := range want {
+			got, wantStage := stages[i], want[i]
+			if got.Name != wantStage.Name || got.Enabled != wantStage.Enabled || got.Threshold != wantStage.Threshold ||
+				got.EndlessPage != wantStage.EndlessPage || len(got.Templates) != len(wantStage.Templates) {
+				t.Errorf("stage %d: got %+v, want %+v", i, got, wantStage)
+			}
+		}
+		if err = stages.Validate(*DefaultServerConfig().TarpitConfig); err != nil {
+			t.Errorf("migrated stages are invalid: %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		var stages ThreatStages
+		err := json.Unmarshal([]byte(`[{"name": "calm", "enabled": true}, {"name": "angry", "enabled": true, "threshold": 10}]`), &stages)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if len(stages) != 2 || stages[0].Name != "calm" || stages[1].Name != "angry" || stages[1].Threshold != 10 {
+			t.Errorf("got %+v", stages)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, data := range []string{`{"stage_2": {"threshold": "high"}}`, `[{"name": 1}]`, `"stage_1"`} {
+			var stages ThreatStages
+			if err := json.Unmarshal([]byte(data), &stages); err == nil {
+				t.Errorf("Unmarshal(%s): got no error", data)
+			}
+		}
+	})
+}
+
+func TestThreatStages_Validate(t *testing.T) {
+	tarpit := *DefaultServerConfig().TarpitConfig
+
+	testCases := []struct {
+		name    string
+		stages  ThreatStages
+		wantErr string
+	}{
+		{"defaults", DefaultThreatConfig().Stages, ""},
+		{"one stage", ThreatStages{{Name: "only", Enabled: true}}, ""},
+		{"many stages", ThreatStages{{Name: "a", Enabled: true}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}, {Name: "f"}, {Name: "g"}}, ""},
+		{"disabled stages may be out of order", ThreatStages{{Name: "a", Enabled: true, Threshold: 10}, {Name: "b", Threshold: 5}, {Name: "c", Enabled: true, Threshold: 20}}, ""},
+		{"no stages", ThreatStages{}, "at least one stage"},
+		{"missing name", ThreatStages{{Name: "a"}, {}}, "stage 1: name is required"},
+		{"duplicate name", ThreatStages{{Name: "a"}, {Name: "a"}}, "used more than once"},
+		{"decreasing threshold", ThreatStages{{Name: "a", Enabled: true, Threshold: 10}, {Name: "b", Threshold: 50}, {Name: "c", Enabled: true, Threshold: 5}}, "below the threshold of a"},
+		{"empty template name", ThreatStages{{Name: "a", Templates: []WeightedTemplate{{Weight: 1}}}}, "stage a: template name is empty"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.stages.Validate(tarpit)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate: got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Validate: got %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestThreatCalculator_GetStage(t *testing.T) {
+	config := DefaultThreatConfig()
+	config.Stages = ThreatStages{
+		{Name: "calm", Enabled: true, Threshold: 0},
+		{Name: "wary", Enabled: true, Threshold: 20},
+		{Name: "unused", Enabled: false, Threshold: 30},
+		{Name: "hostile", Enabled: true, Threshold: 40},
+		{Name: "furious", Enabled: true, Threshold: 80},
+		{Name: "scorched", Enabled: true, Threshold: 200},
+	}
+	c := NewThreatCalculator(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	testCases := []struct {
+		threatLevel int
+		wantStage   int
+		wantName    string
+	}{
+		{0, 0, "calm"},
+		{19, 0, "calm"},
+		{20, 1, "wary"},
+		{35, 1, "wary"},
+		{40, 3, "hostile"},
+		{199, 4, "furious"},
+		{1000, 5, "scorched"},
+	}
+
+	for _, tc := range testCases {
+		stage := c.GetStage(tc.threatLevel)
+		if name := c.StageName(stage); stage != tc.wantStage || name != tc.wantName {
+			t.Errorf("GetStage(%d): got %d (%s), want %d (%s)", tc.threatLevel, stage, name, tc.wantStage, tc.wantName)
+		}
+	}
+
+	// Without an enabled stage reached, the fallback is used, clamped to the stages there are.
+	config.Stages[0].Enabled = false
+	for _, fallback := range []struct{ level, want int }{{2, 2}, {9, 5}, {-1, 0}} {
+		config.FallbackLevel = fallback.level
+		if got := c.GetStage(5); got != fallback.want {
+			t.Errorf("fallback %d: got stage %d, want %d", fallback.level, got, fallback.want)
+		}
+	}
+}
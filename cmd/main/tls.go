@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TLSConfig enables HTTPS on the tarpit and API listeners. Both use the same certificate, which is reloaded when its
+// files change, so renewals don't need a restart.
+type TLSConfig struct {
+	// Tarpit and API turn on TLS for each listener.
+	Tarpit bool `json:"tarpit"`
+	API    bool `json:"api"`
+
+	// CertFile and KeyFile are PEM files. The certificate file may include intermediates after the leaf.
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// SelfSigned generates a self-signed certificate for Hosts into CertFile and KeyFile on start, if they don't
+	// exist yet.
+	SelfSigned bool     `json:"self_signed"`
+	Hosts      []string `json:"hosts"`
+
+	// ReloadCheckSec is how often, at most, the files are checked for changes.
+	ReloadCheckSec int `json:"reload_check_sec"`
+}
+
+// Enabled reports whether either listener uses TLS.
+func (c *TLSConfig) Enabled() bool {
+	return c.Tarpit || c.API
+}
+
+// Validate checks that the files are set if TLS is enabled.
+func (c *TLSConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("cert_file and key_file are required when TLS is enabled")
+	}
+	if c.SelfSigned && len(c.Hosts) == 0 {
+		return fmt.Errorf("hosts must not be empty when self_signed is enabled")
+	}
+	if c.ReloadCheckSec < 0 {
+		return fmt.Errorf("reload_check_sec must not be negative")
+	}
+	return nil
+}
+
+// certReloader serves a certificate from a pair of files, and reloads it when either file changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// newCertReloader loads the certificate, generating a self-signed one first if enabled and the files are missing.
+func newCertReloader(config TLSConfig, logger *slog.Logger) (*certReloader, error) {
+	if config.SelfSigned {
+		if err := ensureSelfSigned(config.CertFile, config.KeyFile, config.Hosts, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &certReloader{
+		certFile: config.CertFile,
+		keyFile:  config.KeyFile,
+		interval: time.Duration(config.ReloadCheckSec) * time.Second,
+		logger:   logger,
+	}
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.load(modTime); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// TLSConfig returns a tls.Config that serves the reloader's certificate.
+func (c *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// GetCertificate returns the current certificate, reloading it first if the files have changed since it was loaded.
+// If the new files can't be loaded (e.g. only one of them has been replaced so far), the old certificate is kept.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) >= c.interval {
+		c.checked = time.Now()
+		modTime, err := c.latestModTime()
+		if err != nil {
+			c.logger.Warn("Failed to check TLS certificate files", "error", err)
+		} else if modTime.After(c.modTime) {
+			if err = c.load(modTime); err != nil {
+				c.logger.Warn("Failed to reload TLS certificate, keeping the old one", "error", err)
+			} else {
+				c.logger.Info("Reloaded TLS certificate", "cert_file", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// load reads the certificate and key. The caller must hold mu, unless the reloader isn't in use yet.
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the modification time of whichever file changed last.
+func (c *certReloader) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat certificate file: %w", err)
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat key file: %w", err)
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+// ensureSelfSigned writes a new self-signed certificate and key for the given hosts, unless the certificate file
+// already exists.
+func ensureSelfSigned(certFile, keyFile string, hosts []string, logger *slog.Logger) error {
+	if _, err := os.Stat(certFile); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to stat certificate file: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate TLS key: %w", err)
+	}
+	serial, err := crand.Int(crand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate serial: %w", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(crand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal TLS key: %w", err)
+	}
+
+	for _, file := range []string{certFile, keyFile} {
+		if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", file, err)
+		}
+	}
+	// The key goes first, so a certificate file never exists without its key.
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write TLS key: %w", err)
+	}
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+
+	logger.Info("Generated self-signed TLS certificate", "cert_file", certFile, "hosts", hosts)
+	return nil
+}
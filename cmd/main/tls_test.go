@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// leafHost returns the first DNS name of the certificate the reloader currently serves.
+func leafHost(t *testing.T, c *certReloader) string {
+	t.Helper()
+	cert, err := c.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	if len(leaf.DNSNames) == 0 {
+		return ""
+	}
+	return leaf.DNSNames[0]
+}
+
+func TestCertReloader_GetCertificate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	config := TLSConfig{
+		API:        true,
+		CertFile:   filepath.Join(dir, "tls", "cert.pem"),
+		KeyFile:    filepath.Join(dir, "tls", "key.pem"),
+		SelfSigned: true,
+		Hosts:      []string{"first.example.com", "127.0.0.1"},
+	}
+
+	c, err := newCertReloader(config, logger)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	if got := leafHost(t, c); got != "first.example.com" {
+		t.Fatalf("initial certificate: got host %q, want %q", got, "first.example.com")
+	}
+
+	// An existing pair is kept, not regenerated.
+	if err = ensureSelfSigned(config.CertFile, config.KeyFile, []string{"other.example.com"}, logger); err != nil {
+		t.Fatalf("ensureSelfSigned: %v", err)
+	}
+	if got := leafHost(t, c); got != "first.example.com" {
+		t.Errorf("after ensureSelfSigned on existing files: got host %q, want %q", got, "first.example.com")
+	}
+
+	// Replace the pair, and move the modification time forward in case the filesystem's clock is coarse.
+	for _, file := range []string{config.CertFile, config.KeyFile} {
+		if err = os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ensureSelfSigned(config.CertFile, config.KeyFile, []string{"second.example.com"}, logger); err != nil {
+		t.Fatalf("ensureSelfSigned: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{config.CertFile, config.KeyFile} {
+		if err = os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := leafHost(t, c); got != "second.example.com" {
+		t.Errorf("after rewriting the files: got host %q, want %q", got, "second.example.com")
+	}
+
+	// A broken key keeps the old certificate in use.
+	if err = os.WriteFile(config.KeyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	if err = os.Chtimes(config.KeyFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := leafHost(t, c); got != "second.example.com" {
+		t.Errorf("after breaking the key: got host %q, want %q", got, "second.example.com")
+	}
+}
@@ -142,6 +142,7 @@ document.addEventListener("DOMContentLoaded", () => {
             "Server Control": ["server:config", "server:control"],
             "Statistics": ["stats:read"],
             "Whitelists": ["whitelist:read", "whitelist:write"],
+            "Blocklists": ["blocklist:read", "blocklist:write"],
             "Templates": ["templates:read", "templates:write"],
             "Markov Models": ["markov:read", "markov:write"],
         };
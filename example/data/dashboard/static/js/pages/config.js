@@ -20,6 +20,10 @@ export function renderConfigPage() {
     buildSimpleConfigEditor(config.threat_config, 'threat_config', document.getElementById('threat_config-editor'));
 }
 
+// Keys holding lists of objects, which the simple editor can't represent. They are left untouched
+// by the simple editor and have to be edited in the raw JSON view.
+const rawOnlyKeys = new Set(['routing_rules', 'templates', 'stages', 'rules', 'bots', 'tcp_tarpits']);
+
 function buildSimpleConfigEditor(obj, prefix, container, level = 0) {
     if (level === 0) container.innerHTML = '';
     for (const key in obj) {
@@ -28,6 +32,16 @@ function buildSimpleConfigEditor(obj, prefix, container, level = 0) {
         const field = document.createElement('div');
         field.className = 'config-field';
 
+        if (rawOnlyKeys.has(key)) {
+            field.innerHTML = `<label class="config-field-label">${key}</label>`;
+            const note = document.createElement('p');
+            note.className = 'config-field-note';
+            note.textContent = `${Array.isArray(value) ? value.length : 0} entries. Edit these in the raw JSON view.`;
+            field.appendChild(note);
+            container.appendChild(field);
+            continue;
+        }
+
         // Special handling for "headers" to treat it as a Map (dynamic key-value pairs)
         if (key === 'headers' && typeof value === 'object' && value !== null && !Array.isArray(value)) {
             field.innerHTML = `<label class="config-field-label">${key}</label>`;
@@ -1,6 +1,6 @@
 import { appState } from '../state.js';
 import { apiRequest } from '../api.js';
-import { formatCompactNumber, showToast } from '../utils.js';
+import { escapeHTML, formatBytes, formatCompactNumber, showToast } from '../utils.js';
 
 export async function loadStats(button = null) {
     const ipTbody = document.querySelector('#ips-table tbody');
@@ -9,13 +9,17 @@ export async function loadStats(button = null) {
     if (!agentTbody.innerHTML) agentTbody.innerHTML = '<tr><td colspan="4"><div class="spinner"></div></td></tr>';
 
     try {
-        const [summary, ips, agents, version] = await Promise.all([
+        const [summary, ips, agents, subnets, asns, connections, families, version] = await Promise.all([
             apiRequest('/api/stats/summary', {}, button),
             apiRequest('/api/stats/top_ips'),
             apiRequest('/api/stats/top_user_agents'),
+            apiRequest('/api/stats/top_subnets'),
+            apiRequest('/api/stats/top_asns'),
+            apiRequest('/api/stats/connections'),
+            apiRequest('/api/stats/crawler_families'),
             apiRequest('/api/server/version')
         ]);
-        appState.dataCache.stats = {summary, ips: ips || [], agents: agents || []};
+        appState.dataCache.stats = {summary, ips: ips || [], agents: agents || [], subnets: subnets || [], asns: asns || [], connections, families: families || []};
         appState.dataCache.version = version;
         renderStatsPage();
     } catch (error) {
@@ -49,12 +53,41 @@ export function renderStatsPage() {
         <li><span class="label">Version</span><span class="value">${version.version}</span></li>
         <li><span class="label">Commit</span><span class="value">${version.commit.substring(0, 7)}</span></li>
         <li><span class="label">Build Date</span><span class="value">${new Date(version.build_date).toLocaleString()}</span></li>
+        <li><span class="label">Bytes Generated</span><span class="value">${formatBytes(stats.summary.bytes_before_compression)}</span></li>
+        <li><span class="label">Bytes Sent</span><span class="value">${formatBytes(stats.summary.bytes_after_compression)}</span></li>
+        <li><span class="label">Status Codes</span><span class="value">${formatStatusCodes(stats.summary.status_codes)}</span></li>
+        <li><span class="label">Held Connections</span><span class="value">${(stats.connections.held || 0).toLocaleString()}</span></li>
+        <li><span class="label">Crawler Families</span><span class="value">${formatCrawlerFamilies(stats.families)}</span></li>
+        <li><span class="label">Top Subnets</span><span class="value">${formatSubnets(stats.subnets)}</span></li>
+        <li><span class="label">Top ASNs</span><span class="value">${formatASNs(stats.asns)}</span></li>
+        <li><span class="label">Over Limit</span><span class="value">${(stats.connections.over_limit_served || 0).toLocaleString()} served, ${(stats.connections.over_limit_rejected || 0).toLocaleString()} rejected</span></li>
     `;
 
     renderStatsTable('ips-table', stats.ips, appState.uiState.ipsTable);
     renderStatsTable('agents-table', stats.agents, appState.uiState.agentsTable);
 }
 
+function formatStatusCodes(statusCodes) {
+    const entries = Object.entries(statusCodes || {}).sort(([a], [b]) => a - b);
+    if (entries.length === 0) return 'None';
+    return entries.map(([code, count]) => `${code}: ${formatCompactNumber(count)}`).join(', ');
+}
+
+function formatCrawlerFamilies(families) {
+    if (families.length === 0) return 'None';
+    return families.slice(0, 5).map(f => `${escapeHTML(f.name || f.family)}: ${formatCompactNumber(f.total_hits)}`).join(', ');
+}
+
+function formatSubnets(subnets) {
+    if (subnets.length === 0) return 'None';
+    return subnets.slice(0, 5).map(s => `${escapeHTML(s.subnet)}: ${formatCompactNumber(s.total_hits)}`).join(', ');
+}
+
+function formatASNs(asns) {
+    if (asns.length === 0) return 'None';
+    return asns.slice(0, 5).map(a => `AS${a.asn}${a.organization ? ` (${escapeHTML(a.organization)})` : ''}: ${formatCompactNumber(a.total_hits)}`).join(', ');
+}
+
 function renderStatsTable(tableId, data, state) {
     const tbody = document.querySelector(`#${tableId} tbody`);
     if (!Array.isArray(data) || data.length === 0) {
@@ -2,6 +2,8 @@ import { appState } from '../state.js';
 import { apiRequest } from '../api.js';
 import { debounce, showToast } from '../utils.js';
 
+const templateNamePattern = /\.(tmpl|part)\.(html|json|xml|rss|atom|csv|txt)$/;
+
 export async function loadTemplates(button = null) {
     const selector = document.getElementById('templateSelector');
     selector.innerHTML = '<option>Loading...</option>';
@@ -52,7 +54,7 @@ async function previewTemplate(name, threat, content = null, button = null) {
         let endpoint = `/api/templates/preview?name=${name}&threat=${threat}`;
         let options = {};
         if (content !== null) {
-            endpoint = `/api/templates/test?threat=${threat}`;
+            endpoint = `/api/templates/test?name=${name}&threat=${threat}`;
             options = {method: 'POST', body: content, headers: {'Content-Type': 'text/plain'}};
         }
         const response = await apiRequest(endpoint, options, button);
@@ -79,7 +81,8 @@ async function handleCreateTemplate(e) {
         return;
     }
 
-    const fullName = baseName.replace(/\.tmpl\.html$|\.part\.html$/, '') + extension;
+    // A name that already ends in a template extension (e.g. "feed.tmpl.rss") is kept as is.
+    const fullName = templateNamePattern.test(baseName) ? baseName : baseName + extension;
 
     try {
         await apiRequest(`/api/templates/${fullName}`, {
@@ -178,6 +181,9 @@ export function setupTemplatesEventListeners() {
             return;
         }
         const threat = document.getElementById('previewThreat').value;
-        previewTemplate('test.tmpl.html', threat, content, e.currentTarget);
+        const baseName = document.getElementById('newTemplateNameInput').value.trim();
+        const extension = document.querySelector('input[name="template-extension"]:checked').value;
+        const name = templateNamePattern.test(baseName) ? baseName : 'test' + extension;
+        previewTemplate(name, threat, content, e.currentTarget);
     });
 }
@@ -1,29 +1,39 @@
 import { appState } from '../state.js';
 import { apiRequest } from '../api.js';
-import { showToast } from '../utils.js';
+import { escapeHTML, showToast } from '../utils.js';
+
+// Whitelist types by API path, with their singular and plural labels.
+const whitelistTypes = {
+    ip: ['IP', 'IPs'],
+    cidr: ['CIDR range', 'CIDR ranges'],
+    useragent: ['User Agent', 'User Agents'],
+    useragent_glob: ['UA glob', 'UA globs'],
+    useragent_regex: ['UA regex', 'UA regexes'],
+};
 
 export async function loadWhitelist(button = null) {
-    const ipList = document.getElementById('ip-whitelist-list');
-    const uaList = document.getElementById('useragent-whitelist-list');
-    ipList.innerHTML = `<div class="spinner"></div>`;
-    uaList.innerHTML = `<div class="spinner"></div>`;
+    const types = Object.keys(whitelistTypes);
+    types.forEach(type => {
+        document.getElementById(`${type}-whitelist-list`).innerHTML = `<div class="spinner"></div>`;
+    });
     try {
-        const [ips, useragents] = await Promise.all([
-            apiRequest('/api/whitelist/ip', {}, button),
-            apiRequest('/api/whitelist/useragent')
-        ]);
-        appState.dataCache.whitelist.ip = ips || [];
-        appState.dataCache.whitelist.useragent = useragents || [];
+        const lists = await Promise.all(types.map((type, i) =>
+            apiRequest(`/api/whitelist/${type}`, {}, i === 0 ? button : null)
+        ));
+        types.forEach((type, i) => {
+            appState.dataCache.whitelist[type] = lists[i] || [];
+        });
         renderWhitelistPage();
     } catch (error) {
-        ipList.innerHTML = `<li class="list-empty-state">Failed to load IP whitelist.</li>`;
-        uaList.innerHTML = `<li class="list-empty-state">Failed to load User Agent whitelist.</li>`;
+        types.forEach(type => {
+            document.getElementById(`${type}-whitelist-list`).innerHTML =
+                `<li class="list-empty-state">Failed to load ${whitelistTypes[type][0]} whitelist.</li>`;
+        });
     }
 }
 
 export function renderWhitelistPage() {
-    renderWhitelistList('ip');
-    renderWhitelistList('useragent');
+    Object.keys(whitelistTypes).forEach(renderWhitelistList);
 }
 
 function renderWhitelistList(type) {
@@ -36,14 +46,14 @@ function renderWhitelistList(type) {
     }
 
     if (data.length === 0) {
-        listEl.innerHTML = `<li class="list-empty-state">No ${type === 'ip' ? 'IPs' : 'User Agents'} whitelisted.</li>`;
+        listEl.innerHTML = `<li class="list-empty-state">No ${whitelistTypes[type][1]} whitelisted.</li>`;
         return;
     }
 
     listEl.innerHTML = data.map(item => `
-        <li class="whitelist-item" data-value="${item}">
-            <span class="value">${item}</span>
-            <button type="button" class="remove-item-btn" data-type="${type}" data-value="${item}" title="Remove">
+        <li class="whitelist-item" data-value="${escapeHTML(item)}">
+            <span class="value">${escapeHTML(item)}</span>
+            <button type="button" class="remove-item-btn" data-type="${type}" data-value="${escapeHTML(item)}" title="Remove">
                 <svg class="btn-icon" xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke-width="1.5" stroke="currentColor"><path stroke-linecap="round" stroke-linejoin="round" d="M6 18L18 6M6 6l12 12" /></svg>
             </button>
         </li>
@@ -53,7 +63,7 @@ function renderWhitelistList(type) {
 async function handleAddWhitelist(e) {
     e.preventDefault();
     const form = e.currentTarget;
-    const type = form.id.includes('ip') ? 'ip' : 'useragent';
+    const type = form.dataset.type;
     const input = form.querySelector('input');
     const value = input.value.trim();
     if (!value) {
@@ -66,7 +76,7 @@ async function handleAddWhitelist(e) {
             method: 'POST',
             body: JSON.stringify({value})
         }, form.querySelector('button[type="submit"]'));
-        showToast(`${type === 'ip' ? 'IP' : 'User Agent'} added.`);
+        showToast(`${whitelistTypes[type][0]} added.`);
         input.value = '';
         await loadWhitelist(); // Refresh list
     } catch (error) {
@@ -80,7 +90,7 @@ async function handleRemoveWhitelist(type, value, button) {
             method: 'DELETE',
             body: JSON.stringify({value})
         }, button);
-        showToast(`${type === 'ip' ? 'IP' : 'User Agent'} removed.`);
+        showToast(`${whitelistTypes[type][0]} removed.`);
         await loadWhitelist(); // Refresh list
     } catch (error) {
         // Error toast is handled by apiRequest
@@ -100,22 +110,16 @@ async function handleWhitelistImport(e) {
             return;
         }
 
-        let successCount = 0;
-        let errorCount = 0;
-
-        for (const line of lines) {
-            try {
-                await apiRequest(`/api/whitelist/${type}`, {
-                    method: 'POST',
-                    body: JSON.stringify({value: line})
-                });
-                successCount++;
-            } catch (error) {
-                errorCount++;
-            }
+        try {
+            const result = await apiRequest(`/api/whitelist/${type}/import`, {
+                method: 'POST',
+                body: JSON.stringify({values: lines})
+            });
+            const failed = result.invalid.length;
+            showToast(`Import complete. Added: ${result.added}, Already listed: ${result.skipped}, Failed: ${failed}.`, failed > 0 ? 'error' : 'success');
+        } catch (error) {
+            // Error toast is handled by apiRequest
         }
-
-        showToast(`Import complete. Added: ${successCount}, Failed: ${errorCount}.`, errorCount > 0 ? 'error' : 'success');
         await loadWhitelist(); // Refresh the list
     };
     reader.readAsText(file);
@@ -136,8 +140,9 @@ export function setupWhitelistEventListeners() {
         }
     });
 
-    document.getElementById('add-ip-whitelist-form').addEventListener('submit', handleAddWhitelist);
-    document.getElementById('add-useragent-whitelist-form').addEventListener('submit', handleAddWhitelist);
+    document.querySelectorAll('.add-whitelist-form').forEach(form => {
+        form.addEventListener('submit', handleAddWhitelist);
+    });
 
     document.getElementById('whitelist-content').addEventListener('click', e => {
         const removeBtn = e.target.closest('.remove-item-btn');
@@ -10,7 +10,7 @@ export const appState = {
         templates: null,
         models: null,
         keys: null,
-        whitelist: {ip: null, useragent: null},
+        whitelist: {ip: null, cidr: null, useragent: null, useragent_glob: null, useragent_regex: null},
     },
     uiState: {
         ipsTable: {showAll: false, sort: {key: 'last_seen', dir: 'desc'}, filter: ''},
@@ -28,6 +28,7 @@ type generateOptions struct {
 	// New optional parameters
 	temperature float64
 	topK        int
+	rng         *rand.Rand
 }
 
 // GenerateOption is a function that configures generation parameters. It's used
@@ -61,6 +62,13 @@ func WithTopK(k int) GenerateOption {
 	return func(o *generateOptions) { o.topK = k }
 }
 
+// WithRand sets the random source used for token selection. By default, the global
+// math/rand/v2 source is used. Passing a seeded source makes generation reproducible
+// for as long as the model is unchanged.
+func WithRand(r *rand.Rand) GenerateOption {
+	return func(o *generateOptions) { o.rng = r }
+}
+
 // Generate creates a new Markov chain, builds it into a single string, and returns it.
 // It starts from a default initial state of Start-Of-Chain (SOC) tokens.
 // Generation can be customized with GenerateOption functions.
@@ -291,7 +299,7 @@ func chooseNextToken(choices []ChainToken, totalFreq int, options *generateOptio
 			}
 		}
 	} else if options.temperature == 1.0 { // Standard weighted random
-		randChoice := rand.IntN(totalFreq)
+		randChoice := options.intN(totalFreq)
 		for _, choice := range choices {
 			randChoice -= choice.Freq
 			if randChoice < 0 {
@@ -316,7 +324,7 @@ func chooseNextToken(choices []ChainToken, totalFreq int, options *generateOptio
 			weights[i] = w
 			totalWeight += w
 		}
-		randChoice := rand.Float64() * totalWeight
+		randChoice := options.float64() * totalWeight
 		for i, choice := range choices {
 			randChoice -= weights[i]
 			if randChoice < 0 {
@@ -327,3 +335,19 @@ func chooseNextToken(choices []ChainToken, totalFreq int, options *generateOptio
 	}
 	return nextToken
 }
+
+// intN returns a random int in [0, n) from the configured random source.
+func (o *generateOptions) intN(n int) int {
+	if o.rng != nil {
+		return o.rng.IntN(n)
+	}
+	return rand.IntN(n)
+}
+
+// float64 returns a random float64 in [0.0, 1.0) from the configured random source.
+func (o *generateOptions) float64() float64 {
+	if o.rng != nil {
+		return o.rng.Float64()
+	}
+	return rand.Float64()
+}
@@ -2,6 +2,7 @@ package markov
 
 import (
 	"context"
+	"math/rand/v2"
 	"strings"
 	"testing"
 )
@@ -23,6 +24,27 @@ func TestGenerate(t *testing.T) {
 	}
 }
 
+func TestGenerateWithRand(t *testing.T) {
+	ctx, g, modelInfo := setupTestDBWithTraining(t)
+
+	// Two sources with the same seed must produce the same chains.
+	r1 := rand.New(rand.NewPCG(1, 2))
+	r2 := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 10; i++ {
+		out1, err := g.Generate(ctx, modelInfo, WithMaxLength(10), WithRand(r1))
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		out2, err := g.Generate(ctx, modelInfo, WithMaxLength(10), WithRand(r2))
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if out1 != out2 {
+			t.Errorf("Generate() with equally seeded sources differed: %q != %q", out1, out2)
+		}
+	}
+}
+
 func TestGenerateFrom(t *testing.T) {
 	ctx, g, modelInfo := setupTestDBWithTraining(t)
 
@@ -0,0 +1,12 @@
+/*
+Package tarpit provides the request routing and drip-feeding parts of the Sarracenia tarpit as an embeddable
+http.Handler middleware.
+
+A Middleware asks a Classifier about every request. Requests that should be tarpitted go to the tarpit handler,
+and everything else goes on to the wrapped handler, so the tarpit can be put in front of an existing service.
+Classification, statistics, and the tarpit handler are all pluggable: the Sarracenia server uses its own threat
+scoring and templates, and any http.Handler can use a DripWriter to send its response out slowly.
+
+For a complete usage example, see the README.md file.
+*/
+package tarpit
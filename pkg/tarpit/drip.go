@@ -0,0 +1,158 @@
+package tarpit
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// DripConfig holds the delays and chunk sizes for drip-feeding a response.
+type DripConfig struct {
+	// Range of the delay before the first byte is sent.
+	InitialDelayMin time.Duration
+	InitialDelayMax time.Duration
+
+	// Range of the delay between chunks.
+	DelayMin time.Duration
+	DelayMax time.Duration
+
+	// Range of the chunk size, in bytes.
+	ChunkBytesMin int
+	ChunkBytesMax int
+}
+
+// InitialDelay returns a random delay from the initial delay range.
+func (c DripConfig) InitialDelay() time.Duration {
+	return randRange(c.InitialDelayMin, c.InitialDelayMax)
+}
+
+// Delay returns a random delay from the range between chunks.
+func (c DripConfig) Delay() time.Duration {
+	return randRange(c.DelayMin, c.DelayMax)
+}
+
+// DripWriter is an io.Writer that forwards everything written to it to an http.ResponseWriter
+// in small, throttled chunks. It only ever buffers a single chunk, so a template can be executed
+// directly into it and drip-feeding starts as soon as the first chunk has been rendered, instead
+// of after the whole page is built.
+type DripWriter struct {
+	ctx     context.Context
+	w       http.ResponseWriter
+	flusher http.Flusher
+	config  DripConfig
+
+	// statusCode is sent along with the first chunk, if set.
+	statusCode int
+
+	buf       []byte
+	chunkSize int
+	started   bool
+}
+
+// NewDripWriter creates a DripWriter for the given response. The context should be the request
+// context, so that a client disconnect aborts any pending delay.
+func NewDripWriter(ctx context.Context, w http.ResponseWriter, flusher http.Flusher, config DripConfig) *DripWriter {
+	d := &DripWriter{
+		ctx:     ctx,
+		w:       w,
+		flusher: flusher,
+		config:  config,
+	}
+	d.nextChunkSize()
+	return d
+}
+
+// SetStatusCode sets the status code sent along with the first chunk. Without it, the first chunk
+// is sent with whatever status the ResponseWriter defaults to.
+func (d *DripWriter) SetStatusCode(statusCode int) {
+	d.statusCode = statusCode
+}
+
+// Write buffers p and sends out every full chunk, pausing between chunks.
+func (d *DripWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), d.chunkSize-len(d.buf))
+		d.buf = append(d.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(d.buf) >= d.chunkSize {
+			if err := d.sendChunk(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close sends whatever is left in the buffer. It does not close the underlying writer.
+func (d *DripWriter) Close() error {
+	if len(d.buf) == 0 {
+		return nil
+	}
+	return d.sendChunk()
+}
+
+// Started reports whether any bytes have been sent to the client yet. Until then, the response
+// status and headers can still be changed.
+func (d *DripWriter) Started() bool {
+	return d.started
+}
+
+// sendChunk waits out the drip-feed delay (if this isn't the first chunk), then writes and
+// flushes the buffered chunk.
+func (d *DripWriter) sendChunk() error {
+	if d.started {
+		if err := SleepContext(d.ctx, d.config.Delay()); err != nil {
+			return err
+		}
+	}
+	if !d.started && d.statusCode != 0 {
+		d.w.WriteHeader(d.statusCode)
+	}
+	d.started = true
+
+	if _, err := d.w.Write(d.buf); err != nil {
+		return err
+	}
+	d.flusher.Flush()
+
+	d.buf = d.buf[:0]
+	d.nextChunkSize()
+	return nil
+}
+
+// nextChunkSize picks the size of the next chunk from the configured byte range.
+func (d *DripWriter) nextChunkSize() {
+	d.chunkSize = max(randRange(d.config.ChunkBytesMin, d.config.ChunkBytesMax), 1)
+	if cap(d.buf) < d.chunkSize {
+		d.buf = make([]byte, 0, d.chunkSize)
+	}
+}
+
+// SleepContext sleeps for the given duration, returning early with the context's error if it
+// is cancelled first.
+func SleepContext(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// randRange returns a random value in [lo, hi), or lo if the range is empty. Negative values are treated as 0.
+func randRange[T int | time.Duration](lo, hi T) T {
+	lo = max(lo, 0)
+	if hi <= lo {
+		return lo
+	}
+	return rand.N(hi-lo) + lo
+}
@@ -0,0 +1,117 @@
+package tarpit
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// Decision is the outcome of classifying a request.
+type Decision struct {
+	// Tarpit sends the request to the tarpit handler instead of the wrapped handler.
+	Tarpit bool
+
+	// Reason is a short description of why, for logging.
+	Reason string
+
+	// ThreatLevel is the request's threat score, if the classifier computes one.
+	ThreatLevel int
+
+	// ThreatStage is the stage ThreatLevel falls in, if the classifier uses stages.
+	ThreatStage int
+
+	// Value carries classifier-specific data on to the handlers, e.g. the metrics the decision was based on.
+	Value any
+}
+
+// Classifier decides whether a request should be tarpitted.
+type Classifier interface {
+	Classify(r *http.Request) Decision
+}
+
+// ClassifierFunc adapts a function to a Classifier.
+type ClassifierFunc func(r *http.Request) Decision
+
+// Classify calls f(r).
+func (f ClassifierFunc) Classify(r *http.Request) Decision {
+	return f(r)
+}
+
+// Recorder receives every decision the middleware makes, e.g. to keep statistics.
+type Recorder interface {
+	RecordDecision(r *http.Request, decision Decision)
+}
+
+type decisionContextKey struct{}
+
+// NewContext returns a copy of ctx carrying the decision.
+func NewContext(ctx context.Context, decision Decision) context.Context {
+	return context.WithValue(ctx, decisionContextKey{}, decision)
+}
+
+// FromContext returns the decision the middleware made for a request, if any.
+func FromContext(ctx context.Context) (Decision, bool) {
+	decision, ok := ctx.Value(decisionContextKey{}).(Decision)
+	return decision, ok
+}
+
+// Middleware routes requests between the tarpit and the handler it wraps.
+type Middleware struct {
+	classifier Classifier
+	tarpit     http.Handler
+	recorder   Recorder
+	logger     *slog.Logger
+}
+
+// Option configures a Middleware.
+type Option func(*Middleware)
+
+// WithRecorder sets a Recorder to receive every decision.
+func WithRecorder(recorder Recorder) Option {
+	return func(m *Middleware) {
+		m.recorder = recorder
+	}
+}
+
+// WithLogger sets the logger for decisions. By default, nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *Middleware) {
+		m.logger = logger
+	}
+}
+
+// New creates a Middleware that sends the requests the classifier picks to the tarpit handler.
+func New(classifier Classifier, tarpit http.Handler, opts ...Option) *Middleware {
+	m := &Middleware{
+		classifier: classifier,
+		tarpit:     tarpit,
+		logger:     slog.New(slog.DiscardHandler),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Wrap returns a handler that classifies each request, and passes it on to next unless it should be tarpitted.
+// Both handlers can get the decision with FromContext.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision := m.classifier.Classify(r)
+		if m.recorder != nil {
+			m.recorder.RecordDecision(r, decision)
+		}
+		r = r.WithContext(NewContext(r.Context(), decision))
+
+		if !decision.Tarpit {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m.logger.Debug("Tarpitting request",
+			"reason", decision.Reason,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"Threat_level", decision.ThreatLevel)
+		m.tarpit.ServeHTTP(w, r)
+	})
+}
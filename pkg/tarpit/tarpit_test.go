@@ -0,0 +1,112 @@
+package tarpit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type decisionRecorder struct {
+	decisions []Decision
+}
+
+func (d *decisionRecorder) RecordDecision(_ *http.Request, decision Decision) {
+	d.decisions = append(d.decisions, decision)
+}
+
+func TestMiddleware_Wrap(t *testing.T) {
+	classifier := ClassifierFunc(func(r *http.Request) Decision {
+		if strings.HasPrefix(r.URL.Path, "/bait") {
+			return Decision{Tarpit: true, Reason: "bait", ThreatLevel: 42}
+		}
+		return Decision{Reason: "clean"}
+	})
+
+	// Both handlers echo the decision they were given, so the test can check it was passed along.
+	echo := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision, ok := FromContext(r.Context())
+			if !ok {
+				t.Errorf("%s handler: no decision in context", name)
+			}
+			_, _ = io.WriteString(w, name+":"+decision.Reason)
+		})
+	}
+
+	recorder := &decisionRecorder{}
+	handler := New(classifier, echo("tarpit"), WithRecorder(recorder)).Wrap(echo("next"))
+
+	testCases := []struct {
+		path string
+		want string
+	}{
+		{"/", "next:clean"},
+		{"/bait/admin", "tarpit:bait"},
+		{"/about", "next:clean"},
+	}
+	for _, tc := range testCases {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if got := rec.Body.String(); got != tc.want {
+			t.Errorf("path %s: got %q, want %q", tc.path, got, tc.want)
+		}
+	}
+
+	if len(recorder.decisions) != len(testCases) {
+		t.Fatalf("recorded %d decisions, want %d", len(recorder.decisions), len(testCases))
+	}
+	if d := recorder.decisions[1]; !d.Tarpit || d.ThreatLevel != 42 {
+		t.Errorf("recorded decision %+v, want the bait decision", d)
+	}
+}
+
+func TestDripWriter(t *testing.T) {
+	config := DripConfig{ChunkBytesMin: 4, ChunkBytesMax: 5}
+
+	t.Run("StatusAndClose", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		dw := NewDripWriter(context.Background(), rec, rec, config)
+		dw.SetStatusCode(http.StatusTeapot)
+
+		if _, err := dw.Write([]byte("ab")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if dw.Started() {
+			t.Error("Started before a full chunk was written")
+		}
+		if err := dw.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if !dw.Started() {
+			t.Error("not Started after Close sent the remaining bytes")
+		}
+		if rec.Code != http.StatusTeapot {
+			t.Errorf("got status %d, want %d", rec.Code, http.StatusTeapot)
+		}
+		if rec.Body.String() != "ab" {
+			t.Errorf("got body %q, want %q", rec.Body.String(), "ab")
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		config := config
+		config.DelayMin, config.DelayMax = time.Hour, 2*time.Hour
+
+		rec := httptest.NewRecorder()
+		dw := NewDripWriter(ctx, rec, rec, config)
+		_, err := dw.Write([]byte("0123456789"))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want context.Canceled", err)
+		}
+		if rec.Body.Len() != 4 {
+			t.Errorf("sent %d bytes, want only the first chunk", rec.Body.Len())
+		}
+	})
+}
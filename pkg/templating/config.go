@@ -54,6 +54,9 @@ type TemplateConfig struct {
 	// MaxTableCols sets the maximum number of columns for the randomComplexTable function.
 	MaxTableCols int `json:"max_table_cols"`
 
+	// MaxFeedItems sets the maximum number of entries generated by the feedItems function.
+	MaxFeedItems int `json:"max_feed_items"`
+
 	// MaxFormFields sets the maximum number of fields for the randomForm function.
 	MaxFormFields int `json:"max_form_fields"`
 
@@ -96,6 +99,7 @@ func DefaultConfig() *TemplateConfig {
 		MaxNestDivs:             50,
 		MaxTableRows:            100,
 		MaxTableCols:            50,
+		MaxFeedItems:            100,
 		MaxFormFields:           75,
 		MaxStyleRules:           200,
 		MaxCssVars:              100,
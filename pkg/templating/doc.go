@@ -4,7 +4,7 @@ It is designed for generating dynamic, complex, and plausible-looking web conten
 with minimal server overhead.
 
 It includes a rich library of custom template functions for generating
-everything from structured data (JSON, forms) and styled elements (CSS, SVGs) to
+everything from structured data (JSON, RSS and Atom feeds, CSV, forms) and styled elements (CSS, SVGs) to
 thematic text via an integrated, SQLite-backed Markov generator. The engine is
 fully configurable with safety limits to prevent abuse and supports hot-reloading
 of templates from the filesystem, enabling easy updates post-deployment.
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"html/template"
+	"iter"
 	"math/rand/v2"
 	"strconv"
 	"strings"
@@ -32,38 +33,38 @@ var emailDomains = []string{
 }
 
 // markovSentence generates a sentence from a named model.
-func (tm *TemplateManager) markovSentence(modelName string, maxLength int) (string, error) {
-	if !tm.config.MarkovEnabled { // fallback to random if markov is not enabled
-		return randomSentence(maxLength), nil
+func (r *renderer) markovSentence(modelName string, maxLength int) (string, error) {
+	if !r.tm.getConfig().MarkovEnabled { // fallback to random if markov is not enabled
+		return r.randomSentence(maxLength), nil
 	}
 
 	ctx := context.Background()
 
-	model, ok := tm.markovModels[modelName]
+	model, ok := r.tm.getModel(modelName)
 	if !ok {
-		tm.logger.Error("markovSentence: model not found", "model", modelName)
+		r.tm.logger.Error("markovSentence: model not found", "model", modelName)
 		return "", nil
 	}
 
-	sentence, err := tm.markovGen.Generate(ctx, model, markov.WithMaxLength(maxLength))
+	sentence, err := r.tm.markovGen.Generate(ctx, model, markov.WithMaxLength(maxLength), markov.WithRand(r.rng))
 	if err != nil {
-		tm.logger.Error("markovSentence: generation failed", "model", modelName, "error", err)
+		r.tm.logger.Error("markovSentence: generation failed", "model", modelName, "error", err)
 		return "", nil
 	}
 	return sentence, nil
 }
 
 // markovParagraphs generates N paragraphs of thematic text.
-func (tm *TemplateManager) markovParagraphs(modelName string, count, minSentences, maxSentences, minLength, maxLength int) (string, error) {
-	if !tm.config.MarkovEnabled { // fallback to random if markov is not enabled
-		return randomParagraphs(count, minSentences, maxSentences, minLength, maxLength), nil
+func (r *renderer) markovParagraphs(modelName string, count, minSentences, maxSentences, minLength, maxLength int) (string, error) {
+	if !r.tm.getConfig().MarkovEnabled { // fallback to random if markov is not enabled
+		return r.randomParagraphs(count, minSentences, maxSentences, minLength, maxLength), nil
 	}
 
 	var builder strings.Builder
 	for i := 0; i < count; i++ {
-		numSentences := rand.IntN(maxSentences-minSentences) + minSentences
+		numSentences := r.rng.IntN(maxSentences-minSentences) + minSentences
 		for j := 0; j < numSentences; j++ {
-			sentence, err := tm.markovSentence(modelName, rand.IntN(maxLength-minLength)+minLength)
+			sentence, err := r.markovSentence(modelName, r.rng.IntN(maxLength-minLength)+minLength)
 			if err != nil {
 				return "", err
 			}
@@ -77,23 +78,68 @@ func (tm *TemplateManager) markovParagraphs(modelName string, count, minSentence
 	return builder.String(), nil
 }
 
+// markovStream lazily generates up to maxLength tokens of thematic text from a named model.
+// It is meant to be ranged over (`{{range markovStream "model" 500}}{{.}}{{end}}`), so each
+// token is written out as soon as it is generated instead of building the whole text first.
+// If the render is aborted part way through, the underlying generation stream is cancelled.
+func (r *renderer) markovStream(modelName string, maxLength int) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if !r.tm.getConfig().MarkovEnabled { // fallback to random if markov is not enabled
+			for i := 0; i < maxLength; i++ {
+				word := r.randomWord()
+				if i > 0 {
+					word = " " + word
+				}
+				if !yield(word) {
+					return
+				}
+			}
+			return
+		}
+
+		model, ok := r.tm.getModel(modelName)
+		if !ok {
+			r.tm.logger.Error("markovStream: model not found", "model", modelName)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// The stream is generated on another goroutine while the template keeps running, so it gets
+		// its own source, derived from the render's so that the output stays reproducible.
+		streamRand := rand.New(rand.NewPCG(r.rng.Uint64(), r.rng.Uint64()))
+
+		stream, err := r.tm.markovGen.GenerateStream(ctx, model, markov.WithMaxLength(maxLength), markov.WithEarlyTermination(false), markov.WithRand(streamRand))
+		if err != nil {
+			r.tm.logger.Error("markovStream: generation failed", "model", modelName, "error", err)
+			return
+		}
+		for token := range stream {
+			if !yield(token.Text) {
+				return
+			}
+		}
+	}
+}
+
 // randomWord returns a single random word from the manager's loaded word list.
-func randomWord() string {
+func (r *renderer) randomWord() string {
 	if wordCount == 0 {
 		return ""
 	}
-	return wordList[rand.IntN(wordCount)]
+	return wordList[r.rng.IntN(wordCount)]
 }
 
 // randomSentence generates a nonsensical sentence from random words.
-func randomSentence(length int) string {
+func (r *renderer) randomSentence(length int) string {
 	if length <= 0 {
 		return ""
 	}
 	var builder strings.Builder
 	words := make([]string, length)
 	for i := 0; i < length; i++ {
-		words[i] = randomWord()
+		words[i] = r.randomWord()
 	}
 	// Capitalize the first letter of the first word
 	if len(words[0]) > 0 {
@@ -105,15 +151,15 @@ func randomSentence(length int) string {
 }
 
 // randomParagraphs generates N paragraphs of filler text.
-func randomParagraphs(count, minSentences, maxSentences, minLength, maxLength int) string {
+func (r *renderer) randomParagraphs(count, minSentences, maxSentences, minLength, maxLength int) string {
 	if count <= 0 {
 		return ""
 	}
 	var builder strings.Builder
 	for i := 0; i < count; i++ {
-		numSentences := rand.IntN(maxSentences-minSentences) + minSentences
+		numSentences := r.rng.IntN(maxSentences-minSentences) + minSentences
 		for j := 0; j < numSentences; j++ {
-			builder.WriteString(randomSentence(rand.IntN(maxLength-minLength) + minLength))
+			builder.WriteString(r.randomSentence(r.rng.IntN(maxLength-minLength) + minLength))
 			builder.WriteByte(' ')
 		}
 		if i < count-1 {
@@ -123,16 +169,16 @@ func randomParagraphs(count, minSentences, maxSentences, minLength, maxLength in
 	return builder.String()
 }
 
-func randomString(t string, length int) string {
+func (r *renderer) randomString(t string, length int) string {
 
 	var builder strings.Builder
 
 	switch t {
 	case "username":
-		word1 := wordList[rand.IntN(wordCount)]
-		word2 := wordList[rand.IntN(wordCount)]
-		num1 := rand.IntN(10)
-		num2 := rand.IntN(10)
+		word1 := wordList[r.rng.IntN(wordCount)]
+		word2 := wordList[r.rng.IntN(wordCount)]
+		num1 := r.rng.IntN(10)
+		num2 := r.rng.IntN(10)
 
 		// Pre-allocate: length of two words + 2 digits
 		builder.Grow(len(word1) + len(word2) + 2)
@@ -143,9 +189,9 @@ func randomString(t string, length int) string {
 		return builder.String()
 
 	case "email":
-		word1 := wordList[rand.IntN(wordCount)]
-		word2 := wordList[rand.IntN(wordCount)]
-		domain := emailDomains[rand.IntN(len(emailDomains))] // Pick a random domain
+		word1 := wordList[r.rng.IntN(wordCount)]
+		word2 := wordList[r.rng.IntN(wordCount)]
+		domain := emailDomains[r.rng.IntN(len(emailDomains))] // Pick a random domain
 
 		// Pre-allocate: word lengths + at-sign + domain length
 		builder.Grow(len(word1) + len(word2) + 1 + len(domain))
@@ -163,7 +209,7 @@ func randomString(t string, length int) string {
 				builder.WriteByte('-')
 			}
 			for j := 0; j < n; j++ {
-				builder.WriteByte(lowerHexChars[rand.IntN(len(lowerHexChars))])
+				builder.WriteByte(lowerHexChars[r.rng.IntN(len(lowerHexChars))])
 			}
 		}
 		return builder.String()
@@ -174,7 +220,7 @@ func randomString(t string, length int) string {
 		}
 		builder.Grow(length)
 		for i := 0; i < length; i++ {
-			builder.WriteByte(upperHexChars[rand.IntN(len(upperHexChars))])
+			builder.WriteByte(upperHexChars[r.rng.IntN(len(upperHexChars))])
 		}
 		return builder.String()
 
@@ -184,7 +230,7 @@ func randomString(t string, length int) string {
 		}
 		builder.Grow(length)
 		for i := 0; i < length; i++ {
-			builder.WriteByte(alphanumericChars[rand.IntN(len(alphanumericChars))])
+			builder.WriteByte(alphanumericChars[r.rng.IntN(len(alphanumericChars))])
 		}
 		return builder.String()
 
@@ -193,7 +239,7 @@ func randomString(t string, length int) string {
 	}
 }
 
-func randomDate(layout, start, end string) (string, error) {
+func (r *renderer) randomDate(layout, start, end string) (string, error) {
 	startTime, err := time.Parse(layout, start)
 	if err != nil {
 		return "", err
@@ -212,59 +258,59 @@ func randomDate(layout, start, end string) (string, error) {
 		return startTime.Format(layout), nil
 	}
 
-	sec := rand.Int64N(delta) + startTime.Unix()
+	sec := r.rng.Int64N(delta) + startTime.Unix()
 
 	return time.Unix(sec, 0).Format(layout), nil
 }
 
 // randomJSON generates a random, nested JSON object as a string.
-func (tm *TemplateManager) randomJSON(requestedDepth, maxElements, maxStringLength int) (template.HTML, error) {
+func (r *renderer) randomJSON(requestedDepth, maxElements, maxStringLength int) (template.HTML, error) {
 
-	depth := min(requestedDepth, tm.config.MaxJSONDepth)
+	depth := min(requestedDepth, r.tm.getConfig().MaxJSONDepth)
 
-	data := generateRandomJSONValue(depth, maxElements, maxStringLength)
+	data := r.generateRandomJSONValue(depth, maxElements, maxStringLength)
 
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		tm.logger.Error("failed to marshal random JSON", "error", err)
+		r.tm.logger.Error("failed to marshal random JSON", "error", err)
 		return "", err
 	}
 
 	return template.HTML(jsonData), nil
 }
 
-func generateRandomJSONValue(depth, maxElements, maxStringLength int) any {
+func (r *renderer) generateRandomJSONValue(depth, maxElements, maxStringLength int) any {
 	// When depth is zero, return a primitive value.
 	if depth <= 0 {
-		switch rand.IntN(4) {
+		switch r.rng.IntN(4) {
 		case 0: // string
-			return randomId("val", maxStringLength)
+			return r.randomId("val", maxStringLength)
 		case 1: // integer
-			return rand.IntN(10000)
+			return r.rng.IntN(10000)
 		case 2: // boolean
-			return rand.IntN(2) == 0
+			return r.rng.IntN(2) == 0
 		default: // float
-			return rand.Float64() * 1000
+			return r.rng.Float64() * 1000
 		}
 	}
 
 	// Recursive step: Create an object or an array.
-	if rand.IntN(2) == 0 {
+	if r.rng.IntN(2) == 0 {
 		// Create a JSON object (map).
 		obj := make(map[string]any)
-		numElements := rand.IntN(maxElements) + 1
+		numElements := r.rng.IntN(maxElements) + 1
 		for i := 0; i < numElements; i++ {
-			key := randomId("key", 8)
-			obj[key] = generateRandomJSONValue(depth-1, maxElements, maxStringLength)
+			key := r.randomId("key", 8)
+			obj[key] = r.generateRandomJSONValue(depth-1, maxElements, maxStringLength)
 		}
 		return obj
 	}
 
 	// Create a JSON array (slice).
 	arr := make([]any, 0)
-	numElements := rand.IntN(maxElements) + 1
+	numElements := r.rng.IntN(maxElements) + 1
 	for i := 0; i < numElements; i++ {
-		arr = append(arr, generateRandomJSONValue(depth-1, maxElements, maxStringLength))
+		arr = append(arr, r.generateRandomJSONValue(depth-1, maxElements, maxStringLength))
 	}
 	return arr
 }
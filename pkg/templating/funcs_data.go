@@ -0,0 +1,140 @@
+package templating
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeedItem is a single generated entry of a news feed. It is used by the feedItems
+// template function to return a slice that RSS and Atom templates can range over.
+type FeedItem struct {
+	Title       string
+	Link        string
+	Description string
+	Author      string
+	GUID        string
+	Published   time.Time
+}
+
+// PubDate returns the publication date in the RFC 822 format used by RSS.
+func (f FeedItem) PubDate() string {
+	return f.Published.Format(time.RFC1123Z)
+}
+
+// Updated returns the publication date in the RFC 3339 format used by Atom.
+func (f FeedItem) Updated() string {
+	return f.Published.Format(time.RFC3339)
+}
+
+// feedItems generates count feed entries, newest first. Titles and descriptions come from the
+// named Markov model, or from the word list if modelName is empty.
+func (r *renderer) feedItems(modelName string, count int) ([]FeedItem, error) {
+	count = min(count, r.tm.getConfig().MaxFeedItems)
+	if count <= 0 {
+		return nil, nil
+	}
+
+	items := make([]FeedItem, 0, count)
+	published := time.Now()
+	for i := 0; i < count; i++ {
+		published = published.Add(-time.Duration(r.rng.IntN(48*60)+10) * time.Minute)
+
+		var title, description string
+		if modelName == "" {
+			title = r.randomSentence(r.rng.IntN(6) + 4)
+			description = r.randomParagraphs(1, 2, 5, 8, 20)
+		} else {
+			var err error
+			if title, err = r.markovSentence(modelName, 12); err != nil {
+				return nil, err
+			}
+			if description, err = r.markovParagraphs(modelName, 1, 2, 5, 8, 20); err != nil {
+				return nil, err
+			}
+		}
+
+		items = append(items, FeedItem{
+			Title:       strings.TrimSpace(title),
+			Link:        r.randomLink(),
+			Description: strings.TrimSpace(description),
+			Author:      r.randomString("email", 0),
+			GUID:        r.randomString("uuid", 0),
+			Published:   published,
+		})
+	}
+	return items, nil
+}
+
+// randomCSV generates a CSV document with a header row and the given number of data rows.
+func (r *renderer) randomCSV(rows, cols int) (string, error) {
+	config := r.tm.getConfig()
+	rows = min(rows, config.MaxTableRows)
+	cols = min(cols, config.MaxTableCols)
+	if rows <= 0 || cols <= 0 {
+		return "", nil
+	}
+
+	// Each column gets a fixed type, so the file looks like a real export.
+	columnTypes := make([]int, cols)
+	header := make([]string, cols)
+	for c := 0; c < cols; c++ {
+		columnTypes[c] = r.rng.IntN(5)
+		header[c] = r.randomWord()
+	}
+
+	var builder strings.Builder
+	writer := csv.NewWriter(&builder)
+	if err := writer.Write(header); err != nil {
+		return "", err
+	}
+	record := make([]string, cols)
+	for i := 0; i < rows; i++ {
+		for c, columnType := range columnTypes {
+			switch columnType {
+			case 0:
+				record[c] = r.randomString("uuid", 0)
+			case 1:
+				record[c] = strconv.Itoa(r.rng.IntN(100000))
+			case 2:
+				record[c] = strconv.FormatFloat(r.rng.Float64()*1000, 'f', 2, 64)
+			case 3:
+				record[c] = r.randomString("email", 0)
+			default:
+				record[c] = r.randomSentence(r.rng.IntN(6) + 2)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return "", err
+		}
+	}
+	writer.Flush()
+	return builder.String(), writer.Error()
+}
+
+// toJSON encodes a value as JSON, e.g. to safely embed a generated string in a JSON template.
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// xmlEscape escapes a string for use as XML text or an attribute value.
+func xmlEscape(s string) string {
+	var builder strings.Builder
+	_ = xml.EscapeText(&builder, []byte(s))
+	return builder.String()
+}
+
+// csvField quotes a string for use as a single CSV field, if it needs quoting.
+func csvField(s string) string {
+	if s == "" || (!strings.ContainsAny(s, ",\"\r\n") && s[0] != ' ' && s[0] != '\t') {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
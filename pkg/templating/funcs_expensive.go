@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"html/template"
 	"math"
-	"math/rand/v2"
 	"strconv"
 	"strings"
 )
@@ -36,16 +35,16 @@ type StyleBlock struct {
 }
 
 // randomStyleBlock generates a <style> block with a specified number of complex CSS rules
-func (tm *TemplateManager) randomStyleBlock(styleType string, requestedCount int) StyleBlock {
-	count := min(requestedCount, tm.config.MaxStyleRules)
-	parentClass := "c-" + randomHexString(12)
+func (r *renderer) randomStyleBlock(styleType string, requestedCount int) StyleBlock {
+	count := min(requestedCount, r.tm.getConfig().MaxStyleRules)
+	parentClass := "c-" + r.randomHexString(12)
 	var builder strings.Builder
 
 	builder.WriteString("<style>\n")
 	for i := 0; i < count; i++ {
-		selector := buildComplexSelector("."+parentClass, styleType)
+		selector := r.buildComplexSelector("."+parentClass, styleType)
 		// Use the pure randomCSSStyle function for the rule body.
-		ruleBody := randomCSSStyle(rand.IntN(4) + 2)
+		ruleBody := r.randomCSSStyle(r.rng.IntN(4) + 2)
 		_, err := fmt.Fprintf(&builder, "%s { %s }\n", selector, ruleBody)
 		if err != nil {
 			return StyleBlock{}
@@ -60,44 +59,44 @@ func (tm *TemplateManager) randomStyleBlock(styleType string, requestedCount int
 }
 
 // buildComplexSelector is an unexported helper for creating convoluted CSS selectors.
-func buildComplexSelector(base, styleType string) string {
+func (r *renderer) buildComplexSelector(base, styleType string) string {
 	switch styleType {
 	case "nested":
-		depth := rand.IntN(8) + 4
+		depth := r.rng.IntN(8) + 4
 		selector := base
 		for i := 0; i < depth; i++ {
-			selector += " > " + randomKeyword([]string{"div", "span", "p", "a"})
-			if rand.IntN(2) == 0 {
-				selector += fmt.Sprintf(":nth-child(%d)", rand.IntN(10)+1)
+			selector += " > " + r.randomKeyword([]string{"div", "span", "p", "a"})
+			if r.rng.IntN(2) == 0 {
+				selector += fmt.Sprintf(":nth-child(%d)", r.rng.IntN(10)+1)
 			}
 		}
 		return selector
 	case "complex":
-		attr := fmt.Sprintf(`[data-%s^="%s"]`, randomWord(), randomHexString(3))
-		pseudo := ":" + randomKeyword([]string{"hover", "active", "focus", "not(:last-child)", "first-of-type"})
-		combinator := " " + randomKeyword([]string{"+", "~", ">"}) + " " + randomKeyword([]string{"span", "b", "i"})
+		attr := fmt.Sprintf(`[data-%s^="%s"]`, r.randomWord(), r.randomHexString(3))
+		pseudo := ":" + r.randomKeyword([]string{"hover", "active", "focus", "not(:last-child)", "first-of-type"})
+		combinator := " " + r.randomKeyword([]string{"+", "~", ">"}) + " " + r.randomKeyword([]string{"span", "b", "i"})
 		return base + attr + pseudo + combinator
 	default: // "utility"
-		return base + " ." + randomHexString(8)
+		return base + " ." + r.randomHexString(8)
 	}
 }
 
 // randomCSSVars generates a <style> block defining a chain of interdependent CSS custom properties
-func (tm *TemplateManager) randomCSSVars(requestedCount int) template.HTML {
-	count := min(requestedCount, tm.config.MaxCssVars)
+func (r *renderer) randomCSSVars(requestedCount int) template.HTML {
+	count := min(requestedCount, r.tm.getConfig().MaxCssVars)
 	if count < 2 {
 		return ""
 	}
 	var builder strings.Builder
 	builder.WriteString("<style>:root {\n")
-	_, err := fmt.Fprintf(&builder, "  --v1: %dpx;\n", rand.IntN(100)+1)
+	_, err := fmt.Fprintf(&builder, "  --v1: %dpx;\n", r.rng.IntN(100)+1)
 	if err != nil {
 		return ""
 	}
 	for i := 2; i <= count; i++ {
-		op := randomKeyword([]string{"+", "-", "*"})
-		refVar := fmt.Sprintf("var(--v%d)", rand.IntN(i-1)+1)
-		val := randomInt(1, 50)
+		op := r.randomKeyword([]string{"+", "-", "*"})
+		refVar := fmt.Sprintf("var(--v%d)", r.rng.IntN(i-1)+1)
+		val := r.randomInt(1, 50)
 		expr := fmt.Sprintf("calc(%s %s %dpx)", refVar, op, val)
 		_, err = fmt.Fprintf(&builder, "  --v%d: %s;\n", i, expr)
 		if err != nil {
@@ -110,19 +109,19 @@ func (tm *TemplateManager) randomCSSVars(requestedCount int) template.HTML {
 
 // randomSVG generates complex, computationally expensive inline SVG graphics.
 // Types: "fractal", "filters". These stress the client's rendering engine.
-func (tm *TemplateManager) randomSVG(svgType string, depth int) template.HTML {
+func (r *renderer) randomSVG(svgType string, depth int) template.HTML {
 	switch svgType {
 	case "fractal":
-		return tm.generateFractalSVG(depth)
+		return r.generateFractalSVG(depth)
 	case "filters":
-		return tm.generateFiltersSVG(depth)
+		return r.generateFiltersSVG(depth)
 	default:
 		return ""
 	}
 }
 
 // generateFractalSVG creates a recursive fractal tree, a classic CPU-intensive render task.
-func (tm *TemplateManager) generateFractalSVG(depth int) template.HTML {
+func (r *renderer) generateFractalSVG(depth int) template.HTML {
 	var pathBuilder strings.Builder
 	var drawBranch func(x, y, angle, length float64, depth int)
 
@@ -137,69 +136,69 @@ func (tm *TemplateManager) generateFractalSVG(depth int) template.HTML {
 			return
 		}
 
-		for i := 0; i < rand.IntN(2)+2; i++ {
-			drawBranch(x2, y2, angle+(rand.Float64()*80-40), length*(rand.Float64()*0.2+0.7), depth-1)
+		for i := 0; i < r.rng.IntN(2)+2; i++ {
+			drawBranch(x2, y2, angle+(r.rng.Float64()*80-40), length*(r.rng.Float64()*0.2+0.7), depth-1)
 		}
 	}
 
 	// Total elements are capped by config for safety.
-	maxElements := tm.config.MaxSvgElements
+	maxElements := r.tm.getConfig().MaxSvgElements
 	if int(math.Pow(3, float64(depth))) > maxElements {
 		depth = int(math.Log(float64(maxElements)) / math.Log(3))
 	}
 
-	drawBranch(250, 500, -90, float64(randomInt(70, 90)), depth)
+	drawBranch(250, 500, -90, float64(r.randomInt(70, 90)), depth)
 
-	svg := fmt.Sprintf(`<svg width="500" height="500" viewBox="0 0 500 500"><path d="%s" stroke="%s" stroke-width="1" fill="none"/></svg>`, pathBuilder.String(), randomColor())
+	svg := fmt.Sprintf(`<svg width="500" height="500" viewBox="0 0 500 500"><path d="%s" stroke="%s" stroke-width="1" fill="none"/></svg>`, pathBuilder.String(), r.randomColor())
 	return template.HTML(svg)
 }
 
 // generateFiltersSVG's complexity now scales with aggression.
-func (tm *TemplateManager) generateFiltersSVG(depth int) template.HTML {
-	filterID := "f-" + randomHexString(8)
+func (r *renderer) generateFiltersSVG(depth int) template.HTML {
+	filterID := "f-" + r.randomHexString(8)
 
 	filterGenerators := []func() string{
-		func() string { return fmt.Sprintf(`<feGaussianBlur stdDeviation="%d"/>`, rand.IntN(5)+1) },
+		func() string { return fmt.Sprintf(`<feGaussianBlur stdDeviation="%d"/>`, r.rng.IntN(5)+1) },
 		func() string {
-			return fmt.Sprintf(`<feMorphology operator="%s" radius="%d"/>`, randomKeyword([]string{"erode", "dilate"}), rand.IntN(4)+1)
+			return fmt.Sprintf(`<feMorphology operator="%s" radius="%d"/>`, r.randomKeyword([]string{"erode", "dilate"}), r.rng.IntN(4)+1)
 		},
 		func() string {
-			return fmt.Sprintf(`<feTurbulence type="fractalNoise" baseFrequency="0.0%d" numOctaves="%d" result="t"/>`, rand.IntN(8)+1, rand.IntN(3)+2)
+			return fmt.Sprintf(`<feTurbulence type="fractalNoise" baseFrequency="0.0%d" numOctaves="%d" result="t"/>`, r.rng.IntN(8)+1, r.rng.IntN(3)+2)
 		},
 		func() string { return `<feDisplacementMap in="SourceGraphic" in2="t" scale="50"/>` },
-		func() string { return fmt.Sprintf(`<feColorMatrix type="hueRotate" values="%d"/>`, rand.IntN(361)) },
+		func() string { return fmt.Sprintf(`<feColorMatrix type="hueRotate" values="%d"/>`, r.rng.IntN(361)) },
 		func() string {
-			return fmt.Sprintf(`<feConvolveMatrix order="3" kernelMatrix="1 -1 1 -1 %d -1 1 -1 1"/>`, rand.IntN(10)-5)
+			return fmt.Sprintf(`<feConvolveMatrix order="3" kernelMatrix="1 -1 1 -1 %d -1 1 -1 1"/>`, r.rng.IntN(10)-5)
 		},
 	}
 
 	var filterChain strings.Builder
 	depth = min(depth, len(filterGenerators))
 
-	rand.Shuffle(len(filterGenerators), func(i, j int) { filterGenerators[i], filterGenerators[j] = filterGenerators[j], filterGenerators[i] })
+	r.rng.Shuffle(len(filterGenerators), func(i, j int) { filterGenerators[i], filterGenerators[j] = filterGenerators[j], filterGenerators[i] })
 
 	for i := 0; i < depth; i++ {
 		filterChain.WriteString(filterGenerators[i]())
 	}
 
 	svg := fmt.Sprintf(`<svg width="200" height="200"><defs><filter id="%s" x="-50%%" y="-50%%" width="200%%" height="200%%">%s</filter></defs><rect width="100%%" height="100%%" fill="%s" filter="url(#%s)"/></svg>`,
-		filterID, filterChain.String(), randomColor(), filterID)
+		filterID, filterChain.String(), r.randomColor(), filterID)
 	return template.HTML(svg)
 }
 
 // jsInteractiveContent is the master function for JS-based deception.
 // The `wasteCycles` parameter directly controls the number of iterations in the waste loop.
-func (tm *TemplateManager) jsInteractiveContent(tag, content string, wasteCycles int) template.HTML {
-	if len(content) > tm.config.MaxJsContentSize {
-		content = content[:tm.config.MaxJsContentSize]
+func (r *renderer) jsInteractiveContent(tag, content string, wasteCycles int) template.HTML {
+	if maxSize := r.tm.getConfig().MaxJsContentSize; len(content) > maxSize {
+		content = content[:maxSize]
 	}
 
-	placeholderID := "p-" + randomHexString(12)
+	placeholderID := "p-" + r.randomHexString(12)
 
 	var encodedContent, decoderJS string
-	xorKey := byte(rand.IntN(254) + 1)
+	xorKey := byte(r.rng.IntN(254) + 1)
 
-	switch rand.IntN(6) {
+	switch r.rng.IntN(6) {
 	case 0: // Reversed Base64
 		encodedContent = reverseString(base64.StdEncoding.EncodeToString([]byte(content)))
 		decoderJS = "d=atob(c.split('').reverse().join(''));"
@@ -224,16 +223,16 @@ func (tm *TemplateManager) jsInteractiveContent(tag, content string, wasteCycles
 		decoderJS = "d=atob(c);"
 	}
 
-	iterations := min(wasteCycles, tm.config.MaxJsWasteCycles) // Safety cap
+	iterations := min(wasteCycles, r.tm.getConfig().MaxJsWasteCycles) // Safety cap
 
 	var mathExprs []string
-	for i := 0; i < rand.IntN(5)+2; i++ { // 2-6 lines of math
-		mathExprs = append(mathExprs, "w+="+randomJSExpr(2)+";")
+	for i := 0; i < r.rng.IntN(5)+2; i++ { // 2-6 lines of math
+		mathExprs = append(mathExprs, "w+="+r.randomJSExpr(2)+";")
 	}
 	mathWaste := strings.Join(mathExprs, "")
 	wasteJS := fmt.Sprintf(`let w=0;for(let i=0;i<%d;i++){%s}`, iterations, mathWaste)
 
-	sideEffectJS := fmt.Sprintf(`p.style.borderLeft='%dpx solid transparent';p.style.opacity=(w%%100)/100+0.01;`, rand.IntN(5)+1)
+	sideEffectJS := fmt.Sprintf(`p.style.borderLeft='%dpx solid transparent';p.style.opacity=(w%%100)/100+0.01;`, r.rng.IntN(5)+1)
 
 	script := fmt.Sprintf(`(function(){let c='%s',d; %s; let p=document.getElementById('%s');try{%s;p.innerHTML=d;}catch(e){} %s})();`,
 		encodedContent, wasteJS, placeholderID, decoderJS, sideEffectJS)
@@ -270,14 +269,14 @@ func reverseString(s string) string {
 }
 
 // randomJSExpr recursively builds a random, complex math expression string.
-func randomJSExpr(depth int) string {
-	if depth <= 0 || rand.Float64() < 0.3 {
-		return jsVars[rand.IntN(len(jsVars))]
+func (r *renderer) randomJSExpr(depth int) string {
+	if depth <= 0 || r.rng.Float64() < 0.3 {
+		return jsVars[r.rng.IntN(len(jsVars))]
 	}
-	if rand.Float64() < 0.5 {
-		op := jsUnaryOps[rand.IntN(len(jsUnaryOps))]
-		return fmt.Sprintf(op, randomJSExpr(depth-1))
+	if r.rng.Float64() < 0.5 {
+		op := jsUnaryOps[r.rng.IntN(len(jsUnaryOps))]
+		return fmt.Sprintf(op, r.randomJSExpr(depth-1))
 	}
-	op := jsBinaryOps[rand.IntN(len(jsBinaryOps))]
-	return fmt.Sprintf(op, randomJSExpr(depth-1), randomJSExpr(depth-1))
+	op := jsBinaryOps[r.rng.IntN(len(jsBinaryOps))]
+	return fmt.Sprintf(op, r.randomJSExpr(depth-1), r.randomJSExpr(depth-1))
 }
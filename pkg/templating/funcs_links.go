@@ -1,30 +1,30 @@
 package templating
 
 import (
-	"math/rand/v2"
 	"strings"
 )
 
 // randomLink generates a plausible, random, relative URL path.
-func (tm *TemplateManager) randomLink() string {
+func (r *renderer) randomLink() string {
 	var builder strings.Builder
 	builder.WriteByte('/')
 
 	// Generate the first path segment, ensuring it's not in the whitelist.
 	for {
-		segment := randomWord()
+		segment := r.randomWord()
 		path := "/" + segment
-		if _, isWhitelisted := tm.whitelistMap[path]; !isWhitelisted {
+		if !r.tm.isPathWhitelisted(path) {
 			builder.WriteString(segment)
 			break
 		}
 	}
 
 	// Add additional random subpaths.
-	numSubpaths := rand.IntN(tm.config.MaxSubpaths-tm.config.MinSubpaths+1) + tm.config.MinSubpaths
+	config := r.tm.getConfig()
+	numSubpaths := r.rng.IntN(config.MaxSubpaths-config.MinSubpaths+1) + config.MinSubpaths
 	for i := 1; i < numSubpaths; i++ {
 		builder.WriteByte('/')
-		builder.WriteString(randomWord())
+		builder.WriteString(r.randomWord())
 	}
 
 	// End with a trailing slash for a directory-like appearance.
@@ -33,8 +33,8 @@ func (tm *TemplateManager) randomLink() string {
 }
 
 // randomQueryLink generates a random URL path and appends a specified number of random query parameters.
-func (tm *TemplateManager) randomQueryLink(keyCount int) string {
-	path := tm.randomLink()
+func (r *renderer) randomQueryLink(keyCount int) string {
+	path := r.randomLink()
 	if keyCount <= 0 {
 		return path
 	}
@@ -44,9 +44,9 @@ func (tm *TemplateManager) randomQueryLink(keyCount int) string {
 	builder.WriteByte('?')
 
 	for i := 0; i < keyCount; i++ {
-		key := randomWord()
+		key := r.randomWord()
 		// Using the pure `randomString` function for the value.
-		value := randomString("alphanum", 12)
+		value := r.randomString("alphanum", 12)
 		builder.WriteString(key)
 		builder.WriteByte('=')
 		builder.WriteString(value)
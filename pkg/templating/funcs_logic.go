@@ -1,7 +1,6 @@
 package templating
 
 import (
-	"math/rand/v2"
 	"reflect"
 )
 
@@ -23,7 +22,7 @@ func list(args ...any) []any {
 }
 
 // randomChoice selects and returns a single random element from a slice.
-func randomChoice(slice any) any {
+func (r *renderer) randomChoice(slice any) any {
 	if slice == nil {
 		return nil
 	}
@@ -44,14 +43,14 @@ func randomChoice(slice any) any {
 	}
 
 	// Select a random index and return the element at that index.
-	randomIndex := rand.IntN(val.Len())
+	randomIndex := r.rng.IntN(val.Len())
 	return val.Index(randomIndex).Interface()
 }
 
 // randomInt returns a random integer within the range [min, max).
-func randomInt(min, max int) int {
+func (r *renderer) randomInt(min, max int) int {
 	if min >= max {
 		return min
 	}
-	return rand.IntN(max-min) + min
+	return r.rng.IntN(max-min) + min
 }
@@ -2,7 +2,6 @@ package templating
 
 import (
 	"html/template"
-	"math/rand/v2"
 	"strconv"
 	"strings"
 )
@@ -16,21 +15,21 @@ type DefinitionData struct {
 }
 
 // randomForm generates a <form> with a specified number of varied input fields.
-func (tm *TemplateManager) randomForm(count, styleCount int) template.HTML {
-	count = min(count, tm.config.MaxFormFields)
+func (r *renderer) randomForm(count, styleCount int) template.HTML {
+	count = min(count, r.tm.getConfig().MaxFormFields)
 
 	var builder strings.Builder
 	inputTypes := []string{"text", "password", "radio", "checkbox", "submit", "button", "date", "email"}
 
 	builder.WriteString("<form method=\"post\" action=\"#\">\n")
 	for i := 0; i < count; i++ {
-		id := randomId("input", 8)
-		inputType := inputTypes[rand.IntN(len(inputTypes))]
+		id := r.randomId("input", 8)
+		inputType := inputTypes[r.rng.IntN(len(inputTypes))]
 
 		builder.WriteString("  <div>\n")
-		builder.WriteString("    <label for=\"" + id + "\">" + randomWord() + "</label>\n")
+		builder.WriteString("    <label for=\"" + id + "\">" + r.randomWord() + "</label>\n")
 		builder.WriteString("    <input type=\"" + inputType + "\" id=\"" + id + "\" name=\"" + id + "\" ")
-		builder.WriteString(string(randomInlineStyle(styleCount)))
+		builder.WriteString(string(r.randomInlineStyle(styleCount)))
 		builder.WriteString(">\n")
 		builder.WriteString("  </div>\n")
 	}
@@ -39,20 +38,20 @@ func (tm *TemplateManager) randomForm(count, styleCount int) template.HTML {
 }
 
 // randomDefinitionData returns a slice of {Term, Def} structs.
-func randomDefinitionData(count, sentenceLength int) []DefinitionData {
+func (r *renderer) randomDefinitionData(count, sentenceLength int) []DefinitionData {
 	data := make([]DefinitionData, count)
 	for i := 0; i < count; i++ {
 		data[i] = DefinitionData{
-			Term: randomWord(),
-			Def:  randomSentence(sentenceLength),
+			Term: r.randomWord(),
+			Def:  r.randomSentence(sentenceLength),
 		}
 	}
 	return data
 }
 
 // nestDivs generates a specified number of deeply nested <div> elements.
-func (tm *TemplateManager) nestDivs(depth int) template.HTML {
-	depth = min(depth, tm.config.MaxNestDivs)
+func (r *renderer) nestDivs(depth int) template.HTML {
+	depth = min(depth, r.tm.getConfig().MaxNestDivs)
 	if depth <= 0 {
 		return ""
 	}
@@ -60,10 +59,10 @@ func (tm *TemplateManager) nestDivs(depth int) template.HTML {
 	var builder strings.Builder
 	for i := 0; i < depth; i++ {
 		builder.WriteString("<div class=\"")
-		builder.WriteString(string(randomClasses(3)))
+		builder.WriteString(string(r.randomClasses(3)))
 		builder.WriteString("\">")
 	}
-	builder.WriteString(randomSentence(5))
+	builder.WriteString(r.randomSentence(5))
 	for i := 0; i < depth; i++ {
 		builder.WriteString("</div>")
 	}
@@ -71,28 +70,29 @@ func (tm *TemplateManager) nestDivs(depth int) template.HTML {
 }
 
 // randomComplexTable generates an irregular HTML table with random colspans.
-func (tm *TemplateManager) randomComplexTable(rows, cols int) template.HTML {
-	rows = min(rows, tm.config.MaxTableRows)
-	cols = min(cols, tm.config.MaxTableCols)
+func (r *renderer) randomComplexTable(rows, cols int) template.HTML {
+	config := r.tm.getConfig()
+	rows = min(rows, config.MaxTableRows)
+	cols = min(cols, config.MaxTableCols)
 	if rows <= 0 || cols <= 0 {
 		return ""
 	}
 	var builder strings.Builder
 	builder.WriteString("<table border=\"1\">\n")
-	for r := 0; r < rows; r++ {
+	for row := 0; row < rows; row++ {
 		builder.WriteString("  <tr>\n")
 		c := 0
 		for c < cols {
-			colspan := rand.IntN(3) + 1
+			colspan := r.rng.IntN(3) + 1
 			if c+colspan > cols {
 				colspan = cols - c
 			}
 			tag := "td"
-			if rand.IntN(5) == 0 {
+			if r.rng.IntN(5) == 0 {
 				tag = "th"
 			}
 			builder.WriteString("    <" + tag + " colspan=\"" + strconv.Itoa(colspan) + "\">")
-			builder.WriteString(randomSentence(3))
+			builder.WriteString(r.randomSentence(3))
 			builder.WriteString("</" + tag + ">\n")
 			c += colspan
 		}
@@ -1,6 +1,7 @@
 package templating
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"strings"
 	"testing"
@@ -9,15 +10,16 @@ import (
 // TestTemplateFunctions validates the behavior of each category of template functions.
 func TestTemplateFunctions(t *testing.T) {
 	tm := setupTestManager(t)
+	r := newRenderer(tm, nil)
 
 	t.Run("ContentFuncs", func(t *testing.T) {
-		word := randomWord()
+		word := r.randomWord()
 		if !containsString(wordList, word) {
-			t.Errorf("randomWord() returned '%s', which is not in the global word list", word)
+			t.Errorf("r.randomWord() returned '%s', which is not in the global word list", word)
 		}
 
 		// This test now works because setupTestManager correctly inserts and trains the model.
-		sent, err := tm.markovSentence("test_model", 5)
+		sent, err := r.markovSentence("test_model", 5)
 		if err != nil {
 			t.Fatalf("markovSentence failed: %v", err)
 		}
@@ -27,7 +29,15 @@ func TestTemplateFunctions(t *testing.T) {
 			t.Errorf("markovSentence returned unexpected sentence: '%s'", sent)
 		}
 
-		jsonData, err := tm.randomJSON(tm.config.MaxJSONDepth+1, 2, 2)
+		var streamed strings.Builder
+		for token := range r.markovStream("test_model", 3) {
+			streamed.WriteString(token)
+		}
+		if streamed.String() != "one two three" {
+			t.Errorf("markovStream returned unexpected text: '%s'", streamed.String())
+		}
+
+		jsonData, err := r.randomJSON(tm.config.MaxJSONDepth+1, 2, 2)
 		if err != nil {
 			t.Fatalf("randomJSON failed: %v", err)
 		}
@@ -37,41 +47,82 @@ func TestTemplateFunctions(t *testing.T) {
 		}
 	})
 
+	t.Run("DataFuncs", func(t *testing.T) {
+		items, err := r.feedItems("test_model", 999)
+		if err != nil {
+			t.Fatalf("feedItems failed: %v", err)
+		}
+		if len(items) != tm.config.MaxFeedItems {
+			t.Errorf("feedItems did not respect MaxFeedItems limit, generated %d items", len(items))
+		}
+		for i := 1; i < len(items); i++ {
+			if !items[i].Published.Before(items[i-1].Published) {
+				t.Fatal("feedItems should be ordered newest first")
+			}
+		}
+
+		csvData, err := r.randomCSV(5, 3)
+		if err != nil {
+			t.Fatalf("randomCSV failed: %v", err)
+		}
+		records, err := csv.NewReader(strings.NewReader(csvData)).ReadAll()
+		if err != nil {
+			t.Fatalf("randomCSV did not produce valid CSV: %v", err)
+		}
+		if len(records) != 6 || len(records[0]) != 3 {
+			t.Errorf("randomCSV produced %d records, expected a header and 5 rows", len(records))
+		}
+
+		encoded, err := toJSON(`say "hi"`)
+		if err != nil || encoded != `"say \"hi\""` {
+			t.Errorf("toJSON returned '%s', %v", encoded, err)
+		}
+		if escaped := xmlEscape("<a & b>"); escaped != "&lt;a &amp; b&gt;" {
+			t.Errorf("xmlEscape returned '%s'", escaped)
+		}
+		if field := csvField(`a, "b"`); field != `"a, ""b"""` {
+			t.Errorf("csvField returned '%s'", field)
+		}
+		if field := csvField("plain"); field != "plain" {
+			t.Errorf("csvField should not quote '%s'", field)
+		}
+	})
+
 	t.Run("StructureFuncs", func(t *testing.T) {
 		// Test safety limits
-		formHTML := tm.randomForm(999, 1)
+		formHTML := r.randomForm(999, 1)
 		if strings.Count(string(formHTML), "<input") != tm.config.MaxFormFields {
 			t.Errorf("randomForm did not respect MaxFormFields limit")
 		}
-		divHTML := tm.nestDivs(999)
+		divHTML := r.nestDivs(999)
 		if divs := strings.Count(string(divHTML), "<div"); divs != tm.config.MaxNestDivs {
 			t.Errorf("nestDivs did not respect MaxNestDivs limit, generated %d divs", divs)
 		}
 	})
 
 	t.Run("StylingFuncs", func(t *testing.T) {
-		if !strings.HasPrefix(randomColor(), "#") || len(randomColor()) != 7 {
+		if !strings.HasPrefix(r.randomColor(), "#") || len(r.randomColor()) != 7 {
 			t.Error("randomColor has incorrect format")
 		}
-		if !strings.HasPrefix(randomId("prefix", 8), "prefix-") {
+		if !strings.HasPrefix(r.randomId("prefix", 8), "prefix-") {
 			t.Error("randomId has incorrect format")
 		}
-		classes := string(randomClasses(3))
+		classes := string(r.randomClasses(3))
 		if strings.Count(classes, " ") != 2 {
 			t.Error("randomClasses generated wrong number of classes")
 		}
-		style := string(randomInlineStyle(1))
+		style := string(r.randomInlineStyle(1))
 		if !strings.HasPrefix(style, `style="`) || !strings.HasSuffix(style, `"`) {
 			t.Error("randomInlineStyle has incorrect format")
 		}
 	})
 
 	t.Run("LinkFuncs", func(t *testing.T) {
-		link := tm.randomLink()
+		link := r.randomLink()
 		if !strings.HasPrefix(link, "/") || strings.HasPrefix(link, "/api") {
 			t.Error("randomLink failed whitelist check or format")
 		}
-		jsonHTML, _ := tm.randomJSON(99, 2, 10)
+		jsonHTML, _ := r.randomJSON(99, 2, 10)
 		var data any
 		// Check if it's valid JSON
 		if err := json.Unmarshal([]byte(jsonHTML), &data); err != nil {
@@ -83,11 +134,11 @@ func TestTemplateFunctions(t *testing.T) {
 		if len(repeat(5)) != 5 {
 			t.Error("repeat failed")
 		}
-		choice := randomChoice([]string{"a", "b", "c"}).(string)
+		choice := r.randomChoice([]string{"a", "b", "c"}).(string)
 		if choice != "a" && choice != "b" && choice != "c" {
 			t.Error("randomChoice failed")
 		}
-		if randomInt(10, 11) != 10 {
+		if r.randomInt(10, 11) != 10 {
 			t.Error("randomInt failed")
 		}
 	})
@@ -107,7 +158,7 @@ func TestTemplateFunctions(t *testing.T) {
 	t.Run("ExpensiveFuncs", func(t *testing.T) {
 		// Test jsInteractiveContent obfuscation and structure
 		secret := "my-secret-data"
-		jsHTML := tm.jsInteractiveContent("div", secret, 1000)
+		jsHTML := r.jsInteractiveContent("div", secret, 1000)
 		if strings.Contains(string(jsHTML), secret) {
 			t.Error("jsInteractiveContent failed to obfuscate content")
 		}
@@ -115,7 +166,7 @@ func TestTemplateFunctions(t *testing.T) {
 			t.Error("jsInteractiveContent did not generate correct HTML structure")
 		}
 		// Test SVG generation (just ensure it's not empty)
-		svgHTML := tm.randomSVG("fractal", 5)
+		svgHTML := r.randomSVG("fractal", 5)
 		if !strings.HasPrefix(string(svgHTML), "<svg") {
 			t.Error("randomSVG failed to generate an SVG")
 		}
@@ -0,0 +1,71 @@
+package templating
+
+import "strings"
+
+// ContentKind identifies the type of document a template produces. It decides how the
+// template is parsed and which Content-Type it is served with. The kind of a template is
+// taken from its file extension: "page.tmpl.html" is HTML, "feed.tmpl.rss" is RSS, and so on.
+type ContentKind string
+
+const (
+	KindHTML ContentKind = "html"
+	KindJSON ContentKind = "json"
+	KindXML  ContentKind = "xml"
+	KindRSS  ContentKind = "rss"
+	KindAtom ContentKind = "atom"
+	KindCSV  ContentKind = "csv"
+	KindText ContentKind = "txt"
+)
+
+// textKinds are the kinds parsed with text/template rather than html/template. Their
+// templates are responsible for their own escaping, see toJSON, xmlEscape and csvField.
+var textKinds = []ContentKind{KindJSON, KindXML, KindRSS, KindAtom, KindCSV, KindText}
+
+var contentTypes = map[ContentKind]string{
+	KindHTML: "text/html; charset=utf-8",
+	KindJSON: "application/json; charset=utf-8",
+	KindXML:  "application/xml; charset=utf-8",
+	KindRSS:  "application/rss+xml; charset=utf-8",
+	KindAtom: "application/atom+xml; charset=utf-8",
+	KindCSV:  "text/csv; charset=utf-8",
+	KindText: "text/plain; charset=utf-8",
+}
+
+// ContentType returns the Content-Type header value for documents of this kind.
+func (k ContentKind) ContentType() string {
+	if contentType, ok := contentTypes[k]; ok {
+		return contentType
+	}
+	return contentTypes[KindHTML]
+}
+
+// KindOf returns the kind of the named template or partial, based on its extension.
+// Names without a known extension are treated as HTML.
+func KindOf(name string) ContentKind {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return KindHTML
+	}
+	kind := ContentKind(name[i+1:])
+	if _, ok := contentTypes[kind]; !ok {
+		return KindHTML
+	}
+	return kind
+}
+
+// IsTemplateFile reports whether name follows the naming convention for templates
+// ("*.tmpl.<kind>") or partials ("*.part.<kind>") of any supported kind.
+func IsTemplateFile(name string) bool {
+	for kind := range contentTypes {
+		if strings.HasSuffix(name, ".tmpl."+string(kind)) || strings.HasSuffix(name, ".part."+string(kind)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFullTemplate reports whether name is a full template (as opposed to a partial or a
+// template defined inline with {{define}}).
+func isFullTemplate(name string) bool {
+	return strings.Contains(name, ".tmpl.")
+}
@@ -10,8 +10,10 @@ import (
 	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	texttemplate "text/template"
 
 	"github.com/amenyxia/Sarracenia/pkg/markov"
 )
@@ -70,7 +72,10 @@ type TemplateManager struct {
 	markovModels   map[string]markov.ModelInfo
 	templates      *template.Template
 	cleanTemplates *template.Template
+	textTemplates  *texttemplate.Template
+	renderPool     *sync.Pool
 	templateNames  []string
+	textNames      map[ContentKind][]string
 	funcMap        template.FuncMap
 	templateDir    string
 	mu             sync.RWMutex
@@ -101,7 +106,9 @@ func NewTemplateManager(logger *slog.Logger, markovGen *markov.Generator, config
 		config:       config,
 		whitelistMap: map[string]struct{}{},
 	}
-	tm.funcMap = tm.makeFuncMap()
+	// The manager's own function map is only used for parsing. Each render binds the
+	// functions to its own renderer, see newRenderSet.
+	tm.funcMap = newRenderer(tm, nil).funcMap()
 
 	if err := tm.Refresh(); err != nil {
 		return nil, err
@@ -111,64 +118,6 @@ func NewTemplateManager(logger *slog.Logger, markovGen *markov.Generator, config
 	return tm, nil
 }
 
-func (tm *TemplateManager) makeFuncMap() template.FuncMap {
-	return template.FuncMap{
-		// Content Generation (from funcs_content.go)
-		"markovSentence":   tm.markovSentence,
-		"markovParagraphs": tm.markovParagraphs,
-		"randomWord":       randomWord,
-		"randomSentence":   randomSentence,
-		"randomParagraphs": randomParagraphs,
-		"randomString":     randomString,
-		"randomDate":       randomDate,
-		"randomJSON":       tm.randomJSON,
-
-		// Structure & Composition (from funcs_structure.go)
-		"randomForm":           tm.randomForm,
-		"randomDefinitionData": randomDefinitionData,
-		"nestDivs":             tm.nestDivs,
-		"randomComplexTable":   tm.randomComplexTable,
-
-		// Styling (from funcs_styling.go)
-		"randomColor":       randomColor,
-		"randomId":          randomId,
-		"randomClasses":     randomClasses,
-		"randomCSSStyle":    randomCSSStyle,
-		"randomInlineStyle": randomInlineStyle,
-
-		// Link & Navigation (from funcs_links.go)
-		"randomLink":      tm.randomLink,
-		"randomQueryLink": tm.randomQueryLink,
-
-		// Logic & Control (from funcs_logic.go)
-		"repeat":       repeat,
-		"list":         list,
-		"randomChoice": randomChoice,
-		"randomInt":    randomInt,
-
-		// Simple (from funcs_simple.go)
-		"add":   add,
-		"sub":   sub,
-		"div":   div,
-		"mult":  mult,
-		"max":   max,
-		"min":   min,
-		"mod":   mod,
-		"inc":   inc,
-		"dec":   dec,
-		"and":   and,
-		"or":    or,
-		"not":   not,
-		"isSet": isSet,
-
-		// Computationally Expensive (from funcs_expensive.go)
-		"randomStyleBlock":     tm.randomStyleBlock,
-		"randomCSSVars":        tm.randomCSSVars,
-		"randomSVG":            tm.randomSVG,
-		"jsInteractiveContent": tm.jsInteractiveContent,
-	}
-}
-
 // SetConfig applies a new configuration to the TemplateManager. This allows for
 // changes to the engine's behavior, such as updating safety limits or
 // the path whitelist, without needing to restart the application.
@@ -176,9 +125,12 @@ func (tm *TemplateManager) SetConfig(config *TemplateConfig) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 	tm.config = config
+	// Build a fresh map rather than mutating the old one, since renders in flight may still be reading it.
+	whitelistMap := make(map[string]struct{}, len(config.PathWhitelist))
 	for _, path := range config.PathWhitelist {
-		tm.whitelistMap[path] = struct{}{}
+		whitelistMap[path] = struct{}{}
 	}
+	tm.whitelistMap = whitelistMap
 	if tm.config.MarkovEnabled {
 		var opts []markov.Option
 		if tm.config.MarkovSeparator != "" {
@@ -251,8 +203,18 @@ func (tm *TemplateManager) Refresh() error {
 		tm.logger.Warn("No template files found matching pattern", "pattern", filePattern)
 	}
 
+	// Non-HTML templates are parsed with text/template, as html/template would escape them as HTML.
+	textTemplates, textNames, err := tm.parseTextTemplates()
+	if err != nil {
+		return err
+	}
+
+	// Sort the names, so that picking a template with a seeded source is reproducible across restarts.
+	slices.Sort(names)
 	tm.templates = newParsedFiles
 	tm.templateNames = names
+	tm.textTemplates = textTemplates
+	tm.textNames = textNames
 	tm.logger.Info("Loaded template and partial files", "count", len(parsedFiles.Templates())-1) // Subtract one for the root template
 
 	// Create a clean clone for string executions after all parsing is complete.
@@ -261,6 +223,8 @@ func (tm *TemplateManager) Refresh() error {
 		tm.logger.Error("failed to create a clean clone of templates", "error", err)
 		return err
 	}
+	// Render sets are cloned from the clean templates, so sets from before the refresh must not be reused.
+	tm.renderPool = &sync.Pool{}
 
 	if tm.config.MarkovEnabled {
 		tm.logger.Info("Loading markov models...")
@@ -271,39 +235,181 @@ func (tm *TemplateManager) Refresh() error {
 			return err
 		}
 
-		tm.markovModels = make(map[string]markov.ModelInfo)
-
+		markovModels := make(map[string]markov.ModelInfo, len(models))
 		for _, model := range models {
-			tm.markovModels[model.Name] = model
+			markovModels[model.Name] = model
 		}
+		tm.markovModels = markovModels
 		tm.logger.Info("Loaded markov models", "count", len(tm.markovModels))
 	}
 
 	return nil
 }
 
+// parseTextTemplates parses the templates and partials of every non-HTML kind into a single text/template
+// set. It returns the set along with the names of the full templates of each kind.
+func (tm *TemplateManager) parseTextTemplates() (*texttemplate.Template, map[ContentKind][]string, error) {
+	set := texttemplate.New("").Funcs(texttemplate.FuncMap(tm.funcMap))
+	for _, kind := range textKinds {
+		for _, pattern := range []string{"*.tmpl." + string(kind), "*.part." + string(kind)} {
+			files, err := filepath.Glob(filepath.Join(tm.templateDir, pattern))
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(files) == 0 {
+				continue
+			}
+			if _, err = set.ParseFiles(files...); err != nil {
+				tm.logger.Error("failed to parse template files", "pattern", pattern, "error", err)
+				return nil, nil, err
+			}
+		}
+	}
+
+	names := make(map[ContentKind][]string)
+	for _, t := range set.Templates() {
+		if isFullTemplate(t.Name()) {
+			kind := KindOf(t.Name())
+			names[kind] = append(names[kind], t.Name())
+		}
+	}
+	for kind, kindNames := range names {
+		slices.Sort(kindNames)
+		tm.logger.Info("Loaded non-HTML template files", "kind", kind, "count", len(kindNames))
+	}
+	return set, names, nil
+}
+
 // Execute renders a specific template by name, writing the output to the provided io.Writer.
 // The `data` argument is passed to the template and can be used to provide context or
 // dynamic values.
-func (tm *TemplateManager) Execute(w io.Writer, name string, data interface{}) error {
+//
+// Output is written to w as the template is rendered, so w may be a slow or throttled
+// writer. The manager lock is only held while looking up the current template set,
+// which means a long-running render never blocks Refresh or SetConfig.
+//
+// Every render draws its randomness from its own source, which can be set with WithRand
+// to make the output reproducible.
+//
+// Templates are executed according to their kind (see KindOf): HTML templates with
+// html/template, and every other kind with text/template, without any automatic escaping.
+func (tm *TemplateManager) Execute(w io.Writer, name string, data interface{}, opts ...RenderOption) error {
 	if name == "" {
 		return nil
 	}
+	options := newRenderOptions(opts)
+
 	tm.mu.RLock()
-	defer tm.mu.RUnlock()
-	return tm.templates.ExecuteTemplate(w, name, data)
+	clean, cleanText, pool := tm.cleanTemplates, tm.textTemplates, tm.renderPool
+	tm.mu.RUnlock()
+
+	set, ok := pool.Get().(*renderSet)
+	if !ok {
+		var err error
+		set, err = tm.newRenderSet(clean, cleanText)
+		if err != nil {
+			return fmt.Errorf("failed to prepare templates for rendering: %w", err)
+		}
+	}
+	defer pool.Put(set)
+
+	set.renderer.rng = options.rng
+	if set.renderer.rng == nil {
+		set.renderer.rng = newRand()
+	}
+	if KindOf(name) != KindHTML {
+		return set.text.ExecuteTemplate(w, name, data)
+	}
+	return set.templates.ExecuteTemplate(w, name, data)
+}
+
+// GenerateFiller returns a self-contained HTML fragment of generated paragraphs followed by a
+// number of random links. It is intended for appending to a page that has already been rendered,
+// such as an endless tarpit response. Text comes from the named Markov model, or from the word
+// list if modelName is empty or Markov generation is disabled. Like Execute, it accepts
+// WithRand to control the random source.
+func (tm *TemplateManager) GenerateFiller(modelName string, paragraphs, links int, opts ...RenderOption) (string, error) {
+	options := newRenderOptions(opts)
+	r := newRenderer(tm, options.rng)
+
+	var text string
+	if modelName == "" {
+		text = r.randomParagraphs(paragraphs, 2, 6, 8, 24)
+	} else {
+		var err error
+		text, err = r.markovParagraphs(modelName, paragraphs, 2, 6, 8, 24)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var builder strings.Builder
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		builder.WriteString("<p>")
+		builder.WriteString(template.HTMLEscapeString(paragraph))
+		builder.WriteString("</p>\n")
+	}
+	for i := 0; i < links; i++ {
+		builder.WriteString(`<a href="`)
+		builder.WriteString(template.HTMLEscapeString(r.randomLink()))
+		builder.WriteString(`">`)
+		builder.WriteString(template.HTMLEscapeString(r.randomWord()))
+		builder.WriteString("</a>\n")
+	}
+	return builder.String(), nil
+}
+
+// GenerateLinks returns count random relative URLs, built the same way as by the randomLink
+// template function. Like Execute, it accepts WithRand to control the random source.
+func (tm *TemplateManager) GenerateLinks(count int, opts ...RenderOption) []string {
+	r := newRenderer(tm, newRenderOptions(opts).rng)
+	links := make([]string, 0, max(count, 0))
+	for i := 0; i < count; i++ {
+		links = append(links, r.randomLink())
+	}
+	return links
+}
+
+// GenerateSentence returns a sentence of length random words, like the randomSentence
+// template function. Like Execute, it accepts WithRand to control the random source.
+func (tm *TemplateManager) GenerateSentence(length int, opts ...RenderOption) string {
+	return newRenderer(tm, newRenderOptions(opts).rng).randomSentence(length)
 }
 
 // GetRandomTemplate returns the name of a randomly selected template from the set
 // of loaded full templates. This is the primary mechanism for serving varied and
-// unpredictable pages to web scrapers.
-func (tm *TemplateManager) GetRandomTemplate() string {
+// unpredictable pages to web scrapers. The choice is made with the source set by
+// WithRand, if any.
+func (tm *TemplateManager) GetRandomTemplate(opts ...RenderOption) string {
+	options := newRenderOptions(opts)
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
 	if len(tm.templateNames) == 0 {
 		return ""
 	}
+	if options.rng != nil {
+		return tm.templateNames[options.rng.IntN(len(tm.templateNames))]
+	}
+	return tm.templateNames[rand.IntN(len(tm.templateNames))]
+}
+
+// GetRandomTemplateOfKind is like GetRandomTemplate, but picks from the full templates of the
+// given kind. It returns an empty string if no template of that kind is loaded.
+func (tm *TemplateManager) GetRandomTemplateOfKind(kind ContentKind, opts ...RenderOption) string {
+	if kind == KindHTML {
+		return tm.GetRandomTemplate(opts...)
+	}
+	options := newRenderOptions(opts)
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
-	return tm.templateNames[rand.IntN(len(tm.templateNames))]
+	names := tm.textNames[kind]
+	if len(names) == 0 {
+		return ""
+	}
+	if options.rng != nil {
+		return names[options.rng.IntN(len(names))]
+	}
+	return names[rand.IntN(len(names))]
 }
 
 // GetConfig returns a copy of the current configuration.
@@ -314,6 +420,30 @@ func (tm *TemplateManager) GetConfig() TemplateConfig {
 	return *tm.config
 }
 
+// getConfig returns the current configuration pointer. Template functions use this
+// rather than reading tm.config directly, as they run without the manager lock held.
+func (tm *TemplateManager) getConfig() *TemplateConfig {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.config
+}
+
+// getModel looks up a loaded Markov model by name.
+func (tm *TemplateManager) getModel(name string) (markov.ModelInfo, bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	model, ok := tm.markovModels[name]
+	return model, ok
+}
+
+// isPathWhitelisted reports whether a path is in the configured PathWhitelist.
+func (tm *TemplateManager) isPathWhitelisted(path string) bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	_, ok := tm.whitelistMap[path]
+	return ok
+}
+
 // GetTemplateNames returns a slice of the loaded template names.
 // This mainly exists for concurrency-safety reasons, and because
 // it returns the names of partial templates as well.
@@ -327,6 +457,11 @@ func (tm *TemplateManager) GetTemplateNames() []string {
 			names = append(names, t.Name())
 		}
 	}
+	for _, t := range tm.textTemplates.Templates() {
+		if IsTemplateFile(t.Name()) {
+			names = append(names, t.Name())
+		}
+	}
 	return names
 }
 
@@ -341,21 +476,31 @@ func (tm *TemplateManager) GetTemplateDir() string {
 // ExecuteTemplateString parses and executes a raw template string using the manager's function map.
 // This is ideal for testing or previewing templates without saving them to disk.
 func (tm *TemplateManager) ExecuteTemplateString(w io.Writer, content string, data interface{}) error {
-	tm.mu.RLock()
-	defer tm.mu.RUnlock()
+	return tm.ExecuteTemplateStringKind(w, KindHTML, content, data)
+}
 
+// ExecuteTemplateStringKind is like ExecuteTemplateString, but parses the content as a template
+// of the given kind, with access to the partials of that kind.
+func (tm *TemplateManager) ExecuteTemplateStringKind(w io.Writer, kind ContentKind, content string, data interface{}) error {
 	// Clone the clean, unexecuted template set to avoid race conditions and execution state issues.
-	tempSet, err := tm.cleanTemplates.Clone()
+	tm.mu.RLock()
+	tempSet, err := tm.newRenderSet(tm.cleanTemplates, tm.textTemplates)
+	tm.mu.RUnlock()
 	if err != nil {
 		return fmt.Errorf("failed to clone clean templates for string execution: %w", err)
 	}
 
-	// Parse the user-provided content string into this fresh clone.
-	t, err := tempSet.Parse(content)
+	// Parse the user-provided content string into this fresh clone, and execute it.
+	if kind != KindHTML {
+		t, err := tempSet.text.Parse(content)
+		if err != nil {
+			return fmt.Errorf("failed to parse string template: %w", err)
+		}
+		return t.Execute(w, data)
+	}
+	t, err := tempSet.templates.Parse(content)
 	if err != nil {
 		return fmt.Errorf("failed to parse string template: %w", err)
 	}
-
-	// Execute the temporary template.
 	return t.Execute(w, data)
 }
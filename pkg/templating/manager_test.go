@@ -8,10 +8,13 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/amenyxia/Sarracenia/pkg/markov"
 	_ "github.com/mattn/go-sqlite3"
@@ -158,6 +161,143 @@ func TestManager_Execute(t *testing.T) {
 	}
 }
 
+// blockingWriter stalls every write until release is closed, simulating a drip-fed client.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	return len(p), nil
+}
+
+func TestManager_ExecuteDoesNotBlockRefresh(t *testing.T) {
+	tm := setupTestManager(t)
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+
+	execDone := make(chan error, 1)
+	go func() {
+		execDone <- tm.Execute(w, "dummy.tmpl.html", nil)
+	}()
+	<-w.started
+
+	refreshDone := make(chan error, 1)
+	go func() {
+		refreshDone <- tm.Refresh()
+	}()
+
+	select {
+	case err := <-refreshDone:
+		if err != nil {
+			t.Fatalf("Refresh failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Refresh blocked while a render was in progress")
+	}
+
+	close(w.release)
+	if err := <-execDone; err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+}
+
+func TestManager_ExecuteMarkovStream(t *testing.T) {
+	tm := setupTestManager(t)
+	content := `{{range markovStream "test_model" 3}}{{.}}{{end}}`
+	if err := os.WriteFile(filepath.Join(tm.templateDir, "stream.tmpl.html"), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write stream template: %v", err)
+	}
+	if err := tm.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tm.Execute(&buf, "stream.tmpl.html", nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if buf.String() != "one two three" {
+		t.Errorf("expected output 'one two three', got '%s'", buf.String())
+	}
+}
+
+func TestManager_ExecuteWithRand(t *testing.T) {
+	tm := setupTestManager(t)
+	content := `{{randomWord}} {{randomSentence 8}} {{randomLink}} {{randomCSSStyle 4}} {{markovSentence "test_model" 5}}` +
+		`{{range markovStream "test_model" 5}}{{.}}{{end}}{{randomSVG "fractal" 4}}`
+	if err := os.WriteFile(filepath.Join(tm.templateDir, "seeded.tmpl.html"), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write seeded template: %v", err)
+	}
+	if err := tm.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	render := func(seed uint64) string {
+		var buf bytes.Buffer
+		if err := tm.Execute(&buf, "seeded.tmpl.html", nil, WithRand(rand.New(rand.NewPCG(seed, seed)))); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		return buf.String()
+	}
+
+	first := render(42)
+	for i := 0; i < 5; i++ {
+		if out := render(42); out != first {
+			t.Fatalf("renders with the same seed differ:\n%s\n%s", first, out)
+		}
+	}
+	if render(43) == first {
+		t.Error("renders with different seeds are identical")
+	}
+}
+
+func TestManager_GenerateFiller(t *testing.T) {
+	tm := setupTestManager(t)
+
+	filler, err := tm.GenerateFiller("test_model", 2, 3)
+	if err != nil {
+		t.Fatalf("GenerateFiller failed: %v", err)
+	}
+	if count := strings.Count(filler, "<p>"); count != 2 {
+		t.Errorf("expected 2 paragraphs, got %d", count)
+	}
+	if count := strings.Count(filler, "<a href=\"/"); count != 3 {
+		t.Errorf("expected 3 links, got %d", count)
+	}
+
+	filler, err = tm.GenerateFiller("", 1, 0)
+	if err != nil {
+		t.Fatalf("GenerateFiller without a model failed: %v", err)
+	}
+	if !strings.HasPrefix(filler, "<p>") || strings.Contains(filler, "<a ") {
+		t.Errorf("unexpected filler without a model: '%s'", filler)
+	}
+}
+
+func TestManager_GenerateLinks(t *testing.T) {
+	tm := setupTestManager(t)
+
+	links := tm.GenerateLinks(5, WithRand(rand.New(rand.NewPCG(1, 1))))
+	if len(links) != 5 {
+		t.Fatalf("expected 5 links, got %d", len(links))
+	}
+	for _, link := range links {
+		if !strings.HasPrefix(link, "/") || !strings.HasSuffix(link, "/") {
+			t.Errorf("unexpected link format: '%s'", link)
+		}
+	}
+	again := tm.GenerateLinks(5, WithRand(rand.New(rand.NewPCG(1, 1))))
+	if strings.Join(links, " ") != strings.Join(again, " ") {
+		t.Error("GenerateLinks with equally seeded sources returned different links")
+	}
+
+	if sentence := tm.GenerateSentence(4); !strings.HasSuffix(sentence, ".") || strings.Count(sentence, " ") != 3 {
+		t.Errorf("unexpected sentence: '%s'", sentence)
+	}
+}
+
 func TestManager_GetRandomTemplate(t *testing.T) {
 	tm := setupTestManager(t)
 	name := tm.GetRandomTemplate()
@@ -166,6 +306,70 @@ func TestManager_GetRandomTemplate(t *testing.T) {
 	}
 }
 
+func TestManager_ExecuteTextKinds(t *testing.T) {
+	tm := setupTestManager(t)
+	files := map[string]string{
+		"api.tmpl.json":   `{"title": {{toJSON "<b>\"quoted\"</b>"}}}`,
+		"feed.tmpl.rss":   `<rss>{{range feedItems "" 2}}<item>{{xmlEscape "a & b"}}</item>{{end}}</rss>`,
+		"header.part.csv": `{{define "header.part.csv"}}id,name{{end}}`,
+		"export.tmpl.csv": `{{template "header.part.csv"}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tm.templateDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write template %s: %v", name, err)
+		}
+	}
+	if err := tm.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"api.tmpl.json":   `{"title": "\u003cb\u003e\"quoted\"\u003c/b\u003e"}`,
+		"feed.tmpl.rss":   `<rss><item>a &amp; b</item><item>a &amp; b</item></rss>`,
+		"export.tmpl.csv": `id,name`,
+	}
+	for name, expected := range tests {
+		var buf bytes.Buffer
+		if err := tm.Execute(&buf, name, nil); err != nil {
+			t.Fatalf("Execute failed for %s: %v", name, err)
+		}
+		if buf.String() != expected {
+			t.Errorf("%s rendered '%s', expected '%s'", name, buf.String(), expected)
+		}
+	}
+
+	if name := tm.GetRandomTemplateOfKind(KindJSON); name != "api.tmpl.json" {
+		t.Errorf("GetRandomTemplateOfKind(KindJSON) returned '%s'", name)
+	}
+	if name := tm.GetRandomTemplateOfKind(KindAtom); name != "" {
+		t.Errorf("GetRandomTemplateOfKind(KindAtom) should find nothing, got '%s'", name)
+	}
+	if name := tm.GetRandomTemplate(); name != "dummy.tmpl.html" {
+		t.Errorf("GetRandomTemplate should only pick HTML templates, got '%s'", name)
+	}
+	if !containsString(tm.GetTemplateNames(), "header.part.csv") {
+		t.Error("GetTemplateNames should include non-HTML partials")
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	tests := map[string]ContentKind{
+		"page.tmpl.html":   KindHTML,
+		"feed.tmpl.rss":    KindRSS,
+		"header.part.json": KindJSON,
+		"content":          KindHTML,
+		"notes.tmpl.md":    KindHTML,
+	}
+	for name, expected := range tests {
+		if kind := KindOf(name); kind != expected {
+			t.Errorf("KindOf(%q) = %q, expected %q", name, kind, expected)
+		}
+	}
+	if KindCSV.ContentType() != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected content type for CSV: %s", KindCSV.ContentType())
+	}
+}
+
 func TestManager_SetConfig(t *testing.T) {
 	tm := setupTestManager(t)
 	newConfig := DefaultConfig()
@@ -0,0 +1,147 @@
+package templating
+
+import (
+	"html/template"
+	"math/rand/v2"
+	texttemplate "text/template"
+)
+
+// RenderOption configures a single render. It is used as a variadic argument to
+// Execute and GenerateFiller.
+type RenderOption func(*renderOptions)
+
+type renderOptions struct {
+	rng *rand.Rand
+}
+
+func newRenderOptions(opts []RenderOption) *renderOptions {
+	options := &renderOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithRand sets the random source used by every template function during a render.
+// Passing a source seeded from the request (e.g. a hash of its URL) makes a template
+// render identically every time it is requested with the same seed. A nil source
+// is ignored, and each render gets its own randomly seeded source by default.
+func WithRand(r *rand.Rand) RenderOption {
+	return func(o *renderOptions) { o.rng = r }
+}
+
+// renderer holds the per-render state of the template functions. Every function that
+// needs randomness is a method on renderer and draws from its rng, rather than from the
+// global math/rand/v2 source, so the output of a render depends only on its seed.
+type renderer struct {
+	tm  *TemplateManager
+	rng *rand.Rand
+}
+
+// newRenderer creates a renderer for the given manager. If rng is nil, a new randomly
+// seeded source is used.
+func newRenderer(tm *TemplateManager, rng *rand.Rand) *renderer {
+	if rng == nil {
+		rng = newRand()
+	}
+	return &renderer{tm: tm, rng: rng}
+}
+
+// newRand returns a new source seeded from the global source.
+func newRand() *rand.Rand {
+	return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+}
+
+// renderSet is a copy of the template sets whose functions are bound to its own renderer.
+// html/template escapes each template the first time it is executed, so sets are pooled
+// and reused across renders rather than cloned for every request. A set is only ever
+// used by one render at a time.
+type renderSet struct {
+	templates *template.Template
+	text      *texttemplate.Template
+	renderer  *renderer
+}
+
+// newRenderSet clones the clean, unexecuted HTML and text template sets and binds them
+// to a fresh renderer.
+func (tm *TemplateManager) newRenderSet(clean *template.Template, cleanText *texttemplate.Template) (*renderSet, error) {
+	templates, err := clean.Clone()
+	if err != nil {
+		return nil, err
+	}
+	text, err := cleanText.Clone()
+	if err != nil {
+		return nil, err
+	}
+	r := newRenderer(tm, nil)
+	funcs := r.funcMap()
+	templates.Funcs(funcs)
+	text.Funcs(texttemplate.FuncMap(funcs))
+	return &renderSet{templates: templates, text: text, renderer: r}, nil
+}
+
+func (r *renderer) funcMap() template.FuncMap {
+	return template.FuncMap{
+		// Content Generation (from funcs_content.go)
+		"markovSentence":   r.markovSentence,
+		"markovParagraphs": r.markovParagraphs,
+		"markovStream":     r.markovStream,
+		"randomWord":       r.randomWord,
+		"randomSentence":   r.randomSentence,
+		"randomParagraphs": r.randomParagraphs,
+		"randomString":     r.randomString,
+		"randomDate":       r.randomDate,
+		"randomJSON":       r.randomJSON,
+
+		// Data Formats (from funcs_data.go)
+		"feedItems": r.feedItems,
+		"randomCSV": r.randomCSV,
+		"toJSON":    toJSON,
+		"xmlEscape": xmlEscape,
+		"csvField":  csvField,
+
+		// Structure & Composition (from funcs_structure.go)
+		"randomForm":           r.randomForm,
+		"randomDefinitionData": r.randomDefinitionData,
+		"nestDivs":             r.nestDivs,
+		"randomComplexTable":   r.randomComplexTable,
+
+		// Styling (from funcs_styling.go)
+		"randomColor":       r.randomColor,
+		"randomId":          r.randomId,
+		"randomClasses":     r.randomClasses,
+		"randomCSSStyle":    r.randomCSSStyle,
+		"randomInlineStyle": r.randomInlineStyle,
+
+		// Link & Navigation (from funcs_links.go)
+		"randomLink":      r.randomLink,
+		"randomQueryLink": r.randomQueryLink,
+
+		// Logic & Control (from funcs_logic.go)
+		"repeat":       repeat,
+		"list":         list,
+		"randomChoice": r.randomChoice,
+		"randomInt":    r.randomInt,
+
+		// Simple (from funcs_simple.go)
+		"add":   add,
+		"sub":   sub,
+		"div":   div,
+		"mult":  mult,
+		"max":   max,
+		"min":   min,
+		"mod":   mod,
+		"inc":   inc,
+		"dec":   dec,
+		"and":   and,
+		"or":    or,
+		"not":   not,
+		"isSet": isSet,
+
+		// Computationally Expensive (from funcs_expensive.go)
+		"randomStyleBlock":     r.randomStyleBlock,
+		"randomCSSVars":        r.randomCSSVars,
+		"randomSVG":            r.randomSVG,
+		"jsInteractiveContent": r.jsInteractiveContent,
+	}
+}